@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thyyl/chatr/pkg/chat"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+)
+
+var chatBackfillCommand = &cobra.Command{
+	Use:   "chat-backfill",
+	Short: "Backfill the channel list of every user and number the stored messages of every channel",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := config.NewConfig()
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+
+		session, err := infra.NewCassandraSession(config)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		defer session.Close()
+
+		backfilled, err := chat.BackfillUserChannels(context.Background(), session)
+		if err != nil {
+			slog.Error(err.Error(), slog.Int("backfilled", backfilled))
+			os.Exit(1)
+		}
+		slog.Info("user channels backfilled", slog.Int("backfilled", backfilled))
+
+		numbered, err := chat.BackfillMessageSeqs(context.Background(), session)
+		if err != nil {
+			slog.Error(err.Error(), slog.Int("numbered", numbered))
+			os.Exit(1)
+		}
+		slog.Info("message sequences backfilled", slog.Int("numbered", numbered))
+	},
+}
+
+func init() {
+	rootCommand.AddCommand(chatBackfillCommand)
+}
@@ -52,6 +52,9 @@ func InitializeChatServer(name string) (*common.Server, error) {
 		chat.NewChatRepoCacheImpl,
 		wire.Bind(new(chat.ChatRepoCache), new(*chat.ChatRepoCacheImpl)),
 
+		chat.NewMessageIndex,
+		chat.NewTypingTracker,
+
 		chat.NewMelodyChat,
 		chat.NewMessageSubscriber,
 
@@ -93,13 +96,21 @@ func InitializeForwarderServer(name string) (*common.Server, error) {
 		infra.NewKafkaSubscriber,
 		infra.NewBrokerRouter,
 
+		forwarder.NewChatClientConn,
+
 		forwarder.NewForwarderRepoImpl,
 		wire.Bind(new(forwarder.ForwarderRepo), new(*forwarder.ForwarderRepoImpl)),
+		forwarder.NewChannelRepoImpl,
+		wire.Bind(new(forwarder.ChannelRepo), new(*forwarder.ChannelRepoImpl)),
+
+		forwarder.NewSinks,
+		forwarder.NewDeliveryRateLimiter,
 
 		forwarder.NewForwarderServiceImpl,
 		wire.Bind(new(forwarder.ForwarderService), new(*forwarder.ForwarderServiceImpl)),
 
 		forwarder.NewMessageSubscriber,
+		forwarder.NewDeliverySubscriber,
 
 		forwarder.NewGrpcServer,
 		wire.Bind(new(common.GrpcServer), new(*forwarder.GrpcServer)),
@@ -188,13 +199,28 @@ func InitializeUserServer(name string) (*common.Server, error) {
 		infra.NewRedisCacheImpl,
 		wire.Bind(new(infra.RedisCache), new(*infra.RedisCacheImpl)),
 
+		infra.NewKafkaPublisher,
+		infra.NewKafkaSubscriber,
+		infra.NewBrokerRouter,
+
+		user.NewChatClientConn,
+		user.NewMelodyNotificationConn,
+
 		user.NewUserRepoImpl,
 		wire.Bind(new(user.UserRepo), new(*user.UserRepoImpl)),
+		user.NewChannelRepoImpl,
+		wire.Bind(new(user.ChannelRepo), new(*user.ChannelRepoImpl)),
+		user.NewNotificationRepoImpl,
+		wire.Bind(new(user.NotificationRepo), new(*user.NotificationRepoImpl)),
 
 		common.NewSonyFlake,
 
 		user.NewUserServiceImpl,
 		wire.Bind(new(user.UserService), new(*user.UserServiceImpl)),
+		user.NewNotificationServiceImpl,
+		wire.Bind(new(user.NotificationService), new(*user.NotificationServiceImpl)),
+
+		user.NewNotificationSubscriber,
 
 		user.NewGinServer,
 
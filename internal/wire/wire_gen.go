@@ -56,22 +56,24 @@ func InitializeChatServer(name string) (*common.Server, error) {
 		return nil, err
 	}
 	userRepoImpl := chat.NewUserRepoImpl(session, userClientConn)
-	userRepoCacheImpl := chat.NewUserRepoCacheImpl(redisCacheImpl, userRepoImpl)
+	userRepoCacheImpl := chat.NewUserRepoCacheImpl(redisCacheImpl, userRepoImpl, configConfig)
 	userServiceImpl := chat.NewUserServiceImpl(userRepoCacheImpl)
 	publisher, err := infra.NewKafkaPublisher(configConfig)
 	if err != nil {
 		return nil, err
 	}
 	chatRepoImpl := chat.NewChatRepoImpl(session, publisher, configConfig)
-	chatRepoCacheImpl := chat.NewChatRepoCacheImpl(chatRepoImpl)
+	chatRepoCacheImpl := chat.NewChatRepoCacheImpl(redisCacheImpl, chatRepoImpl, configConfig)
+	messageIndex := chat.NewMessageIndex(configConfig, redisCacheImpl)
+	typingTracker := chat.NewTypingTracker(configConfig, httpLog)
 	idGenerator, err := common.NewSonyFlake()
 	if err != nil {
 		return nil, err
 	}
-	chatServiceImpl := chat.NewChatServiceImpl(chatRepoCacheImpl, userRepoCacheImpl, idGenerator)
 	channelRepoImpl := chat.NewChannelRepoImpl(session)
 	channelRepoCacheImpl := chat.NewChannelRepoCacheImpl(redisCacheImpl, channelRepoImpl)
-	channelServiceImpl := chat.NewChannelServiceImpl(channelRepoCacheImpl, userRepoCacheImpl, idGenerator)
+	chatServiceImpl := chat.NewChatServiceImpl(chatRepoCacheImpl, userRepoCacheImpl, channelRepoCacheImpl, messageIndex, typingTracker, idGenerator, configConfig, httpLog)
+	channelServiceImpl := chat.NewChannelServiceImpl(channelRepoCacheImpl, userRepoCacheImpl, messageIndex, idGenerator)
 	forwarderClientConn, err := chat.NewForwarderClientConn(configConfig)
 	if err != nil {
 		return nil, err
@@ -109,7 +111,14 @@ func InitializeForwarderServer(name string) (*common.Server, error) {
 		return nil, err
 	}
 	forwarderRepoImpl := forwarder.NewForwarderRepoImpl(redisCacheImpl, publisher)
-	forwarderServiceImpl := forwarder.NewForwarderServiceImpl(forwarderRepoImpl)
+	chatClientConn, err := forwarder.NewChatClientConn(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	channelRepoImpl := forwarder.NewChannelRepoImpl(chatClientConn)
+	sinks := forwarder.NewSinks(configConfig, publisher)
+	deliveryRateLimiter := forwarder.NewDeliveryRateLimiter(universalClient, configConfig)
+	forwarderServiceImpl := forwarder.NewForwarderServiceImpl(forwarderRepoImpl, channelRepoImpl, sinks, deliveryRateLimiter, configConfig)
 	router, err := infra.NewBrokerRouter(name)
 	if err != nil {
 		return nil, err
@@ -119,7 +128,11 @@ func InitializeForwarderServer(name string) (*common.Server, error) {
 		return nil, err
 	}
 	messageSubscriber := forwarder.NewMessageSubscriber(router, subscriber, forwarderServiceImpl)
-	grpcServer := forwarder.NewGrpcServer(name, grpcLog, configConfig, forwarderServiceImpl, messageSubscriber)
+	deliverySubscriber, err := forwarder.NewDeliverySubscriber(name, grpcLog, configConfig, forwarderServiceImpl)
+	if err != nil {
+		return nil, err
+	}
+	grpcServer := forwarder.NewGrpcServer(name, grpcLog, configConfig, forwarderServiceImpl, messageSubscriber, deliverySubscriber)
 	forwarderRouter := forwarder.NewRouter(grpcServer)
 	infraCloser := forwarder.NewInfraCloser()
 	server := common.NewServer(name, forwarderRouter, infraCloser)
@@ -155,7 +168,7 @@ func InitializeMatchServer(name string) (*common.Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	matchSubscriber := match.NewMatchSubscriber(name, melodyMatchConn, router, userServiceImpl, subscriber)
+	matchSubscriber := match.NewMatchSubscriber(name, melodyMatchConn, router, subscriber)
 	universalClient, err := infra.NewRedisClient(configConfig)
 	if err != nil {
 		return nil, err
@@ -165,9 +178,9 @@ func InitializeMatchServer(name string) (*common.Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	matchRepoImpl := match.NewMatchRepoImpl(redisCacheImpl, publisher)
+	matchRepoImpl := match.NewMatchRepoImpl(redisCacheImpl, publisher, configConfig)
 	channelRepoImpl := match.NewChannelRepoImpl(chatClientConn)
-	matchServiceImpl := match.NewMatchServiceImpl(matchRepoImpl, channelRepoImpl)
+	matchServiceImpl := match.NewMatchServiceImpl(matchRepoImpl, channelRepoImpl, userRepoImpl, configConfig)
 	httpServer := match.NewHttpServer(name, httpLog, configConfig, engine, melodyMatchConn, matchSubscriber, userServiceImpl, matchServiceImpl)
 	matchRouter := match.NewRouter(httpServer)
 	infraCloser := match.NewInfraCloser()
@@ -207,25 +220,46 @@ func InitializeUserServer(name string) (*common.Server, error) {
 		return nil, err
 	}
 	engine := user.NewGinServer(name, httpLog, configConfig)
+	melodyNotificationConn := user.NewMelodyNotificationConn()
+	router, err := infra.NewBrokerRouter(name)
+	if err != nil {
+		return nil, err
+	}
 	universalClient, err := infra.NewRedisClient(configConfig)
 	if err != nil {
 		return nil, err
 	}
 	redisCacheImpl := infra.NewRedisCacheImpl(universalClient)
+	publisher, err := infra.NewKafkaPublisher(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	notificationRepoImpl := user.NewNotificationRepoImpl(redisCacheImpl, publisher, configConfig)
+	notificationServiceImpl := user.NewNotificationServiceImpl(notificationRepoImpl, configConfig)
+	subscriber, err := infra.NewKafkaSubscriber(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	notificationSubscriber := user.NewNotificationSubscriber(melodyNotificationConn, router, notificationServiceImpl, subscriber)
 	userRepoImpl := user.NewUserRepoImpl(redisCacheImpl)
+	chatClientConn, err := user.NewChatClientConn(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	channelRepoImpl := user.NewChannelRepoImpl(chatClientConn)
 	idGenerator, err := common.NewSonyFlake()
 	if err != nil {
 		return nil, err
 	}
-	userServiceImpl := user.NewUserServiceImpl(userRepoImpl, idGenerator)
-	httpServer := user.NewHttpServer(name, httpLog, configConfig, engine, userServiceImpl)
+	userServiceImpl := user.NewUserServiceImpl(userRepoImpl, channelRepoImpl, notificationRepoImpl, idGenerator, httpLog)
+	httpServer := user.NewHttpServer(name, httpLog, configConfig, engine, melodyNotificationConn, notificationSubscriber, userServiceImpl, notificationServiceImpl)
 	grpcLog, err := common.NewGrpcLog(configConfig)
 	if err != nil {
 		return nil, err
 	}
 	grpcServer := user.NewGrpcServer(name, configConfig, grpcLog, userServiceImpl)
-	router := user.NewRouter(httpServer, grpcServer)
+	userRouter := user.NewRouter(httpServer, grpcServer)
 	infraCloser := user.NewInfraCloser()
-	server := common.NewServer(name, router, infraCloser)
+	server := common.NewServer(name, userRouter, infraCloser)
 	return server, nil
 }
@@ -2,6 +2,7 @@ package chat
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/thyyl/chatr/pkg/common"
@@ -54,12 +55,94 @@ func (s *MessageSubscriber) GracefulStop() error {
 }
 
 func (s *MessageSubscriber) sendMessage(ctx context.Context, message *Message) error {
+	if err := s.broadcastMessage(message); err != nil {
+		return err
+	}
+
+	// a member who left or was kicked or banned stops receiving the channel once it has been told why
+	if message.Event == EventAction && Action(message.Payload).RemovesMember() {
+		return s.closeMemberSessions(message.ChannelId, message.UserId)
+	}
+	return nil
+}
+
+func (s *MessageSubscriber) broadcastMessage(message *Message) error {
 	return s.melodyChatConn.BroadcastFilter(message.ToPresenter().Encode(), func(session *melody.Session) bool {
 		channelId, exist := session.Get(common.SessionCidKey)
 		if !exist {
 			return false
 		}
 
-		return message.ChannelId == (channelId.(uint64))
+		if message.ChannelId != (channelId.(uint64)) {
+			return false
+		}
+
+		if buffer, exist := session.Get(common.SessionReplayKey); exist {
+			return !buffer.(*replayBuffer).hold(message)
+		}
+		return true
+	})
+}
+
+// closeMemberSessions closes the sessions of the user in the channel. The hub handles broadcasts in order,
+// so the close is queued behind the message broadcast right before it.
+func (s *MessageSubscriber) closeMemberSessions(channelId uint64, userId uint64) error {
+	return s.melodyChatConn.BroadcastFilter(nil, func(session *melody.Session) bool {
+		sessionChannelId, exist := session.Get(common.SessionCidKey)
+		if !exist || sessionChannelId.(uint64) != channelId {
+			return false
+		}
+
+		sessionUserId, exist := session.Get(common.SessionUidKey)
+		if exist && sessionUserId.(uint64) == userId {
+			session.Close()
+		}
+		return false
 	})
 }
+
+// replayBuffer holds the live messages of a reconnecting session until its missed messages are replayed
+type replayBuffer struct {
+	mu       sync.Mutex
+	live     bool
+	messages []*Message
+}
+
+func newReplayBuffer() *replayBuffer {
+	return &replayBuffer{}
+}
+
+// hold keeps the message for later unless the session has already switched to live delivery
+func (b *replayBuffer) hold(message *Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.live {
+		return false
+	}
+	b.messages = append(b.messages, message)
+	return true
+}
+
+// flush writes the held messages that were not part of the replay and switches the session to live delivery.
+// The lock is kept while writing so that broadcasts arriving meanwhile are queued behind the held messages.
+func (b *replayBuffer) flush(session *melody.Session, replayed map[uint64]struct{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for _, message := range b.messages {
+		if message.Event == EventText || message.Event == EventFile {
+			if _, ok := replayed[message.MessageId]; ok {
+				continue
+			}
+		}
+		if err == nil {
+			err = session.Write(message.ToPresenter().Encode())
+		}
+	}
+
+	b.live = true
+	b.messages = nil
+	return err
+}
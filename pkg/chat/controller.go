@@ -4,22 +4,24 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/thyyl/chatr/pkg/common"
 	"gopkg.in/olahol/melody.v1"
 )
 
 func (s *HttpServer) StartChat(ctx *gin.Context) {
-	uid := ctx.Query("uid")
-	userId, err := strconv.ParseUint(uid, 10, 64)
-	if err != nil {
-		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
 		return
 	}
 
-	_, err = s.userService.GetUser(ctx.Request.Context(), userId)
+	_, err := s.userService.GetUser(ctx.Request.Context(), userId)
 	if err != nil {
 		if errors.Is(err, common.ErrorUserNotFound) {
 			common.Response(ctx, http.StatusNotFound, common.ErrorUserNotFound)
@@ -30,6 +32,11 @@ func (s *HttpServer) StartChat(ctx *gin.Context) {
 		return
 	}
 
+	if _, err := parseLastMessageId(ctx.Query("last")); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
 	accessToken := ctx.Query("access_token")
 	authResult, err := common.Auth(&common.AuthPayload{
 		AccessToken: accessToken,
@@ -113,15 +120,113 @@ func (s *HttpServer) GetOnlineUsers(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &UserIdsDto{UserIds: userIdsDto})
 }
 
+func (s *HttpServer) GetLastSeen(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	lastSeens, err := s.userService.GetChannelLastSeen(ctx.Request.Context(), channelId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	lastSeensDto := []LastSeenDto{}
+	for _, lastSeen := range lastSeens {
+		lastSeensDto = append(lastSeensDto, LastSeenDto{
+			UserId:   strconv.FormatUint(lastSeen.UserId, 10),
+			LastSeen: lastSeen.LastSeen,
+		})
+	}
+	ctx.JSON(http.StatusOK, &LastSeensDto{Users: lastSeensDto})
+}
+
+func (s *HttpServer) GetReadCursors(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	cursors, err := s.chatService.GetReadCursors(ctx.Request.Context(), channelId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	cursorDtos := []ReadCursorDto{}
+	for _, cursor := range cursors {
+		cursorDtos = append(cursorDtos, ReadCursorDto{
+			UserId:    strconv.FormatUint(cursor.UserId, 10),
+			MessageId: strconv.FormatUint(cursor.MessageId, 10),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &ReadCursorsDto{Cursors: cursorDtos})
+}
+
+func (s *HttpServer) ListUserChannels(ctx *gin.Context) {
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	userChannels, err := s.chatService.ListUserChannels(ctx.Request.Context(), userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	userChannelDtos := []UserChannelDto{}
+	for _, userChannel := range userChannels {
+		var lastMessage *MessageDto
+		if userChannel.LastMessage != nil {
+			lastMessage = userChannel.LastMessage.ToPresenter()
+		}
+
+		userChannelDtos = append(userChannelDtos, UserChannelDto{
+			ChannelId:   strconv.FormatUint(userChannel.Id, 10),
+			Encrypted:   userChannel.Encrypted,
+			AccessToken: userChannel.AccessToken,
+			UnreadCount: userChannel.UnreadCount,
+			LastMessage: lastMessage,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, &UserChannelsDto{Channels: userChannelDtos})
+}
+
 func (s *HttpServer) ListMessages(ctx *gin.Context) {
 	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
 	if !ok {
 		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
 		return
 	}
+	viewerId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
 
-	pageState := ctx.Query("ps")
-	messages, nextPageState, err := s.chatService.ListMessages(ctx.Request.Context(), channelId, pageState)
+	var listMessagesRequest ListMessagesRequest
+	if err := ctx.ShouldBindQuery(&listMessagesRequest); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	messages, nextPageState, err := s.chatService.ListMessages(ctx.Request.Context(), channelId, viewerId, &MessageQuery{
+		PageState: listMessagesRequest.PageState,
+		Before:    listMessagesRequest.Before,
+		After:     listMessagesRequest.After,
+		Since:     listMessagesRequest.Since,
+		Limit:     listMessagesRequest.Limit,
+	})
 	if err != nil {
 		s.logger.Error(err.Error())
 		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
@@ -139,34 +244,103 @@ func (s *HttpServer) ListMessages(ctx *gin.Context) {
 	})
 }
 
-func (s *HttpServer) DeleteChannel(ctx *gin.Context) {
+func (s *HttpServer) ListReplies(ctx *gin.Context) {
 	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
 	if !ok {
 		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
 		return
 	}
+	viewerId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
 
-	uid := ctx.Query("delby")
-	userId, err := strconv.ParseUint(uid, 10, 64)
+	parentId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
 		return
 	}
 
-	exist, err := s.userService.IsChannelUserExists(ctx.Request.Context(), channelId, userId)
+	pageState := ctx.Query("ps")
+	messages, nextPageState, err := s.chatService.ListReplies(ctx.Request.Context(), channelId, viewerId, parentId, pageState)
 	if err != nil {
 		s.logger.Error(err.Error())
 		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
 		return
 	}
 
-	if !exist {
-		common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+	messageDtos := []MessageDto{}
+	for _, message := range messages {
+		messageDtos = append(messageDtos, *message.ToPresenter())
+	}
+
+	ctx.JSON(http.StatusOK, &MessagesDto{
+		Messages:      messageDtos,
+		NextPageState: nextPageState,
+	})
+}
+
+func (s *HttpServer) SearchMessages(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	query := ctx.Query("q")
+	if query == "" {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
 		return
 	}
 
-	err = s.channelService.DeleteChannel(ctx.Request.Context(), channelId)
+	var limit int
+	if limitQuery := ctx.Query("limit"); limitQuery != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitQuery); err != nil {
+			common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+			return
+		}
+	}
+
+	messages, err := s.chatService.SearchMessages(ctx.Request.Context(), channelId, query, limit)
 	if err != nil {
+		if errors.Is(err, common.ErrorChannelEncrypted) {
+			common.Response(ctx, http.StatusBadRequest, common.ErrorChannelEncrypted)
+			return
+		}
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	messageDtos := []MessageDto{}
+	for _, message := range messages {
+		messageDtos = append(messageDtos, *message.ToPresenter())
+	}
+
+	ctx.JSON(http.StatusOK, &SearchMessagesDto{Messages: messageDtos})
+}
+
+func (s *HttpServer) DeleteChannel(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	if err := s.channelService.AuthorizeDeletion(ctx.Request.Context(), channelId, userId); err != nil {
+		s.responseChannelError(ctx, err)
+		return
+	}
+
+	if err := s.channelService.DeleteChannel(ctx.Request.Context(), channelId); err != nil {
 		s.logger.Error(err.Error())
 		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
 		return
@@ -177,120 +351,846 @@ func (s *HttpServer) DeleteChannel(ctx *gin.Context) {
 	})
 }
 
-func (s *HttpServer) HandleChatOnConnect(session *melody.Session) {
-	uid := session.Request.URL.Query().Get("uid")
-	userId, err := strconv.ParseUint(uid, 10, 64)
-	if err != nil {
-		s.logger.Error(err.Error())
+func (s *HttpServer) CreateGroupChannel(ctx *gin.Context) {
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
 		return
 	}
 
-	accessToken := session.Request.URL.Query().Get("access_token")
-	authResult, err := common.Auth(&common.AuthPayload{
-		AccessToken: accessToken,
-	})
+	var request CreateGroupChannelRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
 
+	inviteeIds, err := parseUserIds(request.UserIds)
 	if err != nil {
-		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
 	}
-	if authResult.Expired {
-		s.logger.Error(common.ErrorTokenExpired.Error())
+	inviteeIds = slices.DeleteFunc(inviteeIds, func(inviteeId uint64) bool {
+		return inviteeId == userId
+	})
+	if len(inviteeIds)+1 > s.maxMembers {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorExceedChannelMembers)
+		return
+	}
+	if !s.checkUsersExist(ctx, inviteeIds) {
+		return
 	}
 
-	channelId := authResult.ChannelId
-	err = s.initializeChatSession(session, channelId, userId)
+	channel, err := s.channelService.CreateChannel(ctx.Request.Context(), request.Name, request.Encrypted)
 	if err != nil {
 		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
 		return
 	}
 
-	if err := s.chatService.BroadcastConnectMessage(context.Background(), channelId, userId); err != nil {
+	if err := s.userService.AddUserToChannel(ctx.Request.Context(), channel.Id, userId, OwnerRole); err != nil {
 		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
 		return
 	}
+	for _, inviteeId := range inviteeIds {
+		if err := s.userService.AddUserToChannel(ctx.Request.Context(), channel.Id, inviteeId, MemberRole); err != nil {
+			s.logger.Error(err.Error())
+			common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+			return
+		}
+	}
+	if err := s.chatService.NotifyInvitees(ctx.Request.Context(), channel, userId, inviteeIds); err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	ctx.JSON(http.StatusCreated, &ChannelDto{
+		ChannelId:   strconv.FormatUint(channel.Id, 10),
+		Name:        channel.Name,
+		Encrypted:   channel.Encrypted,
+		AccessToken: channel.AccessToken,
+	})
 }
 
-func (s *HttpServer) initializeChatSession(session *melody.Session, channelID, userID uint64) error {
-	ctx := context.Background()
-	if err := s.userService.AddOnlineUser(ctx, channelID, userID); err != nil {
-		return err
+func (s *HttpServer) InviteMembers(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
 	}
-	if err := s.forwarderService.RegisterChannelSession(ctx, channelID, userID, s.messageSubscriber.subscriberId); err != nil {
-		return err
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
 	}
-	session.Set(common.SessionCidKey, channelID)
-	return nil
-}
 
-func (s *HttpServer) HandleChatOnMessage(session *melody.Session, data []byte) {
-	chatMessageDto, err := DecodeToMessageDto(data)
+	var request InviteMembersRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+	inviteeIds, err := parseUserIds(request.UserIds)
 	if err != nil {
-		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
 		return
 	}
 
-	message, err := chatMessageDto.ToMessage(session.Request.URL.Query().Get("access_token"))
+	channelMembers, err := s.channelService.GetChannelMembers(ctx.Request.Context(), channelId)
 	if err != nil {
 		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+	if channelMembers.Name == "" {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorNotGroupChannel)
 		return
 	}
 
-	switch message.Event {
-	case EventText:
-		if err := s.chatService.BroadcastTextMessage(context.Background(), message.ChannelId, message.UserId, message.Payload); err != nil {
-			s.logger.Error(err.Error())
-		}
-	case EventAction:
-		if err := s.chatService.BroadcastActionMessage(context.Background(), message.ChannelId, message.UserId, Action(message.Payload)); err != nil {
-			s.logger.Error(err.Error())
-		}
-	case EventSeen:
-		messageId, err := strconv.ParseUint(message.Payload, 10, 64)
+	memberIds := make(map[uint64]struct{})
+	for _, member := range channelMembers.Members {
+		memberIds[member.UserId] = struct{}{}
+	}
+	if _, ok := memberIds[userId]; !ok {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+		return
+	}
+
+	inviteeIds = slices.DeleteFunc(inviteeIds, func(inviteeId uint64) bool {
+		_, ok := memberIds[inviteeId]
+		return ok
+	})
+	if len(memberIds)+len(inviteeIds) > s.maxMembers {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorExceedChannelMembers)
+		return
+	}
+	if !s.checkUsersExist(ctx, inviteeIds) {
+		return
+	}
+	for _, inviteeId := range inviteeIds {
+		banned, err := s.channelService.IsUserBanned(ctx.Request.Context(), channelId, inviteeId)
 		if err != nil {
 			s.logger.Error(err.Error())
+			common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
 			return
 		}
-
-		if err := s.chatService.MarkMessageSeen(context.Background(), message.ChannelId, message.UserId, messageId); err != nil {
-			s.logger.Error(err.Error())
+		if banned {
+			common.Response(ctx, http.StatusForbidden, common.ErrorUserBanned)
+			return
 		}
-	case EventFile:
-		if err := s.chatService.BroadcastFileMessage(context.Background(), message.ChannelId, message.UserId, message.Payload); err != nil {
+	}
+
+	for _, inviteeId := range inviteeIds {
+		if err := s.userService.AddUserToChannel(ctx.Request.Context(), channelId, inviteeId, MemberRole); err != nil {
 			s.logger.Error(err.Error())
+			common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+			return
 		}
-	default:
-		s.logger.Error("unknown event type")
 	}
+	channel := &Channel{Id: channelId, Name: channelMembers.Name}
+	if err := s.chatService.NotifyInvitees(ctx.Request.Context(), channel, userId, inviteeIds); err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	ctx.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
 }
 
-func (s *HttpServer) HandleChatOnClose(session *melody.Session, i int, str string) error {
-	userID, err := strconv.ParseUint(session.Request.URL.Query().Get("uid"), 10, 64)
+func (s *HttpServer) ListChannelMembers(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	channelMembers, err := s.channelService.GetChannelMembers(ctx.Request.Context(), channelId)
 	if err != nil {
 		s.logger.Error(err.Error())
-		return err
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
 	}
-	accessToken := session.Request.URL.Query().Get("access_token")
-	authResult, err := common.Auth(&common.AuthPayload{
-		AccessToken: accessToken,
+
+	membersDto := []MemberDto{}
+	for _, member := range channelMembers.Members {
+		membersDto = append(membersDto, MemberDto{
+			UserId: strconv.FormatUint(member.UserId, 10),
+			Role:   string(member.Role),
+		})
+	}
+	ctx.JSON(http.StatusOK, &ChannelMembersDto{
+		ChannelId: strconv.FormatUint(channelId, 10),
+		Name:      channelMembers.Name,
+		Encrypted: channelMembers.Encrypted,
+		Members:   membersDto,
 	})
-	if err != nil {
-		s.logger.Error(err.Error())
-		return err
+}
+
+// checkUsersExist writes the error response and returns false when any of the users does not exist
+func (s *HttpServer) checkUsersExist(ctx *gin.Context, userIds []uint64) bool {
+	for _, userId := range userIds {
+		if _, err := s.userService.GetUser(ctx.Request.Context(), userId); err != nil {
+			if errors.Is(err, common.ErrorUserNotFound) {
+				common.Response(ctx, http.StatusNotFound, common.ErrorUserNotFound)
+				return false
+			}
+			s.logger.Error(err.Error())
+			common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+			return false
+		}
 	}
-	if authResult.Expired {
-		s.logger.Error(common.ErrorTokenExpired.Error())
-		return common.ErrorTokenExpired
+	return true
+}
+
+func (s *HttpServer) LeaveChannel(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
 	}
-	channelID := authResult.ChannelId
-	err = s.userService.DeleteOnlineUser(context.Background(), channelID, userID)
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	exist, err := s.userService.IsChannelUserExists(ctx.Request.Context(), channelId, userId)
 	if err != nil {
 		s.logger.Error(err.Error())
-		return err
-	}
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	if !exist {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+		return
+	}
+
+	if err := s.leaveChannel(ctx.Request.Context(), channelId, userId); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+// leaveChannel removes a single member and deletes the channel once nobody is left in it
+func (s *HttpServer) leaveChannel(ctx context.Context, channelId uint64, userId uint64) error {
+	return s.removeMember(ctx, channelId, userId, LeavedMessage)
+}
+
+// removeMember takes the user out of the channel and broadcasts why with the given action. The broadcast also
+// closes the open sessions of the user on every server.
+func (s *HttpServer) removeMember(ctx context.Context, channelId uint64, userId uint64, action Action) error {
+	remaining, err := s.userService.RemoveUserFromChannel(ctx, channelId, userId)
+	if err != nil {
+		return err
+	}
+	if err := s.userService.DeleteOnlineUser(ctx, channelId, userId); err != nil {
+		return err
+	}
+	if err := s.chatService.BroadcastActionMessage(ctx, channelId, userId, action); err != nil {
+		return err
+	}
+	if err := s.forwarderService.RemoveChannelSession(ctx, channelId, userId); err != nil {
+		return err
+	}
+
+	if remaining == 0 {
+		return s.channelService.DeleteChannel(ctx, channelId)
+	}
+	return nil
+}
+
+func (s *HttpServer) ReportChannel(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var request ReportChannelRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	exist, err := s.userService.IsChannelUserExists(ctx.Request.Context(), channelId, userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	if !exist {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+		return
+	}
+
+	report, err := s.chatService.ReportChannel(ctx.Request.Context(), channelId, userId, request.Reason, s.reportEvidenceNum)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, &ReportDto{
+		ReportId: strconv.FormatUint(report.Id, 10),
+	})
+}
+
+// SendFriendRequest lets either member of a matched channel ask the other to become friends. The channel learns
+// about the request, and about the friendship once both members asked.
+func (s *HttpServer) SendFriendRequest(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	userId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	peerId, friends, err := s.channelService.SendFriendRequest(ctx.Request.Context(), channelId, userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorChannelOrUserNotFound):
+			common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+		case errors.Is(err, common.ErrorNotMatchedChannel):
+			common.Response(ctx, http.StatusBadRequest, common.ErrorNotMatchedChannel)
+		case errors.Is(err, common.ErrorPermissionDenied):
+			common.Response(ctx, http.StatusForbidden, common.ErrorPermissionDenied)
+		default:
+			s.logger.Error(err.Error())
+			common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		}
+		return
+	}
+
+	action := FriendRequestMessage
+	if friends {
+		action = BefriendedMessage
+	}
+	if err := s.chatService.BroadcastActionMessage(ctx.Request.Context(), channelId, userId, action); err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	ctx.JSON(http.StatusOK, &FriendRequestDto{
+		PeerId:  strconv.FormatUint(peerId, 10),
+		Friends: friends,
+	})
+}
+
+func (s *HttpServer) KickMember(ctx *gin.Context) {
+	channelId, targetId, ok := s.authorizeModeration(ctx)
+	if !ok {
+		return
+	}
+
+	if err := s.removeMember(ctx.Request.Context(), channelId, targetId, KickedMessage); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) BanMember(ctx *gin.Context) {
+	channelId, targetId, ok := s.authorizeModeration(ctx)
+	if !ok {
+		return
+	}
+
+	if err := s.channelService.BanUser(ctx.Request.Context(), channelId, targetId); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+	if err := s.removeMember(ctx.Request.Context(), channelId, targetId, BannedMessage); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) MuteMember(ctx *gin.Context) {
+	var request MuteMemberRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	channelId, targetId, ok := s.authorizeModeration(ctx)
+	if !ok {
+		return
+	}
+
+	mutedUntil, err := s.channelService.MuteMember(ctx.Request.Context(), channelId, targetId, time.Duration(request.DurationSecond)*time.Second)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+	if err := s.chatService.BroadcastActionMessage(ctx.Request.Context(), channelId, targetId, MutedMessage); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &MuteDto{
+		UserId:     strconv.FormatUint(targetId, 10),
+		MutedUntil: mutedUntil,
+	})
+}
+
+func (s *HttpServer) SetMemberRole(ctx *gin.Context) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	actorId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+	targetId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	var request SetMemberRoleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	role := Role(request.Role)
+	if err := s.channelService.SetMemberRole(ctx.Request.Context(), channelId, actorId, targetId, role); err != nil {
+		s.responseChannelError(ctx, err)
+		return
+	}
+
+	action := PromotedMessage
+	if role == MemberRole {
+		action = DemotedMessage
+	}
+	if err := s.chatService.BroadcastActionMessage(ctx.Request.Context(), channelId, targetId, action); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+// authorizeModeration reads the channel, the acting user of the session and the moderated member of a moderation request.
+// It writes the error response and returns false when the actor may not moderate the member.
+func (s *HttpServer) authorizeModeration(ctx *gin.Context) (uint64, uint64, bool) {
+	channelId, ok := ctx.Request.Context().Value(common.ChannelKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return 0, 0, false
+	}
+
+	actorId, ok := ctx.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(ctx, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return 0, 0, false
+	}
+	targetId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return 0, 0, false
+	}
+
+	if err := s.channelService.AuthorizeModeration(ctx.Request.Context(), channelId, actorId, targetId); err != nil {
+		s.responseChannelError(ctx, err)
+		return 0, 0, false
+	}
+	return channelId, targetId, true
+}
+
+// responseChannelError writes the response for a failed channel permission check
+func (s *HttpServer) responseChannelError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, common.ErrorPermissionDenied):
+		common.Response(ctx, http.StatusForbidden, common.ErrorPermissionDenied)
+	case errors.Is(err, common.ErrorNotGroupChannel):
+		common.Response(ctx, http.StatusBadRequest, common.ErrorNotGroupChannel)
+	case errors.Is(err, common.ErrorChannelOrUserNotFound):
+		common.Response(ctx, http.StatusBadRequest, common.ErrorChannelOrUserNotFound)
+	case errors.Is(err, common.ErrorInvalidParam):
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+	default:
+		s.logger.Error(err.Error())
+		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
+	}
+}
+
+func (s *HttpServer) HandleChatOnConnect(session *melody.Session) {
+	userId, ok := session.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		s.logger.Error("user session not found")
+		return
+	}
+
+	accessToken := session.Request.URL.Query().Get("access_token")
+	authResult, err := common.Auth(&common.AuthPayload{
+		AccessToken: accessToken,
+	})
+
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	if authResult.Expired {
+		s.logger.Error(common.ErrorTokenExpired.Error())
+	}
+
+	lastMessageId, err := parseLastMessageId(session.Request.URL.Query().Get("last"))
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	// live messages are held back from the moment the session joins the channel until the replay is done
+	var buffer *replayBuffer
+	if lastMessageId != 0 {
+		buffer = newReplayBuffer()
+		session.Set(common.SessionReplayKey, buffer)
+	}
+
+	channelId := authResult.ChannelId
+	presence, err := s.initializeChatSession(session, channelId, userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	if buffer != nil {
+		if err := s.replayMissedMessages(session, buffer, channelId, userId, lastMessageId); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}
+
+	if err := s.chatService.BroadcastConnectMessage(context.Background(), channelId, userId, presence); err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	if err := s.userService.TouchLastSeen(context.Background(), userId); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+func (s *HttpServer) initializeChatSession(session *melody.Session, channelID, userID uint64) (*Presence, error) {
+	ctx := context.Background()
+	sessionId := uuid.New().String()
+	presence, err := s.userService.AddOnlineSession(ctx, channelID, userID, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	session.Set(common.SessionPresenceKey, sessionId)
+	if err := s.forwarderService.RegisterChannelSession(ctx, channelID, userID, s.messageSubscriber.subscriberId); err != nil {
+		return nil, err
+	}
+	session.Set(common.SessionUidKey, userID)
+	session.Set(common.SessionCidKey, channelID)
+	return presence, nil
+}
+
+// HandleChatOnPong treats every pong of a chat session as a heartbeat that keeps the session online
+func (s *HttpServer) HandleChatOnPong(session *melody.Session) {
+	channelId, exist := session.Get(common.SessionCidKey)
+	if !exist {
+		return
+	}
+	sessionId := session.MustGet(common.SessionPresenceKey).(string)
+	userId := session.MustGet(common.SessionUidKey).(uint64)
+
+	presence, err := s.userService.AddOnlineSession(context.Background(), channelId.(uint64), userId, sessionId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.chatService.BroadcastExpiredUsers(context.Background(), channelId.(uint64), presence); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// replayMissedMessages sends the edits and deletions made since lastMessageId, then the messages stored after it in
+// ascending order, then releases the held live messages. Clients that missed more than replayNum messages or
+// changes are told to resync their history instead.
+func (s *HttpServer) replayMissedMessages(session *melody.Session, buffer *replayBuffer, channelId, userId, lastMessageId uint64) error {
+	replayed := make(map[uint64]struct{})
+	defer func() {
+		if err := buffer.flush(session, replayed); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}()
+
+	messages, _, err := s.chatService.ListMessages(context.Background(), channelId, userId, &MessageQuery{
+		After: lastMessageId,
+		Limit: s.replayNum + 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(messages) > s.replayNum {
+		return s.writeResync(session, channelId, userId)
+	}
+
+	// edits and deletions of messages the client already has are sent before the missed messages
+	changes, err := s.chatService.ListMessageChanges(context.Background(), channelId, lastMessageId)
+	if errors.Is(err, common.ErrorTooManyMessageChanges) || errors.Is(err, common.ErrorMessageNotFound) {
+		return s.writeResync(session, channelId, userId)
+	}
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := session.Write(change.ToPresenter().Encode()); err != nil {
+			return err
+		}
+	}
+
+	// messages are listed newest first
+	for i := len(messages) - 1; i >= 0; i-- {
+		if err := session.Write(messages[i].ToPresenter().Encode()); err != nil {
+			return err
+		}
+		replayed[messages[i].MessageId] = struct{}{}
+	}
+
+	return nil
+}
+
+// writeResync tells the client to reload its history instead of relying on a replay
+func (s *HttpServer) writeResync(session *melody.Session, channelId, userId uint64) error {
+	resync := &Message{
+		Event:     EventAction,
+		ChannelId: channelId,
+		UserId:    userId,
+		Payload:   string(ResyncMessage),
+		Time:      time.Now().UnixMilli(),
+	}
+	return session.Write(resync.ToPresenter().Encode())
+}
+
+func (s *HttpServer) HandleChatOnMessage(session *melody.Session, data []byte) {
+	chatMessageDto, err := DecodeToMessageDto(data)
+	if err != nil {
+		s.replyError(session, "", err)
+		return
+	}
+
+	// messages are sent as the user the session was opened for, whatever the client claims
+	sessionUserId, exist := session.Get(common.SessionUidKey)
+	if !exist {
+		s.replyError(session, chatMessageDto.CorrelationId, common.ErrorUnauthorized)
+		return
+	}
+	userId := sessionUserId.(uint64)
+
+	messageId, err := s.handleChatMessage(chatMessageDto, session.Request.URL.Query().Get("access_token"), userId)
+	if err != nil {
+		s.replyError(session, chatMessageDto.CorrelationId, err)
+		return
+	}
+
+	ack := &AckDto{
+		Event:         EventAck,
+		CorrelationId: chatMessageDto.CorrelationId,
+	}
+	if messageId != 0 {
+		ack.MessageId = strconv.FormatUint(messageId, 10)
+	}
+	if err := session.Write(ack.Encode()); err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	// a session has nothing left to do in a channel its user left
+	if chatMessageDto.Event == EventAction && Action(chatMessageDto.Payload) == LeavedMessage {
+		if err := session.Close(); err != nil {
+			s.logger.Error(err.Error())
+		}
+		return
+	}
+
+	// actions such as typing are too frequent to count as activity, key exchanges are sent by clients on their own
+	if chatMessageDto.Event != EventAction && chatMessageDto.Event != EventKeyExchange {
+		if err := s.userService.TouchLastSeen(context.Background(), userId); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}
+}
+
+// handleChatMessage dispatches a client message by event and returns the id assigned to a newly stored message
+func (s *HttpServer) handleChatMessage(chatMessageDto *MessageDto, accessToken string, userId uint64) (uint64, error) {
+	message, err := chatMessageDto.ToMessage(accessToken, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	if err := s.authorizeChatMessage(ctx, message); err != nil {
+		return 0, err
+	}
+
+	switch message.Event {
+	case EventText:
+		return s.chatService.BroadcastTextMessage(ctx, message.ChannelId, message.UserId, message.Payload, message.ReplyTo, chatMessageDto.ClientMessageId)
+	case EventAction:
+		if Action(message.Payload) == LeavedMessage {
+			return 0, s.leaveChannel(ctx, message.ChannelId, message.UserId)
+		}
+		return 0, s.chatService.BroadcastActionMessage(ctx, message.ChannelId, message.UserId, Action(message.Payload))
+	case EventSeen:
+		messageId, err := strconv.ParseUint(message.Payload, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, s.chatService.UpdateReadCursor(ctx, message.ChannelId, message.UserId, messageId)
+	case EventFile:
+		return s.chatService.BroadcastFileMessage(ctx, message.ChannelId, message.UserId, message.Payload, message.ReplyTo, chatMessageDto.ClientMessageId)
+	case EventEdit:
+		return 0, s.chatService.EditMessage(ctx, message.ChannelId, message.UserId, message.MessageId, message.Payload)
+	case EventDelete:
+		return 0, s.chatService.DeleteMessage(ctx, message.ChannelId, message.UserId, message.MessageId)
+	case EventReaction:
+		reaction, err := DecodeToReactionDto([]byte(message.Payload))
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, s.chatService.ReactMessage(ctx, message.ChannelId, message.UserId, message.MessageId, reaction.Emoji, reaction.Remove)
+	case EventKeyExchange:
+		return 0, s.chatService.RelayKeyExchange(ctx, message.ChannelId, message.UserId, message.Payload)
+	default:
+		return 0, common.ErrorUnknownEvent
+	}
+}
+
+// authorizeChatMessage rejects messages from users no longer in the channel, moderation and friendship actions sent by clients
+// and anything but reads and deletions from muted members
+func (s *HttpServer) authorizeChatMessage(ctx context.Context, message *Message) error {
+	exist, err := s.userService.IsChannelUserExists(ctx, message.ChannelId, message.UserId)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return common.ErrorChannelOrUserNotFound
+	}
+
+	switch message.Event {
+	case EventAction:
+		if action := Action(message.Payload); action.IsModeration() || action.IsFriendship() {
+			return common.ErrorPermissionDenied
+		}
+		return nil
+	case EventText, EventFile, EventEdit, EventReaction:
+		muted, err := s.channelService.IsMemberMuted(ctx, message.ChannelId, message.UserId)
+		if err != nil {
+			return err
+		}
+		if muted {
+			return common.ErrorMemberMuted
+		}
+	}
+	return nil
+}
+
+// replyError tells the sender its message was dropped. Only failures outside the client's control are logged.
+func (s *HttpServer) replyError(session *melody.Session, correlationId string, err error) {
+	code, reason := classifyError(err)
+	if code == ErrorCodeServer {
+		s.logger.Error(err.Error())
+	}
+
+	errorDto := &ErrorDto{
+		Event:         EventError,
+		CorrelationId: correlationId,
+		Code:          code,
+		Message:       reason.Error(),
+	}
+	if err := session.Write(errorDto.Encode()); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+func (s *HttpServer) HandleChatOnClose(session *melody.Session, i int, str string) error {
+	userID, ok := session.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		return nil
+	}
+	accessToken := session.Request.URL.Query().Get("access_token")
+	authResult, err := common.Auth(&common.AuthPayload{
+		AccessToken: accessToken,
+	})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return err
+	}
+	if authResult.Expired {
+		s.logger.Error(common.ErrorTokenExpired.Error())
+		return common.ErrorTokenExpired
+	}
+	channelID := authResult.ChannelId
+
+	sessionId, exist := session.Get(common.SessionPresenceKey)
+	if !exist {
+		return nil
+	}
+	presence, err := s.userService.DeleteOnlineSession(context.Background(), channelID, userID, sessionId.(string))
+	if err != nil {
+		s.logger.Error(err.Error())
+		return err
+	}
+	if err := s.userService.TouchLastSeen(context.Background(), userID); err != nil {
+		s.logger.Error(err.Error())
+	}
+	err = s.chatService.BroadcastExpiredUsers(context.Background(), channelID, presence)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return err
+	}
+	// the user stays in the channel through its other sessions
+	if presence.SessionCount > 0 {
+		return nil
+	}
+
 	err = s.forwarderService.RemoveChannelSession(context.Background(), channelID, userID)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return err
 	}
+	err = s.chatService.BroadcastActionMessage(context.Background(), channelID, userID, EndTypingMessage)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return err
+	}
 	return s.chatService.BroadcastActionMessage(context.Background(), channelID, userID, OfflineMessage)
 }
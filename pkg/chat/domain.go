@@ -10,8 +10,42 @@ const (
 	EventAction
 	EventSeen
 	EventFile
+	EventEdit
+	EventDelete
+	EventReaction
+	EventAck
+	EventError
+	// EventKeyExchange carries the key material members of an encrypted channel exchange, it is relayed and never stored
+	EventKeyExchange
 )
 
+// ErrorCode tells a websocket client why one of its messages was rejected
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidMessage     ErrorCode = "invalid_message"
+	ErrorCodeUnauthorized       ErrorCode = "unauthorized"
+	ErrorCodeTokenExpired       ErrorCode = "token_expired"
+	ErrorCodeUnknownEvent       ErrorCode = "unknown_event"
+	ErrorCodeMessageLimit       ErrorCode = "message_limit_exceeded"
+	ErrorCodeMessageNotFound    ErrorCode = "message_not_found"
+	ErrorCodeMessageNotEditable ErrorCode = "message_not_editable"
+	ErrorCodeNotMessageAuthor   ErrorCode = "not_message_author"
+	ErrorCodeInvalidReaction    ErrorCode = "invalid_reaction"
+	ErrorCodeNotChannelMember   ErrorCode = "not_channel_member"
+	ErrorCodeMuted              ErrorCode = "muted"
+	ErrorCodePermissionDenied   ErrorCode = "permission_denied"
+	ErrorCodePayloadTooLong     ErrorCode = "payload_too_long"
+	ErrorCodeNotEncrypted       ErrorCode = "channel_not_encrypted"
+	ErrorCodeServer             ErrorCode = "server_error"
+)
+
+// maxReactionBytes bounds a reaction emoji, leaving room for multi-codepoint sequences
+const maxReactionBytes = 32
+
+// maxClientMessageIdBytes bounds the idempotency key a client attaches to a message
+const maxClientMessageIdBytes = 64
+
 type Action string
 
 var (
@@ -21,23 +55,159 @@ var (
 	EndTypingMessage Action = "endtyping"
 	OfflineMessage   Action = "offline"
 	LeavedMessage    Action = "leaved"
+	ResyncMessage    Action = "resync"
+	KickedMessage    Action = "kicked"
+	BannedMessage    Action = "banned"
+	MutedMessage     Action = "muted"
+	PromotedMessage  Action = "promoted"
+	DemotedMessage   Action = "demoted"
+	// FriendRequestMessage and BefriendedMessage tell the peers of a matched channel about friend requests
+	FriendRequestMessage Action = "friendrequest"
+	BefriendedMessage    Action = "befriended"
 )
 
+// IsModeration reports whether the action records a moderation decision, which clients may not send themselves
+func (a Action) IsModeration() bool {
+	switch a {
+	case KickedMessage, BannedMessage, MutedMessage, PromotedMessage, DemotedMessage:
+		return true
+	}
+	return false
+}
+
+// IsFriendship reports whether the action records a friend request, which clients may not send themselves either
+func (a Action) IsFriendship() bool {
+	return a == FriendRequestMessage || a == BefriendedMessage
+}
+
+// RemovesMember reports whether the action takes its user out of the channel
+func (a Action) RemovesMember() bool {
+	return a == KickedMessage || a == BannedMessage || a == LeavedMessage
+}
+
 type Message struct {
 	MessageId uint64 `json:"messageId"`
 	Event     int    `json:"event"`
 	ChannelId uint64 `json:"channelId"`
 	UserId    uint64 `json:"userId"`
-	Payload   string `json:"payload"`
-	Seen      bool   `json:"seen"`
-	Time      int64  `json:"time"`
+	// Payload of a text or file is ciphertext in an encrypted channel
+	Payload  string `json:"payload"`
+	ReplyTo  uint64 `json:"replyTo"`
+	Edited   bool   `json:"edited"`
+	EditedAt int64  `json:"editedAt"`
+	Deleted  bool   `json:"deleted"`
+	Time     int64  `json:"time"`
+
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+}
+
+// MessageQuery selects channel messages either by an opaque Cassandra page state or,
+// when any bound is set, as a window of at most Limit messages between the bounds.
+type MessageQuery struct {
+	PageState string
+	Before    uint64
+	After     uint64
+	Since     int64
+	Limit     int
+}
+
+func (q *MessageQuery) IsRange() bool {
+	return q.Before != 0 || q.After != 0 || q.Since != 0
+}
+
+type Reaction struct {
+	MessageId uint64
+	UserId    uint64
+	Emoji     string
+}
+
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
 }
 
+type ReadCursor struct {
+	UserId    uint64
+	MessageId uint64
+}
+
+// Report is a user report of a channel, queued for moderators together with the latest messages as evidence
+type Report struct {
+	Id         uint64     `json:"id"`
+	ChannelId  uint64     `json:"channelId"`
+	ReporterId uint64     `json:"reporterId"`
+	Reason     string     `json:"reason"`
+	Messages   []*Message `json:"messages"`
+	Time       int64      `json:"time"`
+}
+
+func (r *Report) Encode() []byte {
+	result, _ := json.Marshal(r)
+	return result
+}
+
+// Channel is encrypted when its members exchange ciphertext only, which the server stores and relays without reading
 type Channel struct {
 	Id          uint64 `json:"id"`
+	Name        string `json:"name"`
+	Encrypted   bool   `json:"encrypted"`
 	AccessToken string `json:"accessToken"`
 }
 
+type Role string
+
+const (
+	OwnerRole     Role = "owner"
+	ModeratorRole Role = "moderator"
+	MemberRole    Role = "member"
+)
+
+var roleRanks = map[Role]int{
+	MemberRole:    0,
+	ModeratorRole: 1,
+	OwnerRole:     2,
+}
+
+// Outranks reports whether a member with role r may moderate a member with the target role
+func (r Role) Outranks(target Role) bool {
+	return roleRanks[r] >= roleRanks[ModeratorRole] && roleRanks[r] > roleRanks[target]
+}
+
+type Member struct {
+	UserId uint64
+	Role   Role
+}
+
+// ChannelMembers describes a channel through its members. Only group channels have a name.
+type ChannelMembers struct {
+	ChannelId uint64
+	Name      string
+	Encrypted bool
+	Members   []*Member
+}
+
+type UserChannel struct {
+	Channel
+	UnreadCount int64
+	LastMessage *Message
+}
+
+// Presence is the liveness of a channel right after one of its sessions changed
+type Presence struct {
+	// SessionCount is the number of live sessions of the user whose session changed
+	SessionCount int
+	// UserIds are the users with at least one live session
+	UserIds []uint64
+	// ExpiredUserIds are the users whose last session lapsed without being closed
+	ExpiredUserIds []uint64
+}
+
+type LastSeen struct {
+	UserId   uint64
+	LastSeen int64
+}
+
 type User struct {
 	Id   uint64 `json:"id"`
 	Name string `json:"name"`
@@ -49,12 +219,24 @@ func (m *Message) Encode() []byte {
 }
 
 func (m *Message) ToPresenter() *MessageDto {
+	var replyTo string
+	if m.ReplyTo != 0 {
+		replyTo = strconv.FormatUint(m.ReplyTo, 10)
+	}
+	reactions := []ReactionCountDto{}
+	for _, reaction := range m.Reactions {
+		reactions = append(reactions, ReactionCountDto(reaction))
+	}
 	return &MessageDto{
 		MessageId: strconv.FormatUint(m.MessageId, 10),
 		Event:     m.Event,
 		UserId:    strconv.FormatUint(m.UserId, 10),
 		Payload:   m.Payload,
-		Seen:      m.Seen,
+		ReplyTo:   replyTo,
+		Edited:    m.Edited,
+		EditedAt:  m.EditedAt,
+		Deleted:   m.Deleted,
 		Time:      m.Time,
+		Reactions: reactions,
 	}
 }
@@ -12,8 +12,52 @@ type MessageDto struct {
 	Event     int    `json:"event"`
 	UserId    string `json:"userId"`
 	Payload   string `json:"payload"`
-	Seen      bool   `json:"seen"`
+	ReplyTo   string `json:"replyTo"`
+	Edited    bool   `json:"edited"`
+	EditedAt  int64  `json:"editedAt"`
+	Deleted   bool   `json:"deleted"`
 	Time      int64  `json:"time"`
+
+	Reactions []ReactionCountDto `json:"reactions"`
+
+	// CorrelationId is set by the sender and echoed in the ack or error frame answering the message
+	CorrelationId string `json:"correlationId,omitempty"`
+	// ClientMessageId is set by the sender so that resending a message does not store it twice
+	ClientMessageId string `json:"clientMessageId,omitempty"`
+}
+
+// AckDto is sent back to the sender once its message is accepted
+type AckDto struct {
+	Event         int    `json:"event"`
+	CorrelationId string `json:"correlationId"`
+	MessageId     string `json:"messageId,omitempty"`
+}
+
+// ErrorDto is sent back to the sender when its message is rejected
+type ErrorDto struct {
+	Event         int       `json:"event"`
+	CorrelationId string    `json:"correlationId"`
+	Code          ErrorCode `json:"code"`
+	Message       string    `json:"message"`
+}
+
+type ReactionCountDto struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+type ReactionDto struct {
+	Emoji  string `json:"emoji"`
+	Remove bool   `json:"remove"`
+}
+
+type ListMessagesRequest struct {
+	PageState string `form:"ps"`
+	Before    uint64 `form:"before"`
+	After     uint64 `form:"after"`
+	Since     int64  `form:"since"`
+	Limit     int    `form:"limit" binding:"min=0"`
 }
 
 type MessagesDto struct {
@@ -21,6 +65,95 @@ type MessagesDto struct {
 	Messages      []MessageDto `json:"messages"`
 }
 
+type SearchMessagesDto struct {
+	Messages []MessageDto `json:"messages"`
+}
+
+type ReadCursorDto struct {
+	UserId    string `json:"userId"`
+	MessageId string `json:"messageId"`
+}
+
+type ReadCursorsDto struct {
+	Cursors []ReadCursorDto `json:"cursors"`
+}
+
+type UserChannelDto struct {
+	ChannelId   string      `json:"channelId"`
+	Encrypted   bool        `json:"encrypted"`
+	AccessToken string      `json:"accessToken"`
+	UnreadCount int64       `json:"unreadCount"`
+	LastMessage *MessageDto `json:"lastMessage"`
+}
+
+type UserChannelsDto struct {
+	Channels []UserChannelDto `json:"channels"`
+}
+
+type LastSeenDto struct {
+	UserId   string `json:"userId"`
+	LastSeen int64  `json:"lastSeen"`
+}
+
+type LastSeensDto struct {
+	Users []LastSeenDto `json:"users"`
+}
+
+type CreateGroupChannelRequest struct {
+	Name      string   `json:"name" binding:"required,max=64"`
+	UserIds   []string `json:"userIds"`
+	Encrypted bool     `json:"encrypted"`
+}
+
+type InviteMembersRequest struct {
+	UserIds []string `json:"userIds" binding:"required,min=1"`
+}
+
+type MuteMemberRequest struct {
+	DurationSecond int64 `json:"durationSecond" binding:"required,min=1,max=2592000"`
+}
+
+type SetMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=moderator member"`
+}
+
+type ReportChannelRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+type ReportDto struct {
+	ReportId string `json:"reportId"`
+}
+
+type MuteDto struct {
+	UserId     string `json:"userId"`
+	MutedUntil int64  `json:"mutedUntil"`
+}
+
+type ChannelDto struct {
+	ChannelId   string `json:"channelId"`
+	Name        string `json:"name"`
+	Encrypted   bool   `json:"encrypted"`
+	AccessToken string `json:"accessToken"`
+}
+
+type FriendRequestDto struct {
+	PeerId  string `json:"peerId"`
+	Friends bool   `json:"friends"`
+}
+
+type MemberDto struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type ChannelMembersDto struct {
+	ChannelId string      `json:"channelId"`
+	Name      string      `json:"name"`
+	Encrypted bool        `json:"encrypted"`
+	Members   []MemberDto `json:"members"`
+}
+
 type UserDto struct {
 	Id   string `json:"id"`
 	Name string `json:"name" binding:"required"`
@@ -30,12 +163,28 @@ type UserIdsDto struct {
 	UserIds []string `json:"userIds"`
 }
 
+func (r *ReactionDto) Encode() []byte {
+	result, _ := json.Marshal(r)
+	return result
+}
+
+func (a *AckDto) Encode() []byte {
+	result, _ := json.Marshal(a)
+	return result
+}
+
+func (e *ErrorDto) Encode() []byte {
+	result, _ := json.Marshal(e)
+	return result
+}
+
 func (m *MessageDto) Encode() []byte {
 	result, _ := json.Marshal(m)
 	return result
 }
 
-func (m *MessageDto) ToMessage(accessToken string) (*Message, error) {
+// ToMessage builds the message sent by userId, the user id of the dto is only informative and never trusted
+func (m *MessageDto) ToMessage(accessToken string, userId uint64) (*Message, error) {
 	authResult, err := common.Auth(&common.AuthPayload{
 		AccessToken: accessToken,
 	})
@@ -46,15 +195,27 @@ func (m *MessageDto) ToMessage(accessToken string) (*Message, error) {
 		return nil, common.ErrorTokenExpired
 	}
 	channelID := authResult.ChannelId
-	userID, err := strconv.ParseUint(m.UserId, 10, 64)
-	if err != nil {
-		return nil, err
+	var messageID uint64
+	if m.MessageId != "" {
+		messageID, err = strconv.ParseUint(m.MessageId, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var replyTo uint64
+	if m.ReplyTo != "" {
+		replyTo, err = strconv.ParseUint(m.ReplyTo, 10, 64)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &Message{
+		MessageId: messageID,
 		Event:     m.Event,
 		ChannelId: channelID,
-		UserId:    userID,
+		UserId:    userId,
 		Payload:   m.Payload,
+		ReplyTo:   replyTo,
 		Time:      m.Time,
 	}, nil
 }
@@ -2,14 +2,30 @@ package chat
 
 import (
 	"context"
+	"errors"
 
+	"github.com/thyyl/chatr/pkg/common"
 	chatProto "github.com/thyyl/chatr/proto/chat"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 func (s *GrpcServer) CreateChannel(ctx context.Context, request *chatProto.CreateChannelRequest) (*chatProto.CreateChannelResponse, error) {
-	channel, err := s.channelService.CreateChannel(ctx)
+	if request.ChannelId != 0 {
+		channel, err := s.channelService.ReopenChannel(ctx, request.ChannelId)
+		if err == nil {
+			return &chatProto.CreateChannelResponse{
+				ChannelId:   channel.Id,
+				AccessToken: channel.AccessToken,
+			}, nil
+		}
+		if !errors.Is(err, common.ErrorChannelOrUserNotFound) {
+			s.logger.Error(err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	channel, err := s.channelService.CreateChannel(ctx, "", false)
 	if err != nil {
 		return nil, err
 	}
@@ -21,7 +37,7 @@ func (s *GrpcServer) CreateChannel(ctx context.Context, request *chatProto.Creat
 }
 
 func (s *GrpcServer) AddUserToChannel(ctx context.Context, request *chatProto.AddUserRequest) (*chatProto.AddUserResponse, error) {
-	err := s.userService.AddUserToChannel(ctx, request.ChannelId, request.UserId)
+	err := s.userService.AddUserToChannel(ctx, request.ChannelId, request.UserId, MemberRole)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
@@ -29,3 +45,15 @@ func (s *GrpcServer) AddUserToChannel(ctx context.Context, request *chatProto.Ad
 
 	return &chatProto.AddUserResponse{}, nil
 }
+
+func (s *GrpcServer) GetChannelUserIds(ctx context.Context, request *chatProto.GetChannelUserIdsRequest) (*chatProto.GetChannelUserIdsResponse, error) {
+	userIds, err := s.userService.GetChannelUserIds(ctx, request.ChannelId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &chatProto.GetChannelUserIdsResponse{
+		UserIds: userIds,
+	}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thyyl/chatr/pkg/common"
@@ -21,6 +22,11 @@ type MelodyChatConn struct {
 func NewMelodyChat(config *config.Config) MelodyChatConn {
 	melody := melody.New()
 	melody.Config.MaxMessageSize = config.Chat.Message.MaxSizeByte
+	// leave room for the missed messages replayed on reconnect on top of live traffic
+	melody.Config.MessageBufferSize += config.Chat.Message.ReplayNum
+	// pongs refresh the presence of a session, so they must come well within the presence TTL
+	melody.Config.PingPeriod = time.Duration(config.Chat.Presence.HeartbeatSecond) * time.Second
+	melody.Config.PongWait = 2 * melody.Config.PingPeriod
 	MelodyChat = MelodyChatConn{
 		melody,
 	}
@@ -41,6 +47,9 @@ type HttpServer struct {
 	channelService    ChannelService
 	forwarderService  ForwarderService
 	serveSwag         bool
+	replayNum         int
+	maxMembers        int
+	reportEvidenceNum int
 }
 
 func NewGinServer(name string, logger common.HttpLog, config *config.Config) *gin.Engine {
@@ -69,6 +78,26 @@ func NewHttpServer(name string, logger common.HttpLog, config *config.Config, se
 		channelService:    channelService,
 		forwarderService:  forwarderService,
 		serveSwag:         config.Chat.Http.Server.Swag,
+		replayNum:         config.Chat.Message.ReplayNum,
+		maxMembers:        config.Chat.Channel.MaxMembers,
+		reportEvidenceNum: config.Chat.Report.EvidenceNum,
+	}
+}
+
+func (s *HttpServer) CookieAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sid, err := common.GetCookie(c, common.SessionIdCookieName)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		userId, err := s.userService.GetUserIdBySession(c.Request.Context(), sid)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), common.UserKey, userId))
+		c.Next()
 	}
 }
 
@@ -77,7 +106,11 @@ func (s *HttpServer) RegisterRoutes() {
 
 	chatGroup := s.server.Group("/api/chat")
 	{
-		chatGroup.GET("", s.StartChat)
+		chatAuthGroup := chatGroup.Group("")
+		chatAuthGroup.Use(s.CookieAuth())
+		{
+			chatAuthGroup.GET("", s.StartChat)
+		}
 
 		forwarderAuthGroup := chatGroup.Group("/forwarderauth")
 		forwarderAuthGroup.Use(common.JWTAuth())
@@ -90,19 +123,46 @@ func (s *HttpServer) RegisterRoutes() {
 		{
 			userGroup.GET("", s.GetChannelUsers)
 			userGroup.GET("/online", s.GetOnlineUsers)
+			userGroup.GET("/lastseen", s.GetLastSeen)
+		}
+
+		meGroup := chatGroup.Group("/me")
+		meGroup.Use(s.CookieAuth())
+		{
+			meGroup.GET("/channels", s.ListUserChannels)
+			meGroup.POST("/channels", s.CreateGroupChannel)
 		}
 
 		channelGroup := chatGroup.Group("/channel")
 		channelGroup.Use(common.JWTAuth())
 		{
-			channelGroup.GET("/messages", s.ListMessages)
-			channelGroup.DELETE("", s.DeleteChannel)
+			channelGroup.GET("/messages/search", s.SearchMessages)
+			channelGroup.GET("/cursors", s.GetReadCursors)
+			channelGroup.GET("/members", s.ListChannelMembers)
+
+			// members acting on the channel are identified by their session, the channel token alone is not enough
+			channelAuthGroup := channelGroup.Group("")
+			channelAuthGroup.Use(s.CookieAuth())
+			{
+				channelAuthGroup.GET("/messages", s.ListMessages)
+				channelAuthGroup.GET("/messages/:id/replies", s.ListReplies)
+				channelAuthGroup.POST("/members", s.InviteMembers)
+				channelAuthGroup.PUT("/members/:id/role", s.SetMemberRole)
+				channelAuthGroup.POST("/members/:id/kick", s.KickMember)
+				channelAuthGroup.POST("/members/:id/mute", s.MuteMember)
+				channelAuthGroup.POST("/members/:id/ban", s.BanMember)
+				channelAuthGroup.POST("/leave", s.LeaveChannel)
+				channelAuthGroup.POST("/report", s.ReportChannel)
+				channelAuthGroup.POST("/friend", s.SendFriendRequest)
+				channelAuthGroup.DELETE("", s.DeleteChannel)
+			}
 		}
 	}
 
 	s.melodyChat.HandleConnect(s.HandleChatOnConnect)
 	s.melodyChat.HandleMessage(s.HandleChatOnMessage)
 	s.melodyChat.HandleClose(s.HandleChatOnClose)
+	s.melodyChat.HandlePong(s.HandleChatOnPong)
 }
 
 func (s *HttpServer) Run() {
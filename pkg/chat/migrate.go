@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+)
+
+// BackfillUserChannels writes the user_channels row of every channel membership, for memberships stored before
+// channels were listed per user. It returns the number of memberships written; rows are upserts, so running it
+// again is harmless.
+func BackfillUserChannels(ctx context.Context, session *gocql.Session) (int, error) {
+	iteration := session.Query("SELECT id, user_id FROM channels").WithContext(ctx).Idempotent(true).Iter()
+
+	var channelId, userId uint64
+	backfilled := 0
+
+	for iteration.Scan(&channelId, &userId) {
+		// user 0 is the placeholder row written when the channel is created
+		if userId == 0 {
+			continue
+		}
+
+		if err := session.Query("INSERT INTO user_channels (user_id, channel_id) VALUES (?, ?)",
+			userId, channelId).WithContext(ctx).Idempotent(true).Exec(); err != nil {
+			return backfilled, errors.Join(err, iteration.Close())
+		}
+		backfilled++
+	}
+
+	if err := iteration.Close(); err != nil {
+		return backfilled, err
+	}
+
+	return backfilled, nil
+}
+
+// BackfillMessageSeqs numbers the stored messages of every channel in the order they were sent, seeds the sequence
+// of each channel with its latest number and refreshes the read count of every read cursor from the numbers. It
+// overwrites the numbers taken so far, so it must run while no chat server stores messages. It returns the number
+// of messages numbered; running it again is harmless.
+func BackfillMessageSeqs(ctx context.Context, session *gocql.Session) (int, error) {
+	channels := session.Query("SELECT DISTINCT channel_id FROM messages").WithContext(ctx).Idempotent(true).Iter()
+
+	var channelId uint64
+	backfilled := 0
+
+	for channels.Scan(&channelId) {
+		numbered, err := backfillChannelSeqs(ctx, session, channelId)
+		backfilled += numbered
+		if err != nil {
+			return backfilled, errors.Join(err, channels.Close())
+		}
+	}
+
+	if err := channels.Close(); err != nil {
+		return backfilled, err
+	}
+
+	cursors := session.Query("SELECT channel_id, user_id, message_id FROM channel_read_cursors").WithContext(ctx).Idempotent(true).Iter()
+
+	var userId, messageId uint64
+	for cursors.Scan(&channelId, &userId, &messageId) {
+		var readNum int64
+		if err := session.Query("SELECT seq FROM messages WHERE channel_id = ? AND id = ?", channelId, messageId).
+			WithContext(ctx).Idempotent(true).Scan(&readNum); err != nil && err != gocql.ErrNotFound {
+			return backfilled, errors.Join(err, cursors.Close())
+		}
+		if err := session.Query("UPDATE channel_read_cursors SET read_num = ? WHERE channel_id = ? AND user_id = ?",
+			readNum, channelId, userId).WithContext(ctx).Idempotent(true).Exec(); err != nil {
+			return backfilled, errors.Join(err, cursors.Close())
+		}
+	}
+
+	if err := cursors.Close(); err != nil {
+		return backfilled, err
+	}
+
+	return backfilled, nil
+}
+
+func backfillChannelSeqs(ctx context.Context, session *gocql.Session, channelId uint64) (int, error) {
+	iteration := session.Query("SELECT id FROM messages WHERE channel_id = ? ORDER BY id ASC", channelId).
+		WithContext(ctx).Idempotent(true).Iter()
+
+	var messageId uint64
+	var seq int64
+
+	for iteration.Scan(&messageId) {
+		seq++
+		if err := session.Query("UPDATE messages SET seq = ? WHERE channel_id = ? AND id = ?",
+			seq, channelId, messageId).WithContext(ctx).Idempotent(true).Exec(); err != nil {
+			return int(seq - 1), errors.Join(err, iteration.Close())
+		}
+	}
+
+	if err := iteration.Close(); err != nil {
+		return int(seq), err
+	}
+
+	return int(seq), session.Query("INSERT INTO channel_seqs (channel_id, seq) VALUES (?, ?)",
+		channelId, seq).WithContext(ctx).Idempotent(true).Exec()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	base64 "encoding/base64"
 	"fmt"
+	"slices"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -14,27 +15,56 @@ import (
 	"github.com/thyyl/chatr/pkg/transport"
 	forwarderProto "github.com/thyyl/chatr/proto/forwarder"
 	userProto "github.com/thyyl/chatr/proto/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ============================
 // Repository Interfaces
 // ============================
 type UserRepo interface {
-	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error
+	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error
+	RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) error
 	GetUserById(ctx context.Context, userId uint64) (*User, error)
 	GetChannelUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
+	GetUserChannelIds(ctx context.Context, userId uint64) ([]uint64, error)
+	GetUserIdBySession(ctx context.Context, session string) (uint64, error)
+	UpdateLastSeen(ctx context.Context, userId uint64, lastSeen int64) error
+	GetLastSeen(ctx context.Context, userIds []uint64) (map[uint64]int64, error)
+	SendFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error)
 }
 
 type ChannelRepo interface {
-	CreateChannel(ctx context.Context, channelId uint64) (*Channel, error)
+	CreateChannel(ctx context.Context, channelId uint64, name string, encrypted bool) (*Channel, error)
+	GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error)
+	IsChannelEncrypted(ctx context.Context, channelId uint64) (bool, error)
+	GetChannelsEncrypted(ctx context.Context, channelIds []uint64) (map[uint64]bool, error)
+	SetMemberRole(ctx context.Context, channelId uint64, userId uint64, role Role) error
+	BanUser(ctx context.Context, channelId uint64, userId uint64) error
+	IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error)
 	DeleteChannel(ctx context.Context, channelId uint64) error
 }
 
 type ChatRepo interface {
 	InsertMessage(ctx context.Context, chatMessage *Message) error
-	MarkMessageSeen(ctx context.Context, channelId uint64, messageId uint64) error
+	UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) (bool, error)
+	ListReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error)
+	GetReadNums(ctx context.Context, channelIds []uint64, userId uint64) (map[uint64]int64, error)
+	GetMessageNums(ctx context.Context, channelIds []uint64) (map[uint64]int64, error)
+	GetLastMessage(ctx context.Context, channelId uint64) (*Message, error)
+	GetLastMessages(ctx context.Context, channelIds []uint64) (map[uint64]*Message, error)
+	GetMessage(ctx context.Context, channelId uint64, messageId uint64) (*Message, error)
+	GetMessages(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Message, error)
+	EditMessage(ctx context.Context, channelId uint64, messageId uint64, payload string, editedAt int64) error
+	DeleteMessage(ctx context.Context, channelId uint64, messageId uint64) error
 	PublishMessage(ctx context.Context, chatMessage *Message) error
-	ListMessages(ctx context.Context, channelId uint64, pageStateBase64 string) ([]*Message, string, error)
+	PublishReport(ctx context.Context, report *Report) error
+	PublishNotification(ctx context.Context, notification *common.Notification) error
+	ListMessages(ctx context.Context, channelId uint64, query *MessageQuery) ([]*Message, string, error)
+	ListReplies(ctx context.Context, channelId uint64, parentId uint64, pageStateBase64 string) ([]*Message, string, error)
+	AddReaction(ctx context.Context, channelId uint64, reaction *Reaction) error
+	RemoveReaction(ctx context.Context, channelId uint64, reaction *Reaction) error
+	ListReactions(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Reaction, error)
 }
 
 type ForwarderRepo interface {
@@ -42,12 +72,21 @@ type ForwarderRepo interface {
 	RemoveChannelSession(ctx context.Context, channelId uint64, userId uint64) error
 }
 
+const messageColumns = "id, event, channel_id, user_id, payload, reply_to, edited, edited_at, deleted, timestamp"
+
+// maxSeqAttempts bounds how often an insert tries again for a sequence number taken by a concurrent insert
+const maxSeqAttempts = 10
+
 // ============================
 // Repository Implementations
 // ============================
 type UserRepoImpl struct {
-	session *gocql.Session
-	getUser endpoint.Endpoint
+	session            *gocql.Session
+	getUser            endpoint.Endpoint
+	getUserIdBySession endpoint.Endpoint
+	updateLastSeen     endpoint.Endpoint
+	getLastSeen        endpoint.Endpoint
+	sendFriendRequest  endpoint.Endpoint
 }
 
 func NewUserRepoImpl(session *gocql.Session, userConn *UserClientConn) *UserRepoImpl {
@@ -60,6 +99,34 @@ func NewUserRepoImpl(session *gocql.Session, userConn *UserClientConn) *UserRepo
 			"GetUser",
 			&userProto.GetUserResponse{},
 		),
+		getUserIdBySession: transport.NewGrpcEndpoint(
+			userConn.Conn,
+			"user",
+			"user.UserService",
+			"GetUserIdBySession",
+			&userProto.GetUserIdBySessionResponse{},
+		),
+		updateLastSeen: transport.NewGrpcEndpoint(
+			userConn.Conn,
+			"user",
+			"user.UserService",
+			"UpdateLastSeen",
+			&userProto.UpdateLastSeenResponse{},
+		),
+		getLastSeen: transport.NewGrpcEndpoint(
+			userConn.Conn,
+			"user",
+			"user.UserService",
+			"GetLastSeen",
+			&userProto.GetLastSeenResponse{},
+		),
+		sendFriendRequest: transport.NewGrpcEndpoint(
+			userConn.Conn,
+			"user",
+			"user.UserService",
+			"SendFriendRequest",
+			&userProto.SendFriendRequestResponse{},
+		),
 	}
 }
 
@@ -78,6 +145,7 @@ type ChatRepoImpl struct {
 	publisher   message.Publisher
 	maxMessages int64
 	pagination  int
+	queryLimit  int
 }
 
 func NewChatRepoImpl(session *gocql.Session, publisher message.Publisher, config *config.Config) *ChatRepoImpl {
@@ -86,6 +154,7 @@ func NewChatRepoImpl(session *gocql.Session, publisher message.Publisher, config
 		publisher:   publisher,
 		maxMessages: config.Chat.Message.MaxNum,
 		pagination:  config.Chat.Message.PaginationNum,
+		queryLimit:  config.Chat.Message.QueryNum,
 	}
 }
 
@@ -116,15 +185,24 @@ func NewForwarderRepoImpl(forwarderConn *ForwarderClientConn) *ForwarderRepoImpl
 // ============================
 // Repository Functions
 // ============================
-func (repo *UserRepoImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error {
-	if err := repo.session.Query("INSERT INTO channels (id, user_id) VALUES (?, ?)",
-		channelId, userId).WithContext(ctx).Exec(); err != nil {
+func (repo *UserRepoImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error {
+	batch := repo.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Query("INSERT INTO channels (id, user_id, role) VALUES (?, ?, ?)", channelId, userId, string(role))
+	batch.Query("INSERT INTO user_channels (user_id, channel_id) VALUES (?, ?)", userId, channelId)
+	if err := repo.session.ExecuteBatch(batch); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+func (repo *UserRepoImpl) RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) error {
+	batch := repo.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Query("DELETE FROM channels WHERE id = ? AND user_id = ?", channelId, userId)
+	batch.Query("DELETE FROM user_channels WHERE user_id = ? AND channel_id = ?", userId, channelId)
+	return repo.session.ExecuteBatch(batch)
+}
+
 func (repo *UserRepoImpl) GetUserById(ctx context.Context, userId uint64) (*User, error) {
 	request := &userProto.GetUserRequest{Id: userId}
 	response, err := repo.getUser(ctx, request)
@@ -160,9 +238,72 @@ func (repo *UserRepoImpl) GetChannelUserIds(ctx context.Context, channelId uint6
 	return userIds, nil
 }
 
-func (repo *ChannelRepoImpl) CreateChannel(ctx context.Context, channelId uint64) (*Channel, error) {
-	if err := repo.session.Query("INSERT INTO channels (id, user_id) VALUES (?, ?)",
-		channelId, 0).WithContext(ctx).Exec(); err != nil {
+func (repo *UserRepoImpl) GetUserChannelIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	iteration := repo.session.Query("SELECT channel_id FROM user_channels WHERE user_id = ?", userId).WithContext(ctx).Idempotent(true).Iter()
+
+	var channelIds []uint64
+	var channelId uint64
+
+	for iteration.Scan(&channelId) {
+		channelIds = append(channelIds, channelId)
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return channelIds, nil
+}
+
+func (repo *UserRepoImpl) GetUserIdBySession(ctx context.Context, session string) (uint64, error) {
+	response, err := repo.getUserIdBySession(ctx, &userProto.GetUserIdBySessionRequest{
+		Session: session,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return response.(*userProto.GetUserIdBySessionResponse).Id, nil
+}
+
+func (repo *UserRepoImpl) UpdateLastSeen(ctx context.Context, userId uint64, lastSeen int64) error {
+	_, err := repo.updateLastSeen(ctx, &userProto.UpdateLastSeenRequest{
+		Id:       userId,
+		LastSeen: lastSeen,
+	})
+	return err
+}
+
+func (repo *UserRepoImpl) GetLastSeen(ctx context.Context, userIds []uint64) (map[uint64]int64, error) {
+	response, err := repo.getLastSeen(ctx, &userProto.GetLastSeenRequest{
+		Ids: userIds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.(*userProto.GetLastSeenResponse).LastSeen, nil
+}
+
+// SendFriendRequest asks the user service to record the request and reports whether the users are friends now
+func (repo *UserRepoImpl) SendFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error) {
+	response, err := repo.sendFriendRequest(ctx, &userProto.SendFriendRequestRequest{
+		UserId: userId,
+		PeerId: peerId,
+	})
+	if err != nil {
+		if status.Code(err) == codes.PermissionDenied {
+			return false, common.ErrorPermissionDenied
+		}
+		return false, err
+	}
+
+	return response.(*userProto.SendFriendRequestResponse).Friends, nil
+}
+
+func (repo *ChannelRepoImpl) CreateChannel(ctx context.Context, channelId uint64, name string, encrypted bool) (*Channel, error) {
+	if err := repo.session.Query("INSERT INTO channels (id, user_id, name, encrypted) VALUES (?, ?, ?, ?)",
+		channelId, 0, name, encrypted).WithContext(ctx).Exec(); err != nil {
 		return nil, err
 	}
 
@@ -173,50 +314,422 @@ func (repo *ChannelRepoImpl) CreateChannel(ctx context.Context, channelId uint64
 
 	return &Channel{
 		Id:          channelId,
+		Name:        name,
+		Encrypted:   encrypted,
 		AccessToken: accessToken,
 	}, nil
 }
 
-func (repo *ChannelRepoImpl) DeleteChannel(ctx context.Context, channelId uint64) error {
-	if err := repo.session.Query("DELETE FROM channels WHERE id = ?", channelId).WithContext(ctx).Exec(); err != nil {
+func (repo *ChannelRepoImpl) GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error) {
+	iteration := repo.session.Query("SELECT user_id, role, name, encrypted FROM channels WHERE id = ?", channelId).WithContext(ctx).Idempotent(true).Iter()
+
+	channelMembers := &ChannelMembers{ChannelId: channelId}
+	var userId uint64
+	var role, name string
+	var encrypted bool
+
+	for iteration.Scan(&userId, &role, &name, &encrypted) {
+		channelMembers.Name = name
+		channelMembers.Encrypted = encrypted
+		// user 0 is the placeholder row written when the channel is created
+		if userId == 0 {
+			continue
+		}
+
+		// members added before roles existed have none
+		if role == "" {
+			role = string(MemberRole)
+		}
+		channelMembers.Members = append(channelMembers.Members, &Member{
+			UserId: userId,
+			Role:   Role(role),
+		})
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return channelMembers, nil
+}
+
+// IsChannelEncrypted reads the flag from any row of the channel, since it is static. Channels created before
+// encryption existed have no flag and are plaintext.
+func (repo *ChannelRepoImpl) IsChannelEncrypted(ctx context.Context, channelId uint64) (bool, error) {
+	var encrypted bool
+	if err := repo.session.Query("SELECT encrypted FROM channels WHERE id = ? LIMIT 1",
+		channelId).WithContext(ctx).Idempotent(true).Scan(&encrypted); err != nil {
+		if err == gocql.ErrNotFound {
+			return false, common.ErrorChannelOrUserNotFound
+		}
+		return false, err
+	}
+
+	return encrypted, nil
+}
+
+// GetChannelsEncrypted reads the flag of each of the channels in one query, leaving out channels that do not exist
+func (repo *ChannelRepoImpl) GetChannelsEncrypted(ctx context.Context, channelIds []uint64) (map[uint64]bool, error) {
+	channelsEncrypted := make(map[uint64]bool)
+	if len(channelIds) == 0 {
+		return channelsEncrypted, nil
+	}
+
+	iteration := repo.session.Query("SELECT id, encrypted FROM channels WHERE id IN ? PER PARTITION LIMIT 1", channelIds).
+		WithContext(ctx).Idempotent(true).Iter()
+
+	var channelId uint64
+	var encrypted bool
+
+	for iteration.Scan(&channelId, &encrypted) {
+		channelsEncrypted[channelId] = encrypted
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return channelsEncrypted, nil
+}
+
+func (repo *ChannelRepoImpl) SetMemberRole(ctx context.Context, channelId uint64, userId uint64, role Role) error {
+	applied, err := repo.session.Query("UPDATE channels SET role = ? WHERE id = ? AND user_id = ? IF EXISTS",
+		string(role), channelId, userId).WithContext(ctx).ScanCAS()
+	if err != nil {
 		return err
 	}
+	if !applied {
+		return common.ErrorChannelOrUserNotFound
+	}
 
 	return nil
 }
 
-func (repo *ChatRepoImpl) InsertMessage(ctx context.Context, chatMessage *Message) error {
-	var messageNum int64
+func (repo *ChannelRepoImpl) BanUser(ctx context.Context, channelId uint64, userId uint64) error {
+	return repo.session.Query("INSERT INTO channel_bans (channel_id, user_id) VALUES (?, ?)",
+		channelId, userId).WithContext(ctx).Exec()
+}
 
-	err := repo.session.Query("SELECT message_num FROM chanmsg_counters WHERE channel_id = ? LIMIT 1", chatMessage.ChannelId).WithContext(ctx).Idempotent(true).Scan(&messageNum)
-	if err != nil {
+func (repo *ChannelRepoImpl) IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error) {
+	var bannedUserId uint64
+	if err := repo.session.Query("SELECT user_id FROM channel_bans WHERE channel_id = ? AND user_id = ? LIMIT 1",
+		channelId, userId).WithContext(ctx).Idempotent(true).Scan(&bannedUserId); err != nil {
 		if err == gocql.ErrNotFound {
-			messageNum = 0
-		} else {
-			return err
+			return false, nil
 		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (repo *ChannelRepoImpl) DeleteChannel(ctx context.Context, channelId uint64) error {
+	iteration := repo.session.Query("SELECT user_id FROM channels WHERE id = ?", channelId).WithContext(ctx).Idempotent(true).Iter()
+
+	batch := repo.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	var userId uint64
+
+	for iteration.Scan(&userId) {
+		batch.Query("DELETE FROM user_channels WHERE user_id = ? AND channel_id = ?", userId, channelId)
+	}
+
+	if err := iteration.Close(); err != nil {
+		return err
+	}
+
+	batch.Query("DELETE FROM channels WHERE id = ?", channelId)
+	batch.Query("DELETE FROM channel_bans WHERE channel_id = ?", channelId)
+	batch.Query("DELETE FROM channel_seqs WHERE channel_id = ?", channelId)
+	if err := repo.session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *ChatRepoImpl) InsertMessage(ctx context.Context, chatMessage *Message) error {
+	messageNum, err := repo.GetMessageNum(ctx, chatMessage.ChannelId)
+	if err != nil {
+		return err
 	}
 
 	if messageNum >= repo.maxMessages {
 		return common.ErrorExceedMessageNumLimits
 	}
 
-	if err := repo.session.Query("INSERT INTO messages (id, event, channel_id, user_id, payload, seen, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)",
+	// a null reply_to keeps top-level messages out of the reply index
+	var replyTo interface{}
+	if chatMessage.ReplyTo != 0 {
+		replyTo = chatMessage.ReplyTo
+	}
+
+	seq, err := repo.nextSeq(ctx, chatMessage.ChannelId)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.session.Query("INSERT INTO messages (id, event, channel_id, user_id, payload, reply_to, timestamp, seq) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
 		chatMessage.MessageId,
 		chatMessage.Event,
 		chatMessage.ChannelId,
 		chatMessage.UserId,
 		chatMessage.Payload,
-		false,
-		chatMessage.Time).WithContext(ctx).Exec(); err != nil {
+		replyTo,
+		chatMessage.Time,
+		seq).WithContext(ctx).Exec(); err != nil {
 		return err
 	}
 
 	return repo.session.Query("UPDATE chanmsg_counters SET message_num = message_num + 1 WHERE channel_id = ?", chatMessage.ChannelId).WithContext(ctx).Exec()
 }
 
-func (repo *ChatRepoImpl) MarkMessageSeen(ctx context.Context, channelId uint64, messageId uint64) error {
-	if err := repo.session.Query("UPDATE messages SET seen = true WHERE channel_id = ? AND id = ?", channelId, messageId).
+// nextSeq numbers the next stored message of the channel, so that the read count at a cursor is a single row
+// read. The number is taken with a lightweight transaction, so messages inserted by several replicas at once never
+// share one; a number taken by an insert that fails afterwards is skipped.
+func (repo *ChatRepoImpl) nextSeq(ctx context.Context, channelId uint64) (int64, error) {
+	var seq int64
+	if err := repo.session.Query("SELECT seq FROM channel_seqs WHERE channel_id = ?", channelId).
+		WithContext(ctx).Idempotent(true).Scan(&seq); err != nil && err != gocql.ErrNotFound {
+		return 0, err
+	}
+
+	for range maxSeqAttempts {
+		var query *gocql.Query
+		if seq == 0 {
+			query = repo.session.Query("INSERT INTO channel_seqs (channel_id, seq) VALUES (?, 1) IF NOT EXISTS", channelId)
+		} else {
+			query = repo.session.Query("UPDATE channel_seqs SET seq = ? WHERE channel_id = ? IF seq = ?", seq+1, channelId, seq)
+		}
+
+		previous := make(map[string]interface{})
+		applied, err := query.WithContext(ctx).MapScanCAS(previous)
+		if err != nil {
+			return 0, err
+		}
+		if applied {
+			return seq + 1, nil
+		}
+		// another insert took the number, try again after the one it took
+		seq, _ = previous["seq"].(int64)
+	}
+
+	return 0, fmt.Errorf("error number message in channel %d: lost to concurrent inserts %d times", channelId, maxSeqAttempts)
+}
+
+// UpdateReadCursor moves the user's read cursor forward to messageId and reports whether it moved.
+// Lightweight transactions keep the cursor monotonic when several sessions of a user race.
+func (repo *ChatRepoImpl) UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) (bool, error) {
+	previous := make(map[string]interface{})
+	applied, err := repo.session.Query("UPDATE channel_read_cursors SET message_id = ? WHERE channel_id = ? AND user_id = ? IF message_id < ?",
+		messageId, channelId, userId, messageId).WithContext(ctx).MapScanCAS(previous)
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		if _, exist := previous["message_id"]; exist {
+			return false, nil
+		}
+
+		applied, err = repo.session.Query("INSERT INTO channel_read_cursors (channel_id, user_id, message_id) VALUES (?, ?, ?) IF NOT EXISTS",
+			channelId, userId, messageId).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+		if err != nil || !applied {
+			return false, err
+		}
+	}
+
+	if err := repo.updateReadNum(ctx, channelId, userId, messageId); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// updateReadNum records the sequence number of the message at the cursor, which is the number of messages read,
+// so unread counts can be derived from channel_seqs. The write is conditioned on the cursor so a slower concurrent
+// update cannot overwrite a newer count.
+func (repo *ChatRepoImpl) updateReadNum(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error {
+	var readNum int64
+	if err := repo.session.Query("SELECT seq FROM messages WHERE channel_id = ? AND id = ?", channelId, messageId).
+		WithContext(ctx).Idempotent(true).Scan(&readNum); err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+
+	_, err := repo.session.Query("UPDATE channel_read_cursors SET read_num = ? WHERE channel_id = ? AND user_id = ? IF message_id = ?",
+		readNum, channelId, userId, messageId).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	return err
+}
+
+// GetReadNums returns the read count of the user in each of the channels, leaving out channels with no cursor
+func (repo *ChatRepoImpl) GetReadNums(ctx context.Context, channelIds []uint64, userId uint64) (map[uint64]int64, error) {
+	readNums := make(map[uint64]int64)
+	if len(channelIds) == 0 {
+		return readNums, nil
+	}
+
+	iteration := repo.session.Query("SELECT channel_id, read_num FROM channel_read_cursors WHERE channel_id IN ? AND user_id = ?", channelIds, userId).
+		WithContext(ctx).Idempotent(true).Iter()
+
+	var channelId uint64
+	var readNum int64
+
+	for iteration.Scan(&channelId, &readNum) {
+		readNums[channelId] = readNum
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return readNums, nil
+}
+
+func (repo *ChatRepoImpl) GetMessageNum(ctx context.Context, channelId uint64) (int64, error) {
+	var messageNum int64
+	err := repo.session.Query("SELECT message_num FROM chanmsg_counters WHERE channel_id = ? LIMIT 1", channelId).
+		WithContext(ctx).Idempotent(true).Scan(&messageNum)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return messageNum, nil
+}
+
+// GetMessageNums returns the sequence number of the latest message of each of the channels, which the read counts
+// are taken against, leaving out channels with no message
+func (repo *ChatRepoImpl) GetMessageNums(ctx context.Context, channelIds []uint64) (map[uint64]int64, error) {
+	messageNums := make(map[uint64]int64)
+	if len(channelIds) == 0 {
+		return messageNums, nil
+	}
+
+	iteration := repo.session.Query("SELECT channel_id, seq FROM channel_seqs WHERE channel_id IN ?", channelIds).
+		WithContext(ctx).Idempotent(true).Iter()
+
+	var channelId uint64
+	var messageNum int64
+
+	for iteration.Scan(&channelId, &messageNum) {
+		messageNums[channelId] = messageNum
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return messageNums, nil
+}
+
+func (repo *ChatRepoImpl) GetLastMessage(ctx context.Context, channelId uint64) (*Message, error) {
+	var message Message
+	if err := repo.session.Query("SELECT "+messageColumns+" FROM messages WHERE channel_id = ? LIMIT 1", channelId).
+		WithContext(ctx).Idempotent(true).Scan(messageFields(&message)...); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// GetLastMessages returns the latest message of each of the channels, leaving out channels with no message
+func (repo *ChatRepoImpl) GetLastMessages(ctx context.Context, channelIds []uint64) (map[uint64]*Message, error) {
+	lastMessages := make(map[uint64]*Message)
+	if len(channelIds) == 0 {
+		return lastMessages, nil
+	}
+
+	scanner := repo.session.Query("SELECT "+messageColumns+" FROM messages WHERE channel_id IN ? PER PARTITION LIMIT 1", channelIds).
+		WithContext(ctx).Idempotent(true).Iter().Scanner()
+
+	for scanner.Next() {
+		var message Message
+		if err := scanner.Scan(messageFields(&message)...); err != nil {
+			return nil, err
+		}
+
+		lastMessages[message.ChannelId] = &message
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lastMessages, nil
+}
+
+func (repo *ChatRepoImpl) ListReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error) {
+	iteration := repo.session.Query("SELECT user_id, message_id FROM channel_read_cursors WHERE channel_id = ?", channelId).WithContext(ctx).Idempotent(true).Iter()
+
+	var cursors []*ReadCursor
+	var cursor ReadCursor
+
+	for iteration.Scan(&cursor.UserId, &cursor.MessageId) {
+		cursors = append(cursors, &ReadCursor{
+			UserId:    cursor.UserId,
+			MessageId: cursor.MessageId,
+		})
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return cursors, nil
+}
+
+func (repo *ChatRepoImpl) GetMessage(ctx context.Context, channelId uint64, messageId uint64) (*Message, error) {
+	var message Message
+	if err := repo.session.Query("SELECT "+messageColumns+" FROM messages WHERE channel_id = ? AND id = ? LIMIT 1", channelId, messageId).
+		WithContext(ctx).Idempotent(true).Scan(messageFields(&message)...); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, common.ErrorMessageNotFound
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (repo *ChatRepoImpl) GetMessages(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Message, error) {
+	if len(messageIds) == 0 {
+		return nil, nil
+	}
+
+	scanner := repo.session.Query("SELECT "+messageColumns+" FROM messages WHERE channel_id = ? AND id IN ?", channelId, messageIds).
+		WithContext(ctx).Idempotent(true).Iter().Scanner()
+
+	var messages []*Message
+	for scanner.Next() {
+		var message Message
+		if err := scanner.Scan(messageFields(&message)...); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &message)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (repo *ChatRepoImpl) EditMessage(ctx context.Context, channelId uint64, messageId uint64, payload string, editedAt int64) error {
+	if err := repo.session.Query("UPDATE messages SET payload = ?, edited = true, edited_at = ? WHERE channel_id = ? AND id = ?", payload, editedAt, channelId, messageId).
+		WithContext(ctx).Idempotent(true).Exec(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *ChatRepoImpl) DeleteMessage(ctx context.Context, channelId uint64, messageId uint64) error {
+	if err := repo.session.Query("UPDATE messages SET payload = '', deleted = true WHERE channel_id = ? AND id = ?", channelId, messageId).
 		WithContext(ctx).Idempotent(true).Exec(); err != nil {
 		return err
 	}
@@ -234,7 +747,108 @@ func (repo *ChatRepoImpl) PublishMessage(ctx context.Context, chatMessage *Messa
 	)
 }
 
-func (repo *ChatRepoImpl) ListMessages(ctx context.Context, channelId uint64, pageStateBase64 string) ([]*Message, string, error) {
+func (repo *ChatRepoImpl) PublishReport(ctx context.Context, report *Report) error {
+	return repo.publisher.Publish(
+		common.ReportPubTopic,
+		message.NewMessage(
+			watermill.NewUUID(),
+			report.Encode(),
+		),
+	)
+}
+
+func (repo *ChatRepoImpl) PublishNotification(ctx context.Context, notification *common.Notification) error {
+	return repo.publisher.Publish(
+		common.NotificationPubTopic,
+		message.NewMessage(
+			watermill.NewUUID(),
+			notification.Encode(),
+		),
+	)
+}
+
+func (repo *ChatRepoImpl) ListMessages(ctx context.Context, channelId uint64, query *MessageQuery) ([]*Message, string, error) {
+	if !query.IsRange() {
+		pageSize := repo.pagination
+		if query.Limit > 0 && query.Limit < pageSize {
+			pageSize = query.Limit
+		}
+		return repo.pageMessages(ctx, query.PageState, pageSize, "SELECT "+messageColumns+" FROM messages WHERE channel_id = ?", channelId)
+	}
+
+	messages, err := repo.rangeMessages(ctx, channelId, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return messages, "", nil
+}
+
+// rangeMessages walks the id clustering order from the nearest bound, so a window after a message
+// holds the messages right after it rather than the newest ones of the channel.
+func (repo *ChatRepoImpl) rangeMessages(ctx context.Context, channelId uint64, query *MessageQuery) ([]*Message, error) {
+	limit := repo.queryLimit
+	if query.Limit > 0 {
+		limit = min(query.Limit, repo.pagination)
+	}
+
+	statement := "SELECT " + messageColumns + " FROM messages WHERE channel_id = ?"
+	values := []interface{}{channelId}
+
+	if query.Before != 0 {
+		statement += " AND id < ?"
+		values = append(values, query.Before)
+	}
+	if query.After != 0 {
+		statement += " AND id > ?"
+		values = append(values, query.After)
+	}
+	if query.Since != 0 {
+		statement += " AND timestamp >= ?"
+		values = append(values, query.Since)
+	}
+
+	ascending := query.Before == 0
+	if ascending {
+		statement += " ORDER BY id ASC"
+	}
+
+	statement += " LIMIT ?"
+	values = append(values, limit)
+
+	// timestamp is not part of the primary key, filtering is bounded to a single partition
+	if query.Since != 0 {
+		statement += " ALLOW FILTERING"
+	}
+
+	scanner := repo.session.Query(statement, values...).WithContext(ctx).Idempotent(true).Iter().Scanner()
+
+	var messages []*Message
+	for scanner.Next() {
+		var message Message
+		if err := scanner.Scan(messageFields(&message)...); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &message)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if ascending {
+		slices.Reverse(messages)
+	}
+
+	return messages, nil
+}
+
+func (repo *ChatRepoImpl) ListReplies(ctx context.Context, channelId uint64, parentId uint64, pageStateBase64 string) ([]*Message, string, error) {
+	return repo.pageMessages(ctx, pageStateBase64, repo.pagination, "SELECT "+messageColumns+" FROM messages WHERE channel_id = ? AND reply_to = ?", channelId, parentId)
+}
+
+func (repo *ChatRepoImpl) pageMessages(ctx context.Context, pageStateBase64 string, pageSize int, statement string, values ...interface{}) ([]*Message, string, error) {
 	var messages []*Message
 
 	pageState, err := base64.URLEncoding.DecodeString(pageStateBase64)
@@ -242,21 +856,14 @@ func (repo *ChatRepoImpl) ListMessages(ctx context.Context, channelId uint64, pa
 		return nil, "", err
 	}
 
-	iteration := repo.session.Query("SELECT id, event, channel_id, user_id, payload, seen, timestamp FROM messages WHERE channel_id = ?", channelId).
-		WithContext(ctx).Idempotent(true).PageSize(repo.pagination).PageState(pageState).Iter()
+	iteration := repo.session.Query(statement, values...).
+		WithContext(ctx).Idempotent(true).PageSize(pageSize).PageState(pageState).Iter()
 	nextPageStateBase64 := base64.URLEncoding.EncodeToString(iteration.PageState())
 	scanner := iteration.Scanner()
 
 	for scanner.Next() {
 		var message Message
-		if err := scanner.Scan(
-			&message.MessageId,
-			&message.Event,
-			&message.ChannelId,
-			&message.UserId,
-			&message.Payload,
-			&message.Seen,
-			&message.Time); err != nil {
+		if err := scanner.Scan(messageFields(&message)...); err != nil {
 			return nil, "", err
 		}
 
@@ -271,6 +878,50 @@ func (repo *ChatRepoImpl) ListMessages(ctx context.Context, channelId uint64, pa
 	return messages, nextPageStateBase64, nil
 }
 
+func (repo *ChatRepoImpl) AddReaction(ctx context.Context, channelId uint64, reaction *Reaction) error {
+	if err := repo.session.Query("INSERT INTO message_reactions (channel_id, message_id, emoji, user_id) VALUES (?, ?, ?, ?)",
+		channelId, reaction.MessageId, reaction.Emoji, reaction.UserId).WithContext(ctx).Idempotent(true).Exec(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *ChatRepoImpl) RemoveReaction(ctx context.Context, channelId uint64, reaction *Reaction) error {
+	if err := repo.session.Query("DELETE FROM message_reactions WHERE channel_id = ? AND message_id = ? AND emoji = ? AND user_id = ?",
+		channelId, reaction.MessageId, reaction.Emoji, reaction.UserId).WithContext(ctx).Idempotent(true).Exec(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *ChatRepoImpl) ListReactions(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Reaction, error) {
+	if len(messageIds) == 0 {
+		return nil, nil
+	}
+
+	iteration := repo.session.Query("SELECT message_id, emoji, user_id FROM message_reactions WHERE channel_id = ? AND message_id IN ?", channelId, messageIds).
+		WithContext(ctx).Idempotent(true).Iter()
+
+	var reactions []*Reaction
+	var reaction Reaction
+
+	for iteration.Scan(&reaction.MessageId, &reaction.Emoji, &reaction.UserId) {
+		reactions = append(reactions, &Reaction{
+			MessageId: reaction.MessageId,
+			UserId:    reaction.UserId,
+			Emoji:     reaction.Emoji,
+		})
+	}
+
+	if err := iteration.Close(); err != nil {
+		return nil, err
+	}
+
+	return reactions, nil
+}
+
 func (repo *ForwarderRepoImpl) RegisterChannelSession(ctx context.Context, channelId uint64, userId uint64, subscriber string) error {
 	request := &forwarderProto.RegisterChannelSessionRequest{
 		ChannelId:  channelId,
@@ -297,3 +948,18 @@ func (repo *ForwarderRepoImpl) RemoveChannelSession(ctx context.Context, channel
 
 	return nil
 }
+
+func messageFields(message *Message) []interface{} {
+	return []interface{}{
+		&message.MessageId,
+		&message.Event,
+		&message.ChannelId,
+		&message.UserId,
+		&message.Payload,
+		&message.ReplyTo,
+		&message.Edited,
+		&message.EditedAt,
+		&message.Deleted,
+		&message.Time,
+	}
+}
@@ -2,9 +2,13 @@ package chat
 
 import (
 	"context"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 	"github.com/thyyl/chatr/pkg/infra"
 )
 
@@ -12,24 +16,60 @@ import (
 // Repository Interfaces
 // ============================
 type UserRepoCache interface {
-	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error
+	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error
+	RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) error
 	GetUserById(ctx context.Context, userId uint64) (*User, error)
 	IsChannelUserExists(ctx context.Context, channelId uint64, userId uint64) (bool, error)
 	GetChannelUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
-	AddOnlineUser(ctx context.Context, channelId uint64, userId uint64) error
+	AddOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error)
+	DeleteOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error)
 	DeleteOnlineUser(ctx context.Context, channelId uint64, userId uint64) error
 	GetOnlineUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
+	GetUserChannelIds(ctx context.Context, userId uint64) ([]uint64, error)
+	GetUserIdBySession(ctx context.Context, session string) (uint64, error)
+	UpdateLastSeen(ctx context.Context, userId uint64, lastSeen int64) error
+	GetLastSeen(ctx context.Context, userIds []uint64) (map[uint64]int64, error)
+	SendFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error)
 }
 
 type ChatRepoCache interface {
 	InsertMessage(ctx context.Context, chatMessage *Message) error
-	MarkMessageSeen(ctx context.Context, channelId uint64, messageId uint64) error
+	ClaimClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string, messageId uint64) (bool, uint64, error)
+	ReleaseClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error
+	ClaimClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) (bool, error)
+	ReleaseClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error
+	UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) (bool, error)
+	ListReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error)
+	GetReadNums(ctx context.Context, channelIds []uint64, userId uint64) (map[uint64]int64, error)
+	GetMessageNums(ctx context.Context, channelIds []uint64) (map[uint64]int64, error)
+	GetLastMessage(ctx context.Context, channelId uint64) (*Message, error)
+	GetLastMessages(ctx context.Context, channelIds []uint64) (map[uint64]*Message, error)
+	GetMessage(ctx context.Context, channelId uint64, messageId uint64) (*Message, error)
+	GetMessages(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Message, error)
+	EditMessage(ctx context.Context, channelId uint64, messageId uint64, payload string, editedAt int64) error
+	DeleteMessage(ctx context.Context, channelId uint64, messageId uint64) error
+	AddMessageChange(ctx context.Context, channelId uint64, messageId uint64, changedAt int64) error
+	ListMessageChanges(ctx context.Context, channelId uint64, since int64) ([]uint64, error)
 	PublishMessage(ctx context.Context, chatMessage *Message) error
-	ListMessages(ctx context.Context, channelId uint64, pageState string) ([]*Message, string, error)
+	PublishReport(ctx context.Context, report *Report) error
+	PublishNotification(ctx context.Context, notification *common.Notification) error
+	ListMessages(ctx context.Context, channelId uint64, query *MessageQuery) ([]*Message, string, error)
+	ListReplies(ctx context.Context, channelId uint64, parentId uint64, pageState string) ([]*Message, string, error)
+	AddReaction(ctx context.Context, channelId uint64, reaction *Reaction) error
+	RemoveReaction(ctx context.Context, channelId uint64, reaction *Reaction) error
+	ListReactions(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Reaction, error)
 }
 
 type ChannelRepoCache interface {
-	CreateChannel(ctx context.Context, channelId uint64) (*Channel, error)
+	CreateChannel(ctx context.Context, channelId uint64, name string, encrypted bool) (*Channel, error)
+	GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error)
+	IsChannelEncrypted(ctx context.Context, channelId uint64) (bool, error)
+	GetChannelsEncrypted(ctx context.Context, channelIds []uint64) (map[uint64]bool, error)
+	SetMemberRole(ctx context.Context, channelId uint64, userId uint64, role Role) error
+	MuteMember(ctx context.Context, channelId uint64, userId uint64, duration time.Duration) (int64, error)
+	GetMutedUntil(ctx context.Context, channelId uint64, userId uint64) (int64, error)
+	BanUser(ctx context.Context, channelId uint64, userId uint64) error
+	IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error)
 	DeleteChannel(ctx context.Context, channelId uint64) error
 }
 
@@ -37,24 +77,33 @@ type ChannelRepoCache interface {
 // Repository Implementations
 // ============================
 type UserRepoCacheImpl struct {
-	redis    infra.RedisCache
-	userRepo UserRepo
+	redis       infra.RedisCache
+	userRepo    UserRepo
+	presenceTtl time.Duration
 }
 
-func NewUserRepoCacheImpl(redis infra.RedisCache, userRepo UserRepo) *UserRepoCacheImpl {
+func NewUserRepoCacheImpl(redis infra.RedisCache, userRepo UserRepo, config *config.Config) *UserRepoCacheImpl {
 	return &UserRepoCacheImpl{
-		redis:    redis,
-		userRepo: userRepo,
+		redis:       redis,
+		userRepo:    userRepo,
+		presenceTtl: time.Duration(config.Chat.Presence.TtlSecond) * time.Second,
 	}
 }
 
 type ChatRepoCacheImpl struct {
-	chatRepo ChatRepo
+	redis                 infra.RedisCache
+	chatRepo              ChatRepo
+	clientMessageIdWindow time.Duration
+	maxMessageChanges     int64
 }
 
-func NewChatRepoCacheImpl(chatRepo ChatRepo) *ChatRepoCacheImpl {
+func NewChatRepoCacheImpl(redis infra.RedisCache, chatRepo ChatRepo, config *config.Config) *ChatRepoCacheImpl {
 	return &ChatRepoCacheImpl{
-		chatRepo: chatRepo,
+		redis:                 redis,
+		chatRepo:              chatRepo,
+		clientMessageIdWindow: time.Duration(config.Chat.Message.IdempotencyWindowSecond) * time.Second,
+		// one change more than a replay sends tells a reconnecting client that it missed too many
+		maxMessageChanges: int64(config.Chat.Message.ReplayNum) + 1,
 	}
 }
 
@@ -73,13 +122,23 @@ func NewChannelRepoCacheImpl(redis infra.RedisCache, channelRepo ChannelRepo) *C
 // ============================
 // Repository Functions
 // ============================
-func (cache *UserRepoCacheImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error {
-	if err := cache.userRepo.AddUserToChannel(ctx, channelId, userId); err != nil {
+func (cache *UserRepoCacheImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error {
+	if err := cache.userRepo.AddUserToChannel(ctx, channelId, userId, role); err != nil {
 		return err
 	}
 
+	// adding the user to an expired hash would leave it holding only this user, so it is rebuilt on the next read instead
 	key := constructKey(common.ChannelUsersRcKey, channelId)
-	return cache.redis.HSet(ctx, key, strconv.FormatUint(userId, 10), 1)
+	return cache.redis.Delete(ctx, key)
+}
+
+func (cache *UserRepoCacheImpl) RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) error {
+	if err := cache.userRepo.RemoveUserFromChannel(ctx, channelId, userId); err != nil {
+		return err
+	}
+
+	key := constructKey(common.ChannelUsersRcKey, channelId)
+	return cache.redis.HDel(ctx, key, strconv.FormatUint(userId, 10))
 }
 
 func (cache *UserRepoCacheImpl) GetUserById(ctx context.Context, userId uint64) (*User, error) {
@@ -164,56 +223,305 @@ func (cache *UserRepoCacheImpl) GetChannelUserIds(ctx context.Context, channelId
 	return userIds, nil
 }
 
-func (cache *UserRepoCacheImpl) AddOnlineUser(ctx context.Context, channelId uint64, userId uint64) error {
-	key := constructKey(common.OnlineUsersRcKey, channelId)
-	userKey := strconv.FormatUint(userId, 10)
-	return cache.redis.HSet(ctx, key, userKey, 1)
+// AddOnlineSession marks a session of the user as live until the presence TTL elapses. Calling it again
+// for the same session acts as a heartbeat.
+func (cache *UserRepoCacheImpl) AddOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error) {
+	now := time.Now()
+	key := constructKey(common.OnlineSessionsRcKey, channelId)
+	expired, live, err := cache.redis.ZAddAndPrune(ctx, key, float64(now.Add(cache.presenceTtl).UnixMilli()), constructSessionMember(userId, sessionId), float64(now.UnixMilli()))
+	if err != nil {
+		return nil, err
+	}
+
+	return toPresence(userId, expired, live)
+}
+
+func (cache *UserRepoCacheImpl) DeleteOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error) {
+	key := constructKey(common.OnlineSessionsRcKey, channelId)
+	expired, live, err := cache.redis.ZRemAndPrune(ctx, key, constructSessionMember(userId, sessionId), float64(time.Now().UnixMilli()))
+	if err != nil {
+		return nil, err
+	}
+
+	return toPresence(userId, expired, live)
 }
 
+// DeleteOnlineUser drops every session of the user in the channel, live or expired
 func (cache *UserRepoCacheImpl) DeleteOnlineUser(ctx context.Context, channelId uint64, userId uint64) error {
-	key := constructKey(common.OnlineUsersRcKey, channelId)
-	userKey := strconv.FormatUint(userId, 10)
-	return cache.redis.HDel(ctx, key, userKey)
+	key := constructKey(common.OnlineSessionsRcKey, channelId)
+	members, err := cache.redis.ZRangeByScore(ctx, key, "-inf", "+inf")
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		memberUserId, err := parseSessionMember(member)
+		if err != nil {
+			return err
+		}
+		if memberUserId != userId {
+			continue
+		}
+		if _, err := cache.redis.ZRemOne(ctx, key, member); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// GetOnlineUserIds returns the users with at least one live session. Expired sessions are only pruned when a session
+// of the channel is added or removed, so the set is always read by score and never as a whole.
 func (cache *UserRepoCacheImpl) GetOnlineUserIds(ctx context.Context, channelId uint64) ([]uint64, error) {
-	key := constructKey(common.OnlineUsersRcKey, channelId)
+	key := constructKey(common.OnlineSessionsRcKey, channelId)
 
-	userMap, err := cache.redis.HGetAll(ctx, key)
+	// sessions are scored by their expiry, so the live ones are those scored after now
+	members, err := cache.redis.ZRangeByScore(ctx, key, "("+strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf")
 	if err != nil {
 		return nil, err
 	}
 
-	var userIds []uint64
-	for userIdStr := range userMap {
-		userId, err := strconv.ParseUint(userIdStr, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		userIds = append(userIds, userId)
+	presence, err := toPresence(0, nil, members)
+	if err != nil {
+		return nil, err
 	}
 
-	return userIds, nil
+	return presence.UserIds, nil
+}
+
+func (cache *UserRepoCacheImpl) GetUserChannelIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	return cache.userRepo.GetUserChannelIds(ctx, userId)
+}
+
+func (cache *UserRepoCacheImpl) GetUserIdBySession(ctx context.Context, session string) (uint64, error) {
+	return cache.userRepo.GetUserIdBySession(ctx, session)
+}
+
+func (cache *UserRepoCacheImpl) UpdateLastSeen(ctx context.Context, userId uint64, lastSeen int64) error {
+	return cache.userRepo.UpdateLastSeen(ctx, userId, lastSeen)
+}
+
+func (cache *UserRepoCacheImpl) GetLastSeen(ctx context.Context, userIds []uint64) (map[uint64]int64, error) {
+	return cache.userRepo.GetLastSeen(ctx, userIds)
+}
+
+func (cache *UserRepoCacheImpl) SendFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error) {
+	return cache.userRepo.SendFriendRequest(ctx, userId, peerId)
 }
 
 func (cache *ChatRepoCacheImpl) InsertMessage(ctx context.Context, chatMessage *Message) error {
 	return cache.chatRepo.InsertMessage(ctx, chatMessage)
 }
 
-func (cache *ChatRepoCacheImpl) MarkMessageSeen(ctx context.Context, channelId uint64, messageId uint64) error {
-	return cache.chatRepo.MarkMessageSeen(ctx, channelId, messageId)
+// ClaimClientMessageId binds a client message id to messageId for the idempotency window. When the id is
+// already bound, it reports false along with the message id of the first send.
+func (cache *ChatRepoCacheImpl) ClaimClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string, messageId uint64) (bool, uint64, error) {
+	key := constructClientMessageKey(channelId, userId, clientMessageId)
+
+	// the binding may expire between both commands, in which case claiming is attempted once more
+	for range 2 {
+		claimed, err := cache.redis.SetNX(ctx, key, messageId, cache.clientMessageIdWindow)
+		if err != nil {
+			return false, 0, err
+		}
+		if claimed {
+			return true, messageId, nil
+		}
+
+		var originalId uint64
+		exist, err := cache.redis.Get(ctx, key, &originalId)
+		if err != nil {
+			return false, 0, err
+		}
+		if exist {
+			return false, originalId, nil
+		}
+	}
+
+	return false, 0, fmt.Errorf("error claim client message id %s: binding expired while claiming", clientMessageId)
+}
+
+func (cache *ChatRepoCacheImpl) ReleaseClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error {
+	return cache.redis.Delete(ctx, constructClientMessageKey(channelId, userId, clientMessageId))
+}
+
+// ClaimClientMessagePublish reports whether the message of a client message id is to be published by the caller,
+// which is true for a single send within the idempotency window until the claim is released
+func (cache *ChatRepoCacheImpl) ClaimClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) (bool, error) {
+	key := common.Join(constructClientMessageKey(channelId, userId, clientMessageId), ":published")
+	return cache.redis.SetNX(ctx, key, 1, cache.clientMessageIdWindow)
+}
+
+func (cache *ChatRepoCacheImpl) ReleaseClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error {
+	return cache.redis.Delete(ctx, common.Join(constructClientMessageKey(channelId, userId, clientMessageId), ":published"))
+}
+
+func (cache *ChatRepoCacheImpl) UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) (bool, error) {
+	return cache.chatRepo.UpdateReadCursor(ctx, channelId, userId, messageId)
+}
+
+func (cache *ChatRepoCacheImpl) ListReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error) {
+	return cache.chatRepo.ListReadCursors(ctx, channelId)
+}
+
+func (cache *ChatRepoCacheImpl) GetReadNums(ctx context.Context, channelIds []uint64, userId uint64) (map[uint64]int64, error) {
+	return cache.chatRepo.GetReadNums(ctx, channelIds, userId)
+}
+
+func (cache *ChatRepoCacheImpl) GetMessageNums(ctx context.Context, channelIds []uint64) (map[uint64]int64, error) {
+	return cache.chatRepo.GetMessageNums(ctx, channelIds)
+}
+
+func (cache *ChatRepoCacheImpl) GetLastMessage(ctx context.Context, channelId uint64) (*Message, error) {
+	return cache.chatRepo.GetLastMessage(ctx, channelId)
+}
+
+func (cache *ChatRepoCacheImpl) GetLastMessages(ctx context.Context, channelIds []uint64) (map[uint64]*Message, error) {
+	return cache.chatRepo.GetLastMessages(ctx, channelIds)
+}
+
+func (cache *ChatRepoCacheImpl) GetMessage(ctx context.Context, channelId uint64, messageId uint64) (*Message, error) {
+	return cache.chatRepo.GetMessage(ctx, channelId, messageId)
+}
+
+func (cache *ChatRepoCacheImpl) GetMessages(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Message, error) {
+	return cache.chatRepo.GetMessages(ctx, channelId, messageIds)
+}
+
+func (cache *ChatRepoCacheImpl) EditMessage(ctx context.Context, channelId uint64, messageId uint64, payload string, editedAt int64) error {
+	return cache.chatRepo.EditMessage(ctx, channelId, messageId, payload, editedAt)
+}
+
+func (cache *ChatRepoCacheImpl) DeleteMessage(ctx context.Context, channelId uint64, messageId uint64) error {
+	return cache.chatRepo.DeleteMessage(ctx, channelId, messageId)
+}
+
+// AddMessageChange records when a stored message was edited or deleted, so that reconnecting sessions can replay it.
+// Only the latest changes of a channel are kept.
+func (cache *ChatRepoCacheImpl) AddMessageChange(ctx context.Context, channelId uint64, messageId uint64, changedAt int64) error {
+	key := constructKey(common.MessageChangesRcKey, channelId)
+	return cache.redis.ZAddAndTrim(ctx, key, float64(changedAt), strconv.FormatUint(messageId, 10), cache.maxMessageChanges)
+}
+
+// ListMessageChanges returns the ids of the messages changed since the given time, in the order of their last change
+func (cache *ChatRepoCacheImpl) ListMessageChanges(ctx context.Context, channelId uint64, since int64) ([]uint64, error) {
+	key := constructKey(common.MessageChangesRcKey, channelId)
+	members, err := cache.redis.ZRangeByScore(ctx, key, strconv.FormatInt(since, 10), "+inf")
+	if err != nil {
+		return nil, err
+	}
+
+	messageIds := make([]uint64, 0, len(members))
+	for _, member := range members {
+		messageId, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		messageIds = append(messageIds, messageId)
+	}
+	return messageIds, nil
 }
 
 func (cache *ChatRepoCacheImpl) PublishMessage(ctx context.Context, chatMessage *Message) error {
 	return cache.chatRepo.PublishMessage(ctx, chatMessage)
 }
 
-func (cache *ChatRepoCacheImpl) ListMessages(ctx context.Context, channelId uint64, pageState string) ([]*Message, string, error) {
-	return cache.chatRepo.ListMessages(ctx, channelId, pageState)
+func (cache *ChatRepoCacheImpl) PublishReport(ctx context.Context, report *Report) error {
+	return cache.chatRepo.PublishReport(ctx, report)
+}
+
+func (cache *ChatRepoCacheImpl) PublishNotification(ctx context.Context, notification *common.Notification) error {
+	return cache.chatRepo.PublishNotification(ctx, notification)
+}
+
+func (cache *ChatRepoCacheImpl) ListMessages(ctx context.Context, channelId uint64, query *MessageQuery) ([]*Message, string, error) {
+	return cache.chatRepo.ListMessages(ctx, channelId, query)
+}
+
+func (cache *ChatRepoCacheImpl) ListReplies(ctx context.Context, channelId uint64, parentId uint64, pageState string) ([]*Message, string, error) {
+	return cache.chatRepo.ListReplies(ctx, channelId, parentId, pageState)
+}
+
+func (cache *ChatRepoCacheImpl) AddReaction(ctx context.Context, channelId uint64, reaction *Reaction) error {
+	return cache.chatRepo.AddReaction(ctx, channelId, reaction)
+}
+
+func (cache *ChatRepoCacheImpl) RemoveReaction(ctx context.Context, channelId uint64, reaction *Reaction) error {
+	return cache.chatRepo.RemoveReaction(ctx, channelId, reaction)
+}
+
+func (cache *ChatRepoCacheImpl) ListReactions(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Reaction, error) {
+	return cache.chatRepo.ListReactions(ctx, channelId, messageIds)
+}
+
+func (cache *ChannelRepoCacheImpl) CreateChannel(ctx context.Context, channelId uint64, name string, encrypted bool) (*Channel, error) {
+	return cache.channelRepo.CreateChannel(ctx, channelId, name, encrypted)
+}
+
+func (cache *ChannelRepoCacheImpl) GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error) {
+	return cache.channelRepo.GetChannelMembers(ctx, channelId)
+}
+
+// IsChannelEncrypted is asked for every message sent, the flag never changes once the channel exists so it is
+// cached as it is read
+func (cache *ChannelRepoCacheImpl) IsChannelEncrypted(ctx context.Context, channelId uint64) (bool, error) {
+	key := constructKey(common.EncryptedRcKey, channelId)
+	var encrypted bool
+	exist, err := cache.redis.Get(ctx, key, &encrypted)
+	if err != nil {
+		return false, err
+	}
+	if exist {
+		return encrypted, nil
+	}
+
+	encrypted, err = cache.channelRepo.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return false, err
+	}
+	// stored as "true" or "false", which reads back as JSON
+	if err := cache.redis.Set(ctx, key, strconv.FormatBool(encrypted)); err != nil {
+		return false, err
+	}
+
+	return encrypted, nil
+}
+
+// GetChannelsEncrypted reads the flags in one query rather than through the per-channel cache
+func (cache *ChannelRepoCacheImpl) GetChannelsEncrypted(ctx context.Context, channelIds []uint64) (map[uint64]bool, error) {
+	return cache.channelRepo.GetChannelsEncrypted(ctx, channelIds)
 }
 
-func (cache *ChannelRepoCacheImpl) CreateChannel(ctx context.Context, channelId uint64) (*Channel, error) {
-	return cache.channelRepo.CreateChannel(ctx, channelId)
+func (cache *ChannelRepoCacheImpl) SetMemberRole(ctx context.Context, channelId uint64, userId uint64, role Role) error {
+	return cache.channelRepo.SetMemberRole(ctx, channelId, userId, role)
+}
+
+// MuteMember mutes the user for the given duration and returns when the mute ends in unix milliseconds.
+// Mutes only live in redis; the key expires together with the mute.
+func (cache *ChannelRepoCacheImpl) MuteMember(ctx context.Context, channelId uint64, userId uint64, duration time.Duration) (int64, error) {
+	mutedUntil := time.Now().Add(duration).UnixMilli()
+	if err := cache.redis.SetEx(ctx, constructMuteKey(channelId, userId), mutedUntil, duration); err != nil {
+		return 0, err
+	}
+
+	return mutedUntil, nil
+}
+
+// GetMutedUntil returns when the mute of the user ends in unix milliseconds, or 0 if the user is not muted
+func (cache *ChannelRepoCacheImpl) GetMutedUntil(ctx context.Context, channelId uint64, userId uint64) (int64, error) {
+	var mutedUntil int64
+	if _, err := cache.redis.Get(ctx, constructMuteKey(channelId, userId), &mutedUntil); err != nil {
+		return 0, err
+	}
+
+	return mutedUntil, nil
+}
+
+func (cache *ChannelRepoCacheImpl) BanUser(ctx context.Context, channelId uint64, userId uint64) error {
+	return cache.channelRepo.BanUser(ctx, channelId, userId)
+}
+
+func (cache *ChannelRepoCacheImpl) IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error) {
+	return cache.channelRepo.IsUserBanned(ctx, channelId, userId)
 }
 
 func (cache *ChannelRepoCacheImpl) DeleteChannel(ctx context.Context, channelId uint64) error {
@@ -222,9 +530,18 @@ func (cache *ChannelRepoCacheImpl) DeleteChannel(ctx context.Context, channelId
 	}
 
 	channelUsersKey := constructKey(common.ChannelUsersRcKey, channelId)
+	onlineSessionsKey := constructKey(common.OnlineSessionsRcKey, channelId)
 	onlineUsersKey := constructKey(common.OnlineUsersRcKey, channelId)
+	encryptedKey := constructKey(common.EncryptedRcKey, channelId)
+	messageChangesKey := constructKey(common.MessageChangesRcKey, channelId)
 
 	cmds := []infra.RedisCmd{
+		{
+			OpType: infra.DELETE,
+			Payload: infra.RedisDeletePayload{
+				Key: onlineSessionsKey,
+			},
+		},
 		{
 			OpType: infra.DELETE,
 			Payload: infra.RedisDeletePayload{
@@ -237,6 +554,18 @@ func (cache *ChannelRepoCacheImpl) DeleteChannel(ctx context.Context, channelId
 				Key: channelUsersKey,
 			},
 		},
+		{
+			OpType: infra.DELETE,
+			Payload: infra.RedisDeletePayload{
+				Key: encryptedKey,
+			},
+		},
+		{
+			OpType: infra.DELETE,
+			Payload: infra.RedisDeletePayload{
+				Key: messageChangesKey,
+			},
+		},
 	}
 
 	return cache.redis.ExecPipeLine(ctx, &cmds)
@@ -245,3 +574,56 @@ func (cache *ChannelRepoCacheImpl) DeleteChannel(ctx context.Context, channelId
 func constructKey(prefix string, id uint64) string {
 	return common.Join(prefix, ":", strconv.FormatUint(id, 10))
 }
+
+func constructClientMessageKey(channelId uint64, userId uint64, clientMessageId string) string {
+	return common.Join(common.ClientMessageRcKey, ":", strconv.FormatUint(channelId, 10), ":", strconv.FormatUint(userId, 10), ":", clientMessageId)
+}
+
+func constructMuteKey(channelId uint64, userId uint64) string {
+	return common.Join(common.MuteRcKey, ":", strconv.FormatUint(channelId, 10), ":", strconv.FormatUint(userId, 10))
+}
+
+func constructSessionMember(userId uint64, sessionId string) string {
+	return common.Join(strconv.FormatUint(userId, 10), ":", sessionId)
+}
+
+// toPresence folds the expired and live session members of a channel into per-user liveness
+func toPresence(userId uint64, expired []string, live []string) (*Presence, error) {
+	var presence Presence
+	liveUsers := make(map[uint64]struct{})
+	for _, member := range live {
+		memberUserId, err := parseSessionMember(member)
+		if err != nil {
+			return nil, err
+		}
+		if memberUserId == userId {
+			presence.SessionCount++
+		}
+		if _, ok := liveUsers[memberUserId]; !ok {
+			liveUsers[memberUserId] = struct{}{}
+			presence.UserIds = append(presence.UserIds, memberUserId)
+		}
+	}
+
+	expiredUsers := make(map[uint64]struct{})
+	for _, member := range expired {
+		memberUserId, err := parseSessionMember(member)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := liveUsers[memberUserId]; ok {
+			continue
+		}
+		if _, ok := expiredUsers[memberUserId]; !ok {
+			expiredUsers[memberUserId] = struct{}{}
+			presence.ExpiredUserIds = append(presence.ExpiredUserIds, memberUserId)
+		}
+	}
+
+	return &presence, nil
+}
+
+func parseSessionMember(member string) (uint64, error) {
+	userId, _, _ := strings.Cut(member, ":")
+	return strconv.ParseUint(userId, 10, 64)
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/infra/infratest"
+)
+
+func newTestUserRepoCache(t *testing.T) (*UserRepoCacheImpl, *miniredis.Miniredis) {
+	t.Helper()
+
+	config, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, mr := infratest.NewRedisClient(t)
+	return NewUserRepoCacheImpl(infra.NewRedisCacheImpl(client), nil, config), mr
+}
+
+func TestOnlineUserIdsSkipExpiredSessions(t *testing.T) {
+	cache, mr := newTestUserRepoCache(t)
+	ctx := context.Background()
+
+	if _, err := cache.AddOnlineSession(ctx, 1, 10, "a"); err != nil {
+		t.Fatal(err)
+	}
+	// a session that stopped sending heartbeats without any other session writing since
+	key := constructKey(common.OnlineSessionsRcKey, 1)
+	if _, err := mr.ZAdd(key, float64(time.Now().Add(-time.Second).UnixMilli()), constructSessionMember(11, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	userIds, err := cache.GetOnlineUserIds(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(userIds, []uint64{10}) {
+		t.Fatalf("expected only the live user, got %v", userIds)
+	}
+}
+
+func TestOnlineSessionsIgnoreLegacyPresence(t *testing.T) {
+	cache, mr := newTestUserRepoCache(t)
+	ctx := context.Background()
+
+	// presence written by pods that still keep online users in a hash
+	mr.HSet(constructKey(common.OnlineUsersRcKey, 1), "12", "1")
+
+	presence, err := cache.AddOnlineSession(ctx, 1, 10, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(presence.UserIds, []uint64{10}) {
+		t.Fatalf("expected only the session user, got %v", presence.UserIds)
+	}
+	if _, err := cache.DeleteOnlineSession(ctx, 1, 10, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteOnlineUserDropsEverySession(t *testing.T) {
+	cache, _ := newTestUserRepoCache(t)
+	ctx := context.Background()
+
+	for _, session := range []struct {
+		userId    uint64
+		sessionId string
+	}{{10, "a"}, {10, "b"}, {11, "c"}} {
+		if _, err := cache.AddOnlineSession(ctx, 1, session.userId, session.sessionId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.DeleteOnlineUser(ctx, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	userIds, err := cache.GetOnlineUserIds(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(userIds, []uint64{11}) {
+		t.Fatalf("expected only the remaining user, got %v", userIds)
+	}
+}
@@ -0,0 +1,315 @@
+package chat
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+)
+
+const (
+	MemoryMessageIndexType = "memory"
+	RedisMessageIndexType  = "redis"
+
+	maxTokenBytes = 64
+)
+
+// MessageIndex is an inverted index over the text messages of each channel
+type MessageIndex interface {
+	IndexMessage(ctx context.Context, message *Message) error
+	RemoveMessage(ctx context.Context, message *Message) error
+	DeleteChannel(ctx context.Context, channelId uint64) error
+	Search(ctx context.Context, channelId uint64, query string, limit int) ([]uint64, error)
+}
+
+func NewMessageIndex(config *config.Config, redis infra.RedisCache) MessageIndex {
+	if config.Chat.Search.Index == MemoryMessageIndexType {
+		return NewMemoryMessageIndex(config.Chat.Search.MaxResults)
+	}
+	return NewRedisMessageIndex(redis, config.Chat.Search.MaxResults, time.Duration(config.Chat.Search.TtlHour)*time.Hour)
+}
+
+// ============================
+// Redis Message Index
+// ============================
+
+// RedisMessageIndex keeps one set of message ids per token. All keys of a channel share a hash tag
+// so that multi-token queries can be answered with a single SINTER in cluster mode. Every indexed message
+// renews the keys of its tokens, so words nobody used for the TTL drop out of the index.
+type RedisMessageIndex struct {
+	redis      infra.RedisCache
+	maxResults int
+	ttl        time.Duration
+}
+
+func NewRedisMessageIndex(redis infra.RedisCache, maxResults int, ttl time.Duration) *RedisMessageIndex {
+	return &RedisMessageIndex{
+		redis:      redis,
+		maxResults: maxResults,
+		ttl:        ttl,
+	}
+}
+
+func (index *RedisMessageIndex) IndexMessage(ctx context.Context, message *Message) error {
+	tokens := tokenize(message.Payload)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var cmds []infra.RedisCmd
+	var members []interface{}
+	for _, token := range tokens {
+		tokenKey := constructTokenKey(message.ChannelId, token)
+		cmds = append(cmds, infra.RedisCmd{
+			OpType: infra.SADD,
+			Payload: infra.RedisSaddPayload{
+				Key:     tokenKey,
+				Members: []interface{}{message.MessageId},
+			},
+		}, infra.RedisCmd{
+			OpType: infra.EXPIRE,
+			Payload: infra.RedisExpirePayload{
+				Key: tokenKey,
+				Ttl: index.ttl,
+			},
+		})
+		members = append(members, token)
+	}
+	// the token list is renewed with every message, so it outlives the keys it lists
+	tokensKey := constructTokensKey(message.ChannelId)
+	cmds = append(cmds, infra.RedisCmd{
+		OpType: infra.SADD,
+		Payload: infra.RedisSaddPayload{
+			Key:     tokensKey,
+			Members: members,
+		},
+	}, infra.RedisCmd{
+		OpType: infra.EXPIRE,
+		Payload: infra.RedisExpirePayload{
+			Key: tokensKey,
+			Ttl: index.ttl,
+		},
+	})
+
+	return index.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (index *RedisMessageIndex) RemoveMessage(ctx context.Context, message *Message) error {
+	tokens := tokenize(message.Payload)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var cmds []infra.RedisCmd
+	for _, token := range tokens {
+		cmds = append(cmds, infra.RedisCmd{
+			OpType: infra.SREM,
+			Payload: infra.RedisSremPayload{
+				Key:     constructTokenKey(message.ChannelId, token),
+				Members: []interface{}{message.MessageId},
+			},
+		})
+	}
+
+	return index.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (index *RedisMessageIndex) DeleteChannel(ctx context.Context, channelId uint64) error {
+	tokensKey := constructTokensKey(channelId)
+	tokens, err := index.redis.SMembers(ctx, tokensKey)
+	if err != nil {
+		return err
+	}
+
+	var cmds []infra.RedisCmd
+	for _, token := range tokens {
+		cmds = append(cmds, infra.RedisCmd{
+			OpType: infra.DELETE,
+			Payload: infra.RedisDeletePayload{
+				Key: constructTokenKey(channelId, token),
+			},
+		})
+	}
+	cmds = append(cmds, infra.RedisCmd{
+		OpType: infra.DELETE,
+		Payload: infra.RedisDeletePayload{
+			Key: tokensKey,
+		},
+	})
+
+	return index.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (index *RedisMessageIndex) Search(ctx context.Context, channelId uint64, query string, limit int) ([]uint64, error) {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, token := range tokens {
+		keys = append(keys, constructTokenKey(channelId, token))
+	}
+
+	members, err := index.redis.SInter(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	var messageIds []uint64
+	for _, member := range members {
+		messageId, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		messageIds = append(messageIds, messageId)
+	}
+
+	return newestFirst(messageIds, limit, index.maxResults), nil
+}
+
+func constructTokenKey(channelId uint64, token string) string {
+	return common.Join(common.SearchIndexRcKey, ":{", strconv.FormatUint(channelId, 10), "}:t:", token)
+}
+
+func constructTokensKey(channelId uint64) string {
+	return common.Join(common.SearchIndexRcKey, ":{", strconv.FormatUint(channelId, 10), "}:tokens")
+}
+
+// ============================
+// Memory Message Index
+// ============================
+
+// MemoryMessageIndex is an embedded inverted index. It only sees messages inserted through
+// the local process, so it suits tests and single replica deployments.
+type MemoryMessageIndex struct {
+	mu         sync.RWMutex
+	channels   map[uint64]map[string]map[uint64]struct{}
+	maxResults int
+}
+
+func NewMemoryMessageIndex(maxResults int) *MemoryMessageIndex {
+	return &MemoryMessageIndex{
+		channels:   make(map[uint64]map[string]map[uint64]struct{}),
+		maxResults: maxResults,
+	}
+}
+
+func (index *MemoryMessageIndex) IndexMessage(ctx context.Context, message *Message) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	postings, ok := index.channels[message.ChannelId]
+	if !ok {
+		postings = make(map[string]map[uint64]struct{})
+		index.channels[message.ChannelId] = postings
+	}
+
+	for _, token := range tokenize(message.Payload) {
+		if _, ok := postings[token]; !ok {
+			postings[token] = make(map[uint64]struct{})
+		}
+		postings[token][message.MessageId] = struct{}{}
+	}
+
+	return nil
+}
+
+func (index *MemoryMessageIndex) RemoveMessage(ctx context.Context, message *Message) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	postings, ok := index.channels[message.ChannelId]
+	if !ok {
+		return nil
+	}
+
+	for _, token := range tokenize(message.Payload) {
+		delete(postings[token], message.MessageId)
+		if len(postings[token]) == 0 {
+			delete(postings, token)
+		}
+	}
+
+	return nil
+}
+
+func (index *MemoryMessageIndex) DeleteChannel(ctx context.Context, channelId uint64) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	delete(index.channels, channelId)
+	return nil
+}
+
+func (index *MemoryMessageIndex) Search(ctx context.Context, channelId uint64, query string, limit int) ([]uint64, error) {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	postings := index.channels[channelId]
+
+	var messageIds []uint64
+	for messageId := range postings[tokens[0]] {
+		matched := true
+		for _, token := range tokens[1:] {
+			if _, ok := postings[token][messageId]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			messageIds = append(messageIds, messageId)
+		}
+	}
+
+	return newestFirst(messageIds, limit, index.maxResults), nil
+}
+
+// tokenize lowercases the text and splits it into unique words of letters and digits
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	seen := make(map[string]struct{})
+	var tokens []string
+	for _, field := range fields {
+		if len(field) > maxTokenBytes {
+			continue
+		}
+		if _, ok := seen[field]; ok {
+			continue
+		}
+		seen[field] = struct{}{}
+		tokens = append(tokens, field)
+	}
+
+	return tokens
+}
+
+// newestFirst sorts snowflake ids from newest to oldest and keeps at most limit of them
+func newestFirst(messageIds []uint64, limit int, maxResults int) []uint64 {
+	if limit <= 0 || limit > maxResults {
+		limit = maxResults
+	}
+
+	slices.Sort(messageIds)
+	slices.Reverse(messageIds)
+	if len(messageIds) > limit {
+		messageIds = messageIds[:limit]
+	}
+
+	return messageIds
+}
@@ -0,0 +1,142 @@
+package chat
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/infra/infratest"
+)
+
+const (
+	testMaxResults = 50
+	testIndexTtl   = time.Hour
+)
+
+func newTestRedisMessageIndex(t *testing.T) (*RedisMessageIndex, *miniredis.Miniredis) {
+	t.Helper()
+
+	client, mr := infratest.NewRedisClient(t)
+	return NewRedisMessageIndex(infra.NewRedisCacheImpl(client), testMaxResults, testIndexTtl), mr
+}
+
+func testMessageIndexes(t *testing.T) map[string]MessageIndex {
+	t.Helper()
+
+	redisIndex, _ := newTestRedisMessageIndex(t)
+	return map[string]MessageIndex{
+		MemoryMessageIndexType: NewMemoryMessageIndex(testMaxResults),
+		RedisMessageIndexType:  redisIndex,
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("Hello, hello WORLD! it's 2024")
+	expected := []string{"hello", "world", "it", "s", "2024"}
+	if !slices.Equal(tokens, expected) {
+		t.Fatalf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestMessageIndexSearch(t *testing.T) {
+	for name, index := range testMessageIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			messages := []*Message{
+				{MessageId: 1, ChannelId: 1, Payload: "the quick brown fox"},
+				{MessageId: 2, ChannelId: 1, Payload: "a quick brown dog"},
+				{MessageId: 3, ChannelId: 1, Payload: "lazy dog"},
+				{MessageId: 4, ChannelId: 2, Payload: "quick brown"},
+			}
+			for _, message := range messages {
+				if err := index.IndexMessage(ctx, message); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cases := []struct {
+				query    string
+				limit    int
+				expected []uint64
+			}{
+				{"QUICK", 0, []uint64{2, 1}},
+				{"quick dog", 0, []uint64{2}},
+				{"quick brown", 1, []uint64{2}},
+				{"cat", 0, nil},
+				{"!!", 0, nil},
+			}
+			for _, c := range cases {
+				messageIds, err := index.Search(ctx, 1, c.query, c.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(messageIds, c.expected) {
+					t.Errorf("query %q: expected %v, got %v", c.query, c.expected, messageIds)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageIndexRemove(t *testing.T) {
+	for name, index := range testMessageIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first := &Message{MessageId: 1, ChannelId: 1, Payload: "hello world"}
+			second := &Message{MessageId: 2, ChannelId: 1, Payload: "hello there"}
+			for _, message := range []*Message{first, second} {
+				if err := index.IndexMessage(ctx, message); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := index.RemoveMessage(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			messageIds, err := index.Search(ctx, 1, "hello", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(messageIds, []uint64{2}) {
+				t.Fatalf("expected only the remaining message, got %v", messageIds)
+			}
+
+			if err := index.DeleteChannel(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			messageIds, err = index.Search(ctx, 1, "hello", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messageIds) != 0 {
+				t.Fatalf("expected no message after deleting the channel, got %v", messageIds)
+			}
+		})
+	}
+}
+
+func TestRedisMessageIndexTtl(t *testing.T) {
+	index, mr := newTestRedisMessageIndex(t)
+	ctx := context.Background()
+
+	if err := index.IndexMessage(ctx, &Message{MessageId: 1, ChannelId: 1, Payload: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{constructTokenKey(1, "hello"), constructTokensKey(1)} {
+		if ttl := mr.TTL(key); ttl != testIndexTtl {
+			t.Errorf("expected key %s to expire in %s, got %s", key, testIndexTtl, ttl)
+		}
+	}
+
+	mr.FastForward(testIndexTtl)
+	messageIds, err := index.Search(ctx, 1, "hello", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messageIds) != 0 {
+		t.Fatalf("expected the expired token to be gone, got %v", messageIds)
+	}
+}
@@ -2,40 +2,69 @@ package chat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 )
 
 // ============================
 // Service Interfaces
 // ============================
 type UserService interface {
-	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error
+	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error
+	RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) (int, error)
 	GetUser(ctx context.Context, userId uint64) (*User, error)
 	IsChannelUserExists(ctx context.Context, channelId uint64, userId uint64) (bool, error)
 	GetChannelUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
-	AddOnlineUser(ctx context.Context, channelId uint64, userId uint64) error
+	AddOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error)
+	DeleteOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error)
 	DeleteOnlineUser(ctx context.Context, channelId uint64, userId uint64) error
 	GetOnlineUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
+	GetUserIdBySession(ctx context.Context, session string) (uint64, error)
+	TouchLastSeen(ctx context.Context, userId uint64) error
+	GetChannelLastSeen(ctx context.Context, channelId uint64) ([]*LastSeen, error)
 }
 
 type ChatService interface {
-	BroadcastTextMessage(ctx context.Context, channelId uint64, userId uint64, payload string) error
-	BroadcastConnectMessage(ctx context.Context, channelId uint64, userId uint64) error
+	BroadcastTextMessage(ctx context.Context, channelId uint64, userId uint64, payload string, replyTo uint64, clientMessageId string) (uint64, error)
+	BroadcastConnectMessage(ctx context.Context, channelId uint64, userId uint64, presence *Presence) error
+	BroadcastExpiredUsers(ctx context.Context, channelId uint64, presence *Presence) error
 	BroadcastActionMessage(ctx context.Context, channelId uint64, userId uint64, action Action) error
-	BroadcastFileMessage(ctx context.Context, channelId uint64, userId uint64, payload string) error
-	MarkMessageSeen(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error
+	BroadcastFileMessage(ctx context.Context, channelId uint64, userId uint64, payload string, replyTo uint64, clientMessageId string) (uint64, error)
+	UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error
+	GetReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error)
+	ListUserChannels(ctx context.Context, userId uint64) ([]*UserChannel, error)
+	EditMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64, payload string) error
+	DeleteMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error
 	InsertMessage(ctx context.Context, chatMessage *Message) error
 	PublishMessage(ctx context.Context, chatMessage *Message) error
-	ListMessages(ctx context.Context, channelId uint64, pageState string) ([]*Message, string, error)
+	ReactMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64, emoji string, remove bool) error
+	ListMessages(ctx context.Context, channelId uint64, viewerId uint64, query *MessageQuery) ([]*Message, string, error)
+	ListReplies(ctx context.Context, channelId uint64, viewerId uint64, parentId uint64, pageState string) ([]*Message, string, error)
+	ListMessageChanges(ctx context.Context, channelId uint64, lastMessageId uint64) ([]*Message, error)
+	SearchMessages(ctx context.Context, channelId uint64, query string, limit int) ([]*Message, error)
+	RelayKeyExchange(ctx context.Context, channelId uint64, userId uint64, payload string) error
+	ReportChannel(ctx context.Context, channelId uint64, reporterId uint64, reason string, evidenceNum int) (*Report, error)
+	NotifyInvitees(ctx context.Context, channel *Channel, inviterId uint64, inviteeIds []uint64) error
 }
 
 type ChannelService interface {
-	CreateChannel(ctx context.Context) (*Channel, error)
+	CreateChannel(ctx context.Context, name string, encrypted bool) (*Channel, error)
+	ReopenChannel(ctx context.Context, channelId uint64) (*Channel, error)
+	GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error)
+	AuthorizeModeration(ctx context.Context, channelId uint64, actorId uint64, targetId uint64) error
+	AuthorizeDeletion(ctx context.Context, channelId uint64, actorId uint64) error
+	SetMemberRole(ctx context.Context, channelId uint64, actorId uint64, targetId uint64, role Role) error
+	MuteMember(ctx context.Context, channelId uint64, userId uint64, duration time.Duration) (int64, error)
+	IsMemberMuted(ctx context.Context, channelId uint64, userId uint64) (bool, error)
+	BanUser(ctx context.Context, channelId uint64, userId uint64) error
+	IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error)
 	DeleteChannel(ctx context.Context, channelId uint64) error
+	SendFriendRequest(ctx context.Context, channelId uint64, userId uint64) (uint64, bool, error)
 }
 
 type ForwarderService interface {
@@ -55,23 +84,40 @@ func NewUserServiceImpl(userRepoCache UserRepoCache) *UserServiceImpl {
 }
 
 type ChatServiceImpl struct {
-	chatRepoCache ChatRepoCache
-	userRepoCache UserRepoCache
-	sf            common.IDGenerator
+	chatRepoCache    ChatRepoCache
+	userRepoCache    UserRepoCache
+	channelRepoCache ChannelRepoCache
+	messageIndex     MessageIndex
+	typingTracker    *TypingTracker
+	sf               common.IDGenerator
+	maxPayloadBytes  int
+	replayNum        int
+	logger           common.HttpLog
 }
 
-func NewChatServiceImpl(chatRepoCache ChatRepoCache, userRepoCache UserRepoCache, sf common.IDGenerator) *ChatServiceImpl {
-	return &ChatServiceImpl{chatRepoCache, userRepoCache, sf}
+func NewChatServiceImpl(chatRepoCache ChatRepoCache, userRepoCache UserRepoCache, channelRepoCache ChannelRepoCache, messageIndex MessageIndex, typingTracker *TypingTracker, sf common.IDGenerator, config *config.Config, logger common.HttpLog) *ChatServiceImpl {
+	return &ChatServiceImpl{
+		chatRepoCache:    chatRepoCache,
+		userRepoCache:    userRepoCache,
+		channelRepoCache: channelRepoCache,
+		messageIndex:     messageIndex,
+		typingTracker:    typingTracker,
+		sf:               sf,
+		maxPayloadBytes:  int(config.Chat.Message.MaxSizeByte),
+		replayNum:        config.Chat.Message.ReplayNum,
+		logger:           logger,
+	}
 }
 
 type ChannelServiceImpl struct {
 	channelRepoCache ChannelRepoCache
 	userRepoCache    UserRepoCache
+	messageIndex     MessageIndex
 	sf               common.IDGenerator
 }
 
-func NewChannelServiceImpl(channelRepoCache ChannelRepoCache, userRepoCache UserRepoCache, sf common.IDGenerator) *ChannelServiceImpl {
-	return &ChannelServiceImpl{channelRepoCache, userRepoCache, sf}
+func NewChannelServiceImpl(channelRepoCache ChannelRepoCache, userRepoCache UserRepoCache, messageIndex MessageIndex, sf common.IDGenerator) *ChannelServiceImpl {
+	return &ChannelServiceImpl{channelRepoCache, userRepoCache, messageIndex, sf}
 }
 
 type ForwarderServiceImpl struct {
@@ -85,13 +131,34 @@ func NewForwarderServiceImpl(forwarderRepo ForwarderRepo) *ForwarderServiceImpl
 // ============================
 // Service Functions
 // ============================
-func (s *UserServiceImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error {
-	if err := s.userRepoCache.AddUserToChannel(ctx, channelId, userId); err != nil {
+func (s *UserServiceImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64, role Role) error {
+	if err := s.userRepoCache.AddUserToChannel(ctx, channelId, userId, role); err != nil {
 		return fmt.Errorf("error add user %d to channel %d: %w", userId, channelId, err)
 	}
 	return nil
 }
 
+// RemoveUserFromChannel takes the user out of the channel and returns how many members are left
+func (s *UserServiceImpl) RemoveUserFromChannel(ctx context.Context, channelId uint64, userId uint64) (int, error) {
+	if err := s.userRepoCache.RemoveUserFromChannel(ctx, channelId, userId); err != nil {
+		return 0, fmt.Errorf("error remove user %d from channel %d: %w", userId, channelId, err)
+	}
+
+	userIds, err := s.userRepoCache.GetChannelUserIds(ctx, channelId)
+	if err != nil {
+		return 0, fmt.Errorf("error get user ids of channel %d: %w", channelId, err)
+	}
+
+	remaining := 0
+	for _, channelUserId := range userIds {
+		// user 0 is the placeholder row written when the channel is created
+		if channelUserId != 0 {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
+
 func (s *UserServiceImpl) GetUser(ctx context.Context, userId uint64) (*User, error) {
 	user, err := s.userRepoCache.GetUserById(ctx, userId)
 	if err != nil {
@@ -118,19 +185,28 @@ func (s *UserServiceImpl) GetChannelUserIds(ctx context.Context, channelId uint6
 	return userIds, nil
 }
 
-func (s *UserServiceImpl) AddOnlineUser(ctx context.Context, channelId uint64, userId uint64) error {
-	if err := s.userRepoCache.AddOnlineUser(ctx, channelId, userId); err != nil {
-		return fmt.Errorf("error add online user %d in channel %d: %w", userId, channelId, err)
+func (s *UserServiceImpl) AddOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error) {
+	presence, err := s.userRepoCache.AddOnlineSession(ctx, channelId, userId, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("error add online session of user %d in channel %d: %w", userId, channelId, err)
 	}
 
-	return nil
+	return presence, nil
+}
+
+func (s *UserServiceImpl) DeleteOnlineSession(ctx context.Context, channelId uint64, userId uint64, sessionId string) (*Presence, error) {
+	presence, err := s.userRepoCache.DeleteOnlineSession(ctx, channelId, userId, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("error delete online session of user %d in channel %d: %w", userId, channelId, err)
+	}
+
+	return presence, nil
 }
 
 func (s *UserServiceImpl) DeleteOnlineUser(ctx context.Context, channelId uint64, userId uint64) error {
 	if err := s.userRepoCache.DeleteOnlineUser(ctx, channelId, userId); err != nil {
 		return fmt.Errorf("error delete online user %d in channel %d: %w", userId, channelId, err)
 	}
-
 	return nil
 }
 
@@ -143,10 +219,66 @@ func (s *UserServiceImpl) GetOnlineUserIds(ctx context.Context, channelId uint64
 	return userIds, nil
 }
 
-func (s *ChatServiceImpl) BroadcastTextMessage(ctx context.Context, channelId uint64, userId uint64, payload string) error {
+func (s *UserServiceImpl) GetUserIdBySession(ctx context.Context, session string) (uint64, error) {
+	userId, err := s.userRepoCache.GetUserIdBySession(ctx, session)
+	if err != nil {
+		return 0, fmt.Errorf("error get user id by sid %s: %w", session, err)
+	}
+
+	return userId, nil
+}
+
+// TouchLastSeen records now as the last time the user was active
+func (s *UserServiceImpl) TouchLastSeen(ctx context.Context, userId uint64) error {
+	if err := s.userRepoCache.UpdateLastSeen(ctx, userId, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("error update last seen of user %d: %w", userId, err)
+	}
+
+	return nil
+}
+
+// GetChannelLastSeen returns when each channel member was last active, leaving out members never seen
+func (s *UserServiceImpl) GetChannelLastSeen(ctx context.Context, channelId uint64) ([]*LastSeen, error) {
+	userIds, err := s.userRepoCache.GetChannelUserIds(ctx, channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error get user ids of channel %d: %w", channelId, err)
+	}
+
+	lastSeens, err := s.userRepoCache.GetLastSeen(ctx, userIds)
+	if err != nil {
+		return nil, fmt.Errorf("error get last seen of users in channel %d: %w", channelId, err)
+	}
+
+	var result []*LastSeen
+	for _, userId := range userIds {
+		if lastSeen, ok := lastSeens[userId]; ok {
+			result = append(result, &LastSeen{
+				UserId:   userId,
+				LastSeen: lastSeen,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// BroadcastTextMessage stores, publishes and indexes a text. The text of an encrypted channel is ciphertext,
+// which is not indexed.
+func (s *ChatServiceImpl) BroadcastTextMessage(ctx context.Context, channelId uint64, userId uint64, payload string, replyTo uint64, clientMessageId string) (uint64, error) {
+	if err := s.checkPayloadSize(payload); err != nil {
+		return 0, fmt.Errorf("error broadcast text message: %w", err)
+	}
+	encrypted, err := s.channelRepoCache.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return 0, fmt.Errorf("error get encryption of channel %d: %w", channelId, err)
+	}
+	if err := s.checkReplyParent(ctx, channelId, replyTo); err != nil {
+		return 0, fmt.Errorf("error broadcast text message: %w", err)
+	}
+
 	messageId, err := s.sf.NextID()
 	if err != nil {
-		return fmt.Errorf("error create snowflake ID for text message: %w", err)
+		return 0, fmt.Errorf("error create snowflake ID for text message: %w", err)
 	}
 
 	chatMessage := &Message{
@@ -155,32 +287,74 @@ func (s *ChatServiceImpl) BroadcastTextMessage(ctx context.Context, channelId ui
 		ChannelId: channelId,
 		UserId:    userId,
 		Payload:   payload,
+		ReplyTo:   replyTo,
 		Time:      time.Now().UnixMilli(),
 	}
-	if err := s.chatRepoCache.InsertMessage(ctx, chatMessage); err != nil {
-		return err
+	storedId, err := s.storeMessage(ctx, chatMessage, clientMessageId)
+	if err != nil {
+		return 0, fmt.Errorf("error broadcast text message: %w", err)
 	}
-	if err := s.PublishMessage(ctx, chatMessage); err != nil {
-		return err
+	if storedId != messageId || encrypted {
+		return storedId, nil
+	}
+	if err := s.messageIndex.IndexMessage(ctx, chatMessage); err != nil {
+		s.logIndexError(fmt.Errorf("error index text message %d: %w", messageId, err))
 	}
 
-	return nil
+	return messageId, nil
 }
 
-func (s *ChatServiceImpl) BroadcastConnectMessage(ctx context.Context, channelId uint64, userId uint64) error {
-	onlineUserIds, err := s.userRepoCache.GetOnlineUserIds(context.Background(), channelId)
-	if err != nil {
-		return fmt.Errorf("error get online user ids from channel %d: %w", channelId, err)
+// BroadcastConnectMessage announces a new session. A user who is already online through another session
+// is not announced again.
+func (s *ChatServiceImpl) BroadcastConnectMessage(ctx context.Context, channelId uint64, userId uint64, presence *Presence) error {
+	if err := s.BroadcastExpiredUsers(ctx, channelId, presence); err != nil {
+		return err
 	}
 
-	if len(onlineUserIds) == 1 {
+	if len(presence.UserIds) == 1 {
 		return s.BroadcastActionMessage(ctx, channelId, userId, WaitingMessage)
 	}
+	if presence.SessionCount > 1 {
+		return nil
+	}
 
 	return s.BroadcastActionMessage(ctx, channelId, userId, JoinedMessage)
 }
 
+// BroadcastExpiredUsers announces the users whose sessions stopped sending heartbeats as offline
+func (s *ChatServiceImpl) BroadcastExpiredUsers(ctx context.Context, channelId uint64, presence *Presence) error {
+	for _, userId := range presence.ExpiredUserIds {
+		if err := s.BroadcastActionMessage(ctx, channelId, userId, EndTypingMessage); err != nil {
+			return err
+		}
+		if err := s.BroadcastActionMessage(ctx, channelId, userId, OfflineMessage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BroadcastActionMessage publishes an action to the channel. Typing actions only go out when they change
+// what other members see, or to refresh an indicator once per throttle window.
 func (s *ChatServiceImpl) BroadcastActionMessage(ctx context.Context, channelId uint64, userId uint64, action Action) error {
+	switch action {
+	case IsTypingMessage:
+		if !s.typingTracker.Start(channelId, userId, func() error {
+			return s.publishActionMessage(context.Background(), channelId, userId, EndTypingMessage)
+		}) {
+			return nil
+		}
+	case EndTypingMessage:
+		if !s.typingTracker.Stop(channelId, userId) {
+			return nil
+		}
+	}
+
+	return s.publishActionMessage(ctx, channelId, userId, action)
+}
+
+func (s *ChatServiceImpl) publishActionMessage(ctx context.Context, channelId uint64, userId uint64, action Action) error {
 	eventMessageId, err := s.sf.NextID()
 	if err != nil {
 		return fmt.Errorf("error create snowflake ID for action message: %w", err)
@@ -202,10 +376,14 @@ func (s *ChatServiceImpl) BroadcastActionMessage(ctx context.Context, channelId
 	return nil
 }
 
-func (s *ChatServiceImpl) BroadcastFileMessage(ctx context.Context, channelId uint64, userId uint64, payload string) error {
+func (s *ChatServiceImpl) BroadcastFileMessage(ctx context.Context, channelId uint64, userId uint64, payload string, replyTo uint64, clientMessageId string) (uint64, error) {
+	if err := s.checkReplyParent(ctx, channelId, replyTo); err != nil {
+		return 0, fmt.Errorf("error broadcast file message: %w", err)
+	}
+
 	messageId, err := s.sf.NextID()
 	if err != nil {
-		return fmt.Errorf("error create snowflake ID for file message: %w", err)
+		return 0, fmt.Errorf("error create snowflake ID for file message: %w", err)
 	}
 
 	chatMessage := &Message{
@@ -214,21 +392,119 @@ func (s *ChatServiceImpl) BroadcastFileMessage(ctx context.Context, channelId ui
 		ChannelId: channelId,
 		UserId:    userId,
 		Payload:   payload,
+		ReplyTo:   replyTo,
 		Time:      time.Now().UnixMilli(),
 	}
+	storedId, err := s.storeMessage(ctx, chatMessage, clientMessageId)
+	if err != nil {
+		return 0, fmt.Errorf("error broadcast file message: %w", err)
+	}
+
+	return storedId, nil
+}
+
+// storeMessage inserts and publishes a message once per client message id. A resend within the
+// idempotency window returns the id of the first send without storing the message again, and publishes
+// the stored message when the first send could not.
+func (s *ChatServiceImpl) storeMessage(ctx context.Context, chatMessage *Message, clientMessageId string) (uint64, error) {
+	if clientMessageId != "" {
+		if len(clientMessageId) > maxClientMessageIdBytes {
+			return 0, common.ErrorInvalidClientMessageId
+		}
+
+		claimed, originalId, err := s.chatRepoCache.ClaimClientMessageId(ctx, chatMessage.ChannelId, chatMessage.UserId, clientMessageId, chatMessage.MessageId)
+		if err != nil {
+			return 0, err
+		}
+		if !claimed {
+			if err := s.republishMessage(ctx, chatMessage.ChannelId, chatMessage.UserId, originalId, clientMessageId); err != nil {
+				return 0, err
+			}
+			return originalId, nil
+		}
+	}
+
 	if err := s.chatRepoCache.InsertMessage(ctx, chatMessage); err != nil {
-		return fmt.Errorf("error broadcast file message: %w", err)
+		// nothing was stored, so a retry of the same client message id must be allowed through
+		if clientMessageId != "" {
+			if releaseErr := s.chatRepoCache.ReleaseClientMessageId(ctx, chatMessage.ChannelId, chatMessage.UserId, clientMessageId); releaseErr != nil {
+				return 0, errors.Join(err, releaseErr)
+			}
+		}
+		return 0, err
 	}
-	if err := s.PublishMessage(ctx, chatMessage); err != nil {
-		return fmt.Errorf("error broadcast file message: %w", err)
+	if err := s.publishOnce(ctx, chatMessage, clientMessageId); err != nil {
+		return 0, err
 	}
 
+	return chatMessage.MessageId, nil
+}
+
+// republishMessage publishes the message stored by the first send of a client message id, unless that send
+// published it already. A message the first send is still storing is left for it to publish.
+func (s *ChatServiceImpl) republishMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64, clientMessageId string) error {
+	claimed, err := s.chatRepoCache.ClaimClientMessagePublish(ctx, channelId, userId, clientMessageId)
+	if err != nil || !claimed {
+		return err
+	}
+
+	original, err := s.chatRepoCache.GetMessage(ctx, channelId, messageId)
+	if err != nil {
+		if releaseErr := s.chatRepoCache.ReleaseClientMessagePublish(ctx, channelId, userId, clientMessageId); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+		if errors.Is(err, common.ErrorMessageNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.publishClaimed(ctx, original, clientMessageId)
+}
+
+// publishOnce publishes a stored message, or leaves it to a resend of the same client message id that
+// published it already
+func (s *ChatServiceImpl) publishOnce(ctx context.Context, chatMessage *Message, clientMessageId string) error {
+	if clientMessageId == "" {
+		return s.PublishMessage(ctx, chatMessage)
+	}
+
+	claimed, err := s.chatRepoCache.ClaimClientMessagePublish(ctx, chatMessage.ChannelId, chatMessage.UserId, clientMessageId)
+	if err != nil || !claimed {
+		return err
+	}
+	return s.publishClaimed(ctx, chatMessage, clientMessageId)
+}
+
+// publishClaimed publishes a message whose publish was claimed, releasing the claim when publishing fails so
+// that a resend publishes it instead
+func (s *ChatServiceImpl) publishClaimed(ctx context.Context, chatMessage *Message, clientMessageId string) error {
+	if err := s.PublishMessage(ctx, chatMessage); err != nil {
+		if releaseErr := s.chatRepoCache.ReleaseClientMessagePublish(ctx, chatMessage.ChannelId, chatMessage.UserId, clientMessageId); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+		return err
+	}
 	return nil
 }
 
-func (s *ChatServiceImpl) MarkMessageSeen(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error {
-	if err := s.chatRepoCache.MarkMessageSeen(ctx, channelId, messageId); err != nil {
-		return fmt.Errorf("error mark message %d seen in channel %d: %w", messageId, channelId, err)
+// UpdateReadCursor moves the read cursor of the user forward. The cursor is clamped to the latest message, since
+// a cursor past it would keep every later message from counting as unread.
+func (s *ChatServiceImpl) UpdateReadCursor(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error {
+	lastMessage, err := s.chatRepoCache.GetLastMessage(ctx, channelId)
+	if err != nil {
+		return fmt.Errorf("error get last message of channel %d: %w", channelId, err)
+	}
+	if lastMessage == nil {
+		return nil
+	}
+	messageId = min(messageId, lastMessage.MessageId)
+
+	moved, err := s.chatRepoCache.UpdateReadCursor(ctx, channelId, userId, messageId)
+	if err != nil {
+		return fmt.Errorf("error update read cursor of user %d to message %d in channel %d: %w", userId, messageId, channelId, err)
+	}
+	if !moved {
+		return nil
 	}
 
 	eventMessageId, err := s.sf.NextID()
@@ -243,11 +519,298 @@ func (s *ChatServiceImpl) MarkMessageSeen(ctx context.Context, channelId uint64,
 		UserId:    userId,
 		Payload:   strconv.FormatUint(messageId, 10),
 		Time:      time.Now().UnixMilli(),
-		Seen:      true,
 	}
 
 	if err := s.PublishMessage(ctx, chatMessage); err != nil {
-		return fmt.Errorf("error mark message %d seen in channel %d: %w", messageId, channelId, err)
+		return fmt.Errorf("error update read cursor of user %d to message %d in channel %d: %w", userId, messageId, channelId, err)
+	}
+
+	return nil
+}
+
+// GetReadCursors returns the read cursor of every channel member; members who have not read anything yet get a zero cursor.
+func (s *ChatServiceImpl) GetReadCursors(ctx context.Context, channelId uint64) ([]*ReadCursor, error) {
+	userIds, err := s.userRepoCache.GetChannelUserIds(ctx, channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error get read cursors in channel %d: %w", channelId, err)
+	}
+
+	storedCursors, err := s.chatRepoCache.ListReadCursors(ctx, channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error get read cursors in channel %d: %w", channelId, err)
+	}
+
+	readUpTo := make(map[uint64]uint64)
+	for _, cursor := range storedCursors {
+		readUpTo[cursor.UserId] = cursor.MessageId
+	}
+
+	var cursors []*ReadCursor
+	for _, userId := range userIds {
+		// user 0 is the placeholder row written when the channel is created
+		if userId == 0 {
+			continue
+		}
+		cursors = append(cursors, &ReadCursor{
+			UserId:    userId,
+			MessageId: readUpTo[userId],
+		})
+	}
+
+	return cursors, nil
+}
+
+// ListUserChannels lists the channels of a user with a fresh access token, the number of messages
+// stored after the user's read cursor and the latest message as a preview.
+func (s *ChatServiceImpl) ListUserChannels(ctx context.Context, userId uint64) ([]*UserChannel, error) {
+	channelIds, err := s.userRepoCache.GetUserChannelIds(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error list channels of user %d: %w", userId, err)
+	}
+
+	messageNums, err := s.chatRepoCache.GetMessageNums(ctx, channelIds)
+	if err != nil {
+		return nil, fmt.Errorf("error get message numbers of channels of user %d: %w", userId, err)
+	}
+
+	readNums, err := s.chatRepoCache.GetReadNums(ctx, channelIds, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get read numbers of user %d: %w", userId, err)
+	}
+
+	lastMessages, err := s.chatRepoCache.GetLastMessages(ctx, channelIds)
+	if err != nil {
+		return nil, fmt.Errorf("error get last messages of channels of user %d: %w", userId, err)
+	}
+
+	channelsEncrypted, err := s.channelRepoCache.GetChannelsEncrypted(ctx, channelIds)
+	if err != nil {
+		return nil, fmt.Errorf("error get encryption of channels of user %d: %w", userId, err)
+	}
+
+	var userChannels []*UserChannel
+	for _, channelId := range channelIds {
+		accessToken, err := common.NewJWT(channelId)
+		if err != nil {
+			return nil, fmt.Errorf("error create JWT for channel %d: %w", channelId, err)
+		}
+
+		userChannels = append(userChannels, &UserChannel{
+			Channel: Channel{
+				Id:          channelId,
+				Encrypted:   channelsEncrypted[channelId],
+				AccessToken: accessToken,
+			},
+			UnreadCount: max(messageNums[channelId]-readNums[channelId], 0),
+			LastMessage: lastMessages[channelId],
+		})
+	}
+
+	return userChannels, nil
+}
+
+func (s *ChatServiceImpl) EditMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64, payload string) error {
+	if err := s.checkPayloadSize(payload); err != nil {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, err)
+	}
+	encrypted, err := s.channelRepoCache.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return fmt.Errorf("error get encryption of channel %d: %w", channelId, err)
+	}
+
+	original, err := s.getAuthoredMessage(ctx, channelId, userId, messageId)
+	if err != nil {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, err)
+	}
+	if original.Event != EventText {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, common.ErrorMessageNotEditable)
+	}
+
+	editedAt := time.Now().UnixMilli()
+	if err := s.chatRepoCache.EditMessage(ctx, channelId, messageId, payload, editedAt); err != nil {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, err)
+	}
+	if err := s.chatRepoCache.AddMessageChange(ctx, channelId, messageId, editedAt); err != nil {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	chatMessage := &Message{
+		MessageId: messageId,
+		Event:     EventEdit,
+		ChannelId: channelId,
+		UserId:    userId,
+		Payload:   payload,
+		Edited:    true,
+		EditedAt:  editedAt,
+		Time:      original.Time,
+	}
+
+	if err := s.PublishMessage(ctx, chatMessage); err != nil {
+		return fmt.Errorf("error edit message %d in channel %d: %w", messageId, channelId, err)
+	}
+	if encrypted {
+		return nil
+	}
+
+	if err := s.messageIndex.RemoveMessage(ctx, original); err != nil {
+		s.logIndexError(fmt.Errorf("error reindex message %d in channel %d: %w", messageId, channelId, err))
+	}
+	if err := s.messageIndex.IndexMessage(ctx, chatMessage); err != nil {
+		s.logIndexError(fmt.Errorf("error reindex message %d in channel %d: %w", messageId, channelId, err))
+	}
+
+	return nil
+}
+
+func (s *ChatServiceImpl) DeleteMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64) error {
+	original, err := s.getAuthoredMessage(ctx, channelId, userId, messageId)
+	if err != nil {
+		return fmt.Errorf("error delete message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	encrypted, err := s.channelRepoCache.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return fmt.Errorf("error delete message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	if err := s.chatRepoCache.DeleteMessage(ctx, channelId, messageId); err != nil {
+		return fmt.Errorf("error delete message %d in channel %d: %w", messageId, channelId, err)
+	}
+	if err := s.chatRepoCache.AddMessageChange(ctx, channelId, messageId, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("error delete message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	chatMessage := &Message{
+		MessageId: messageId,
+		Event:     EventDelete,
+		ChannelId: channelId,
+		UserId:    userId,
+		Deleted:   true,
+		Time:      original.Time,
+	}
+
+	if err := s.PublishMessage(ctx, chatMessage); err != nil {
+		return fmt.Errorf("error delete message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	if original.Event == EventText && !encrypted {
+		if err := s.messageIndex.RemoveMessage(ctx, original); err != nil {
+			s.logIndexError(fmt.Errorf("error unindex message %d in channel %d: %w", messageId, channelId, err))
+		}
+	}
+
+	return nil
+}
+
+// ListMessageChanges returns the edit and delete events of the messages up to lastMessageId that changed since it was
+// sent, in the order they changed. Later messages are left out as a replay lists them in their current state.
+// It fails with common.ErrorTooManyMessageChanges when more changes were made than a replay sends.
+func (s *ChatServiceImpl) ListMessageChanges(ctx context.Context, channelId uint64, lastMessageId uint64) ([]*Message, error) {
+	lastMessage, err := s.chatRepoCache.GetMessage(ctx, channelId, lastMessageId)
+	if err != nil {
+		return nil, fmt.Errorf("error list message changes in channel %d: %w", channelId, err)
+	}
+
+	changedIds, err := s.chatRepoCache.ListMessageChanges(ctx, channelId, lastMessage.Time)
+	if err != nil {
+		return nil, fmt.Errorf("error list message changes in channel %d: %w", channelId, err)
+	}
+	// older changes may have been dropped already
+	if len(changedIds) > s.replayNum {
+		return nil, fmt.Errorf("error list message changes in channel %d: %w", channelId, common.ErrorTooManyMessageChanges)
+	}
+
+	var messageIds []uint64
+	for _, messageId := range changedIds {
+		if messageId <= lastMessageId {
+			messageIds = append(messageIds, messageId)
+		}
+	}
+	if len(messageIds) == 0 {
+		return nil, nil
+	}
+
+	messages, err := s.chatRepoCache.GetMessages(ctx, channelId, messageIds)
+	if err != nil {
+		return nil, fmt.Errorf("error list message changes in channel %d: %w", channelId, err)
+	}
+	messagesById := make(map[uint64]*Message, len(messages))
+	for _, message := range messages {
+		messagesById[message.MessageId] = message
+	}
+
+	var changes []*Message
+	for _, messageId := range messageIds {
+		message, ok := messagesById[messageId]
+		if !ok {
+			continue
+		}
+
+		change := &Message{
+			MessageId: messageId,
+			ChannelId: channelId,
+			UserId:    message.UserId,
+			Time:      message.Time,
+		}
+		if message.Deleted {
+			change.Event = EventDelete
+			change.Deleted = true
+		} else {
+			change.Event = EventEdit
+			change.Payload = message.Payload
+			change.Edited = true
+			change.EditedAt = message.EditedAt
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// logIndexError only logs a failed index update. The change has reached the channel by then, so failing the
+// request would only make the client retry it, while the index merely misses the message in search results.
+func (s *ChatServiceImpl) logIndexError(err error) {
+	s.logger.Error(err.Error())
+}
+
+// getAuthoredMessage returns the stored message only if it is still visible and was sent by userId.
+func (s *ChatServiceImpl) getAuthoredMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64) (*Message, error) {
+	message, err := s.chatRepoCache.GetMessage(ctx, channelId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.Deleted {
+		return nil, common.ErrorMessageNotFound
+	}
+	if message.UserId != userId {
+		return nil, common.ErrorNotMessageAuthor
+	}
+
+	return message, nil
+}
+
+// checkPayloadSize bounds a text by the websocket message size whichever way it reaches the service,
+// so an edit cannot store a payload a new message could not carry
+func (s *ChatServiceImpl) checkPayloadSize(payload string) error {
+	if len(payload) > s.maxPayloadBytes {
+		return common.ErrorPayloadTooLong
+	}
+	return nil
+}
+
+// checkReplyParent makes sure a reply points at a visible message of the same channel.
+// Messages are partitioned by channel, so a parent from another channel is simply not found.
+func (s *ChatServiceImpl) checkReplyParent(ctx context.Context, channelId uint64, replyTo uint64) error {
+	if replyTo == 0 {
+		return nil
+	}
+
+	parent, err := s.chatRepoCache.GetMessage(ctx, channelId, replyTo)
+	if err != nil {
+		return fmt.Errorf("error get reply parent %d in channel %d: %w", replyTo, channelId, err)
+	}
+	if parent.Deleted {
+		return fmt.Errorf("error get reply parent %d in channel %d: %w", replyTo, channelId, common.ErrorMessageNotFound)
 	}
 
 	return nil
@@ -267,22 +830,232 @@ func (s *ChatServiceImpl) PublishMessage(ctx context.Context, msg *Message) erro
 	return nil
 }
 
-func (s *ChatServiceImpl) ListMessages(ctx context.Context, channelId uint64, pageState string) ([]*Message, string, error) {
-	messages, nextPageState, err := s.chatRepoCache.ListMessages(ctx, channelId, pageState)
+func (s *ChatServiceImpl) ReactMessage(ctx context.Context, channelId uint64, userId uint64, messageId uint64, emoji string, remove bool) error {
+	if emoji == "" || len(emoji) > maxReactionBytes {
+		return fmt.Errorf("error react to message %d in channel %d: %w", messageId, channelId, common.ErrorInvalidReaction)
+	}
+
+	target, err := s.chatRepoCache.GetMessage(ctx, channelId, messageId)
+	if err != nil {
+		return fmt.Errorf("error react to message %d in channel %d: %w", messageId, channelId, err)
+	}
+	if target.Deleted {
+		return fmt.Errorf("error react to message %d in channel %d: %w", messageId, channelId, common.ErrorMessageNotFound)
+	}
+
+	reaction := &Reaction{
+		MessageId: messageId,
+		UserId:    userId,
+		Emoji:     emoji,
+	}
+	if remove {
+		err = s.chatRepoCache.RemoveReaction(ctx, channelId, reaction)
+	} else {
+		err = s.chatRepoCache.AddReaction(ctx, channelId, reaction)
+	}
+	if err != nil {
+		return fmt.Errorf("error react to message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	reactionDto := &ReactionDto{
+		Emoji:  emoji,
+		Remove: remove,
+	}
+	chatMessage := &Message{
+		MessageId: messageId,
+		Event:     EventReaction,
+		ChannelId: channelId,
+		UserId:    userId,
+		Payload:   string(reactionDto.Encode()),
+		Time:      time.Now().UnixMilli(),
+	}
+
+	if err := s.PublishMessage(ctx, chatMessage); err != nil {
+		return fmt.Errorf("error react to message %d in channel %d: %w", messageId, channelId, err)
+	}
+
+	return nil
+}
+
+func (s *ChatServiceImpl) ListMessages(ctx context.Context, channelId uint64, viewerId uint64, query *MessageQuery) ([]*Message, string, error) {
+	messages, nextPageState, err := s.chatRepoCache.ListMessages(ctx, channelId, query)
 	if err != nil {
-		return nil, "", fmt.Errorf("error list messages in channel %d with page state %s: %w", channelId, pageState, err)
+		return nil, "", fmt.Errorf("error list messages in channel %d with query %+v: %w", channelId, *query, err)
+	}
+
+	if err := s.attachReactions(ctx, channelId, viewerId, messages); err != nil {
+		return nil, "", fmt.Errorf("error list messages in channel %d with query %+v: %w", channelId, *query, err)
 	}
 
 	return messages, nextPageState, nil
 }
 
-func (s *ChannelServiceImpl) CreateChannel(ctx context.Context) (*Channel, error) {
+func (s *ChatServiceImpl) ListReplies(ctx context.Context, channelId uint64, viewerId uint64, parentId uint64, pageState string) ([]*Message, string, error) {
+	messages, nextPageState, err := s.chatRepoCache.ListReplies(ctx, channelId, parentId, pageState)
+	if err != nil {
+		return nil, "", fmt.Errorf("error list replies of message %d in channel %d with page state %s: %w", parentId, channelId, pageState, err)
+	}
+
+	if err := s.attachReactions(ctx, channelId, viewerId, messages); err != nil {
+		return nil, "", fmt.Errorf("error list replies of message %d in channel %d with page state %s: %w", parentId, channelId, pageState, err)
+	}
+
+	return messages, nextPageState, nil
+}
+
+// attachReactions aggregates the stored reactions of each visible message into per-emoji counts,
+// flagging the emojis the viewer reacted with.
+func (s *ChatServiceImpl) attachReactions(ctx context.Context, channelId uint64, viewerId uint64, messages []*Message) error {
+	var messageIds []uint64
+	for _, message := range messages {
+		if !message.Deleted {
+			messageIds = append(messageIds, message.MessageId)
+		}
+	}
+
+	reactions, err := s.chatRepoCache.ListReactions(ctx, channelId, messageIds)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[uint64][]ReactionCount)
+	for _, reaction := range reactions {
+		messageCounts := counts[reaction.MessageId]
+		i := 0
+		for i < len(messageCounts) && messageCounts[i].Emoji != reaction.Emoji {
+			i++
+		}
+		if i == len(messageCounts) {
+			messageCounts = append(messageCounts, ReactionCount{Emoji: reaction.Emoji})
+		}
+		messageCounts[i].Count++
+		if viewerId != 0 && reaction.UserId == viewerId {
+			messageCounts[i].Reacted = true
+		}
+		counts[reaction.MessageId] = messageCounts
+	}
+
+	for _, message := range messages {
+		if !message.Deleted {
+			message.Reactions = counts[message.MessageId]
+		}
+	}
+
+	return nil
+}
+
+// SearchMessages finds texts by their words, which the server cannot read in encrypted channels
+func (s *ChatServiceImpl) SearchMessages(ctx context.Context, channelId uint64, query string, limit int) ([]*Message, error) {
+	encrypted, err := s.channelRepoCache.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error get encryption of channel %d: %w", channelId, err)
+	}
+	if encrypted {
+		return nil, common.ErrorChannelEncrypted
+	}
+
+	messageIds, err := s.messageIndex.Search(ctx, channelId, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error search messages in channel %d: %w", channelId, err)
+	}
+
+	messages, err := s.chatRepoCache.GetMessages(ctx, channelId, messageIds)
+	if err != nil {
+		return nil, fmt.Errorf("error get searched messages in channel %d: %w", channelId, err)
+	}
+
+	// the index is updated after the message table, so skip rows it has not caught up with yet
+	var matches []*Message
+	for _, message := range messages {
+		if message.Event == EventText && !message.Deleted {
+			matches = append(matches, message)
+		}
+	}
+
+	return matches, nil
+}
+
+// RelayKeyExchange publishes key material to the other members of an encrypted channel. The payload is up to the
+// clients, the server neither reads nor stores it.
+func (s *ChatServiceImpl) RelayKeyExchange(ctx context.Context, channelId uint64, userId uint64, payload string) error {
+	encrypted, err := s.channelRepoCache.IsChannelEncrypted(ctx, channelId)
+	if err != nil {
+		return fmt.Errorf("error get encryption of channel %d: %w", channelId, err)
+	}
+	if !encrypted {
+		return common.ErrorChannelNotEncrypted
+	}
+
+	eventMessageId, err := s.sf.NextID()
+	if err != nil {
+		return fmt.Errorf("error create snowflake ID for key exchange message: %w", err)
+	}
+
+	chatMessage := &Message{
+		MessageId: eventMessageId,
+		Event:     EventKeyExchange,
+		ChannelId: channelId,
+		UserId:    userId,
+		Payload:   payload,
+		Time:      time.Now().UnixMilli(),
+	}
+	if err := s.PublishMessage(ctx, chatMessage); err != nil {
+		return fmt.Errorf("error relay key exchange of user %d in channel %d: %w", userId, channelId, err)
+	}
+
+	return nil
+}
+
+// ReportChannel queues a report of the channel for moderation with its latest messages attached as evidence
+func (s *ChatServiceImpl) ReportChannel(ctx context.Context, channelId uint64, reporterId uint64, reason string, evidenceNum int) (*Report, error) {
+	reportId, err := s.sf.NextID()
+	if err != nil {
+		return nil, fmt.Errorf("error create snowflake ID for report: %w", err)
+	}
+
+	messages, _, err := s.chatRepoCache.ListMessages(ctx, channelId, &MessageQuery{Limit: evidenceNum})
+	if err != nil {
+		return nil, fmt.Errorf("error list evidence messages in channel %d: %w", channelId, err)
+	}
+
+	report := &Report{
+		Id:         reportId,
+		ChannelId:  channelId,
+		ReporterId: reporterId,
+		Reason:     reason,
+		Messages:   messages,
+		Time:       time.Now().UnixMilli(),
+	}
+	if err := s.chatRepoCache.PublishReport(ctx, report); err != nil {
+		return nil, fmt.Errorf("error publish report of channel %d: %w", channelId, err)
+	}
+
+	return report, nil
+}
+
+func (s *ChatServiceImpl) NotifyInvitees(ctx context.Context, channel *Channel, inviterId uint64, inviteeIds []uint64) error {
+	for _, inviteeId := range inviteeIds {
+		notification := common.NewNotification(inviteeId, common.InvitedNotification, common.NotificationPayload{
+			ChannelId:   channel.Id,
+			ChannelName: channel.Name,
+			PeerId:      inviterId,
+		})
+		if err := s.chatRepoCache.PublishNotification(ctx, notification); err != nil {
+			return fmt.Errorf("error publish invite notification of channel %d to user %d: %w", channel.Id, inviteeId, err)
+		}
+	}
+	return nil
+}
+
+// CreateChannel creates an empty channel. Matched channels are unnamed, group channels carry a name and may be
+// encrypted.
+func (s *ChannelServiceImpl) CreateChannel(ctx context.Context, name string, encrypted bool) (*Channel, error) {
 	channelId, err := s.sf.NextID()
 	if err != nil {
 		return nil, fmt.Errorf("error create snowflake ID for channel: %w", err)
 	}
 
-	channel, err := s.channelRepoCache.CreateChannel(ctx, channelId)
+	channel, err := s.channelRepoCache.CreateChannel(ctx, channelId, name, encrypted)
 	if err != nil {
 		return nil, fmt.Errorf("error create channel: %w", err)
 	}
@@ -290,10 +1063,178 @@ func (s *ChannelServiceImpl) CreateChannel(ctx context.Context) (*Channel, error
 	return channel, nil
 }
 
+// ReopenChannel hands out a new access token for an existing channel, as long as someone is still in it
+func (s *ChannelServiceImpl) ReopenChannel(ctx context.Context, channelId uint64) (*Channel, error) {
+	channelMembers, err := s.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return nil, err
+	}
+	if len(channelMembers.Members) == 0 {
+		return nil, common.ErrorChannelOrUserNotFound
+	}
+
+	accessToken, err := common.NewJWT(channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error create JWT: %w", err)
+	}
+
+	return &Channel{
+		Id:          channelId,
+		Name:        channelMembers.Name,
+		Encrypted:   channelMembers.Encrypted,
+		AccessToken: accessToken,
+	}, nil
+}
+
+// SendFriendRequest sends a friend request from the user to the peer of a matched channel. It returns the peer and
+// whether the peer had already sent one, which makes the two friends.
+func (s *ChannelServiceImpl) SendFriendRequest(ctx context.Context, channelId uint64, userId uint64) (uint64, bool, error) {
+	channelMembers, err := s.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return 0, false, err
+	}
+	if channelMembers.Name != "" || len(channelMembers.Members) != 2 {
+		return 0, false, common.ErrorNotMatchedChannel
+	}
+	if findMember(channelMembers, userId) == nil {
+		return 0, false, common.ErrorChannelOrUserNotFound
+	}
+
+	peerId := channelMembers.Members[0].UserId
+	if peerId == userId {
+		peerId = channelMembers.Members[1].UserId
+	}
+
+	friends, err := s.userRepoCache.SendFriendRequest(ctx, userId, peerId)
+	if err != nil {
+		return 0, false, fmt.Errorf("error send friend request from user %d to user %d: %w", userId, peerId, err)
+	}
+
+	return peerId, friends, nil
+}
+
+func (s *ChannelServiceImpl) GetChannelMembers(ctx context.Context, channelId uint64) (*ChannelMembers, error) {
+	channelMembers, err := s.channelRepoCache.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error get members of channel %d: %w", channelId, err)
+	}
+
+	return channelMembers, nil
+}
+
+// AuthorizeModeration checks that the actor may kick, mute or ban the target. Only group channels are moderated,
+// owners moderate everyone else and moderators moderate plain members.
+func (s *ChannelServiceImpl) AuthorizeModeration(ctx context.Context, channelId uint64, actorId uint64, targetId uint64) error {
+	channelMembers, err := s.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return err
+	}
+	if channelMembers.Name == "" {
+		return common.ErrorNotGroupChannel
+	}
+
+	actor, target := findMember(channelMembers, actorId), findMember(channelMembers, targetId)
+	if actor == nil || target == nil {
+		return common.ErrorChannelOrUserNotFound
+	}
+	if !actor.Role.Outranks(target.Role) {
+		return common.ErrorPermissionDenied
+	}
+
+	return nil
+}
+
+// AuthorizeDeletion checks that the actor may delete the channel. Group channels can only be deleted by their owner,
+// matched channels have no owner and can be ended by either member.
+func (s *ChannelServiceImpl) AuthorizeDeletion(ctx context.Context, channelId uint64, actorId uint64) error {
+	channelMembers, err := s.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return err
+	}
+
+	actor := findMember(channelMembers, actorId)
+	if actor == nil {
+		return common.ErrorChannelOrUserNotFound
+	}
+	if channelMembers.Name != "" && actor.Role != OwnerRole {
+		return common.ErrorPermissionDenied
+	}
+
+	return nil
+}
+
+// SetMemberRole promotes a member to moderator or demotes a moderator back. Only the owner may change roles,
+// and the owner role itself cannot be given or taken away.
+func (s *ChannelServiceImpl) SetMemberRole(ctx context.Context, channelId uint64, actorId uint64, targetId uint64, role Role) error {
+	if role != ModeratorRole && role != MemberRole {
+		return common.ErrorInvalidParam
+	}
+
+	channelMembers, err := s.GetChannelMembers(ctx, channelId)
+	if err != nil {
+		return err
+	}
+	if channelMembers.Name == "" {
+		return common.ErrorNotGroupChannel
+	}
+
+	actor, target := findMember(channelMembers, actorId), findMember(channelMembers, targetId)
+	if actor == nil || target == nil {
+		return common.ErrorChannelOrUserNotFound
+	}
+	if actor.Role != OwnerRole || target.Role == OwnerRole {
+		return common.ErrorPermissionDenied
+	}
+
+	if err := s.channelRepoCache.SetMemberRole(ctx, channelId, targetId, role); err != nil {
+		return fmt.Errorf("error set role of user %d in channel %d: %w", targetId, channelId, err)
+	}
+
+	return nil
+}
+
+func (s *ChannelServiceImpl) MuteMember(ctx context.Context, channelId uint64, userId uint64, duration time.Duration) (int64, error) {
+	mutedUntil, err := s.channelRepoCache.MuteMember(ctx, channelId, userId, duration)
+	if err != nil {
+		return 0, fmt.Errorf("error mute user %d in channel %d: %w", userId, channelId, err)
+	}
+
+	return mutedUntil, nil
+}
+
+func (s *ChannelServiceImpl) IsMemberMuted(ctx context.Context, channelId uint64, userId uint64) (bool, error) {
+	mutedUntil, err := s.channelRepoCache.GetMutedUntil(ctx, channelId, userId)
+	if err != nil {
+		return false, fmt.Errorf("error get mute of user %d in channel %d: %w", userId, channelId, err)
+	}
+
+	return mutedUntil > time.Now().UnixMilli(), nil
+}
+
+func (s *ChannelServiceImpl) BanUser(ctx context.Context, channelId uint64, userId uint64) error {
+	if err := s.channelRepoCache.BanUser(ctx, channelId, userId); err != nil {
+		return fmt.Errorf("error ban user %d from channel %d: %w", userId, channelId, err)
+	}
+
+	return nil
+}
+
+func (s *ChannelServiceImpl) IsUserBanned(ctx context.Context, channelId uint64, userId uint64) (bool, error) {
+	banned, err := s.channelRepoCache.IsUserBanned(ctx, channelId, userId)
+	if err != nil {
+		return false, fmt.Errorf("error check ban of user %d in channel %d: %w", userId, channelId, err)
+	}
+
+	return banned, nil
+}
+
 func (s *ChannelServiceImpl) DeleteChannel(ctx context.Context, channelId uint64) error {
 	if err := s.channelRepoCache.DeleteChannel(ctx, channelId); err != nil {
 		return fmt.Errorf("error delete channel %d: %w", channelId, err)
 	}
+	if err := s.messageIndex.DeleteChannel(ctx, channelId); err != nil {
+		return fmt.Errorf("error delete message index of channel %d: %w", channelId, err)
+	}
 
 	return nil
 }
@@ -0,0 +1,360 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+)
+
+// fakeChatRepoCache keeps the messages of a channel in memory. Methods the tests do not need panic through
+// the nil embedded interface.
+type fakeChatRepoCache struct {
+	ChatRepoCache
+	messages  map[uint64]*Message
+	changes   []messageChange
+	published []*Message
+	// claimed binds client message ids to message ids, publishClaimed holds the ids whose publish was claimed
+	claimed        map[string]uint64
+	publishClaimed map[string]bool
+	insertErr      error
+	publishErr     error
+}
+
+type messageChange struct {
+	messageId uint64
+	changedAt int64
+}
+
+func newFakeChatRepoCache() *fakeChatRepoCache {
+	return &fakeChatRepoCache{
+		messages:       make(map[uint64]*Message),
+		claimed:        make(map[string]uint64),
+		publishClaimed: make(map[string]bool),
+	}
+}
+
+func (cache *fakeChatRepoCache) InsertMessage(ctx context.Context, chatMessage *Message) error {
+	if cache.insertErr != nil {
+		return cache.insertErr
+	}
+	stored := *chatMessage
+	cache.messages[chatMessage.MessageId] = &stored
+	return nil
+}
+
+func (cache *fakeChatRepoCache) AddMessageChange(ctx context.Context, channelId uint64, messageId uint64, changedAt int64) error {
+	cache.changes = slices.DeleteFunc(cache.changes, func(change messageChange) bool { return change.messageId == messageId })
+	cache.changes = append(cache.changes, messageChange{messageId, changedAt})
+	return nil
+}
+
+func (cache *fakeChatRepoCache) ListMessageChanges(ctx context.Context, channelId uint64, since int64) ([]uint64, error) {
+	var messageIds []uint64
+	for _, change := range cache.changes {
+		if change.changedAt >= since {
+			messageIds = append(messageIds, change.messageId)
+		}
+	}
+	return messageIds, nil
+}
+
+func (cache *fakeChatRepoCache) ClaimClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string, messageId uint64) (bool, uint64, error) {
+	if originalId, ok := cache.claimed[clientMessageId]; ok {
+		return false, originalId, nil
+	}
+	cache.claimed[clientMessageId] = messageId
+	return true, messageId, nil
+}
+
+func (cache *fakeChatRepoCache) ReleaseClientMessageId(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error {
+	delete(cache.claimed, clientMessageId)
+	return nil
+}
+
+func (cache *fakeChatRepoCache) ClaimClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) (bool, error) {
+	if cache.publishClaimed[clientMessageId] {
+		return false, nil
+	}
+	cache.publishClaimed[clientMessageId] = true
+	return true, nil
+}
+
+func (cache *fakeChatRepoCache) ReleaseClientMessagePublish(ctx context.Context, channelId uint64, userId uint64, clientMessageId string) error {
+	delete(cache.publishClaimed, clientMessageId)
+	return nil
+}
+
+func (cache *fakeChatRepoCache) PublishMessage(ctx context.Context, chatMessage *Message) error {
+	if cache.publishErr != nil {
+		return cache.publishErr
+	}
+	cache.published = append(cache.published, chatMessage)
+	return nil
+}
+
+func (cache *fakeChatRepoCache) GetMessage(ctx context.Context, channelId uint64, messageId uint64) (*Message, error) {
+	message, ok := cache.messages[messageId]
+	if !ok || message.ChannelId != channelId {
+		return nil, common.ErrorMessageNotFound
+	}
+	stored := *message
+	return &stored, nil
+}
+
+func (cache *fakeChatRepoCache) GetMessages(ctx context.Context, channelId uint64, messageIds []uint64) ([]*Message, error) {
+	var messages []*Message
+	for _, messageId := range messageIds {
+		if message, err := cache.GetMessage(ctx, channelId, messageId); err == nil {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (cache *fakeChatRepoCache) EditMessage(ctx context.Context, channelId uint64, messageId uint64, payload string, editedAt int64) error {
+	message := cache.messages[messageId]
+	message.Payload, message.Edited, message.EditedAt = payload, true, editedAt
+	return nil
+}
+
+func (cache *fakeChatRepoCache) DeleteMessage(ctx context.Context, channelId uint64, messageId uint64) error {
+	message := cache.messages[messageId]
+	message.Payload, message.Deleted = "", true
+	return nil
+}
+
+type fakeChannelRepoCache struct {
+	ChannelRepoCache
+	encrypted map[uint64]bool
+}
+
+func (cache *fakeChannelRepoCache) IsChannelEncrypted(ctx context.Context, channelId uint64) (bool, error) {
+	return cache.encrypted[channelId], nil
+}
+
+// failingMessageIndex fails every update, as an unreachable index would
+type failingMessageIndex struct {
+	MessageIndex
+}
+
+func (failingMessageIndex) IndexMessage(ctx context.Context, message *Message) error {
+	return errors.New("index unavailable")
+}
+
+func (failingMessageIndex) RemoveMessage(ctx context.Context, message *Message) error {
+	return errors.New("index unavailable")
+}
+
+type sequenceIDGenerator struct {
+	id uint64
+}
+
+func (sf *sequenceIDGenerator) NextID() (uint64, error) {
+	sf.id++
+	return sf.id, nil
+}
+
+const (
+	testChannelId          uint64 = 1
+	testEncryptedChannelId uint64 = 2
+	testAuthorId           uint64 = 10
+)
+
+func newTestChatService(t *testing.T, messageIndex MessageIndex) (*ChatServiceImpl, *fakeChatRepoCache) {
+	t.Helper()
+
+	config, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatRepoCache := newFakeChatRepoCache()
+	channelRepoCache := &fakeChannelRepoCache{encrypted: map[uint64]bool{testEncryptedChannelId: true}}
+	logger := common.HttpLog{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	return NewChatServiceImpl(chatRepoCache, nil, channelRepoCache, messageIndex, nil, &sequenceIDGenerator{}, config, logger), chatRepoCache
+}
+
+func searchMessageIds(t *testing.T, chatService *ChatServiceImpl, channelId uint64, query string) []uint64 {
+	t.Helper()
+
+	messages, err := chatService.SearchMessages(context.Background(), channelId, query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messageIds []uint64
+	for _, message := range messages {
+		messageIds = append(messageIds, message.MessageId)
+	}
+	return messageIds
+}
+
+func TestSearchFollowsEditsAndDeletes(t *testing.T) {
+	chatService, _ := newTestChatService(t, NewMemoryMessageIndex(testMaxResults))
+	ctx := context.Background()
+
+	first, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "see you at noon", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "lunch at noon?", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageIds := searchMessageIds(t, chatService, testChannelId, "noon"); !slices.Equal(messageIds, []uint64{second, first}) {
+		t.Fatalf("expected both messages newest first, got %v", messageIds)
+	}
+
+	if err := chatService.EditMessage(ctx, testChannelId, testAuthorId, first, "see you at dawn"); err != nil {
+		t.Fatal(err)
+	}
+	if messageIds := searchMessageIds(t, chatService, testChannelId, "noon"); !slices.Equal(messageIds, []uint64{second}) {
+		t.Fatalf("expected the edited message to lose its old words, got %v", messageIds)
+	}
+	if messageIds := searchMessageIds(t, chatService, testChannelId, "dawn"); !slices.Equal(messageIds, []uint64{first}) {
+		t.Fatalf("expected the edited message to be found by its new words, got %v", messageIds)
+	}
+
+	if err := chatService.DeleteMessage(ctx, testChannelId, testAuthorId, second); err != nil {
+		t.Fatal(err)
+	}
+	if messageIds := searchMessageIds(t, chatService, testChannelId, "noon"); len(messageIds) != 0 {
+		t.Fatalf("expected the deleted message to be gone, got %v", messageIds)
+	}
+}
+
+func TestSearchSkipsEncryptedChannels(t *testing.T) {
+	index := NewMemoryMessageIndex(testMaxResults)
+	chatService, _ := newTestChatService(t, index)
+	ctx := context.Background()
+
+	if _, err := chatService.BroadcastTextMessage(ctx, testEncryptedChannelId, testAuthorId, "ciphertext", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if messageIds, _ := index.Search(ctx, testEncryptedChannelId, "ciphertext", 0); len(messageIds) != 0 {
+		t.Fatalf("expected an encrypted message to stay out of the index, got %v", messageIds)
+	}
+	if _, err := chatService.SearchMessages(ctx, testEncryptedChannelId, "ciphertext", 0); !errors.Is(err, common.ErrorChannelEncrypted) {
+		t.Fatalf("expected %v, got %v", common.ErrorChannelEncrypted, err)
+	}
+}
+
+func TestIndexFailureDoesNotFailDeliveredMessages(t *testing.T) {
+	chatService, chatRepoCache := newTestChatService(t, failingMessageIndex{})
+	ctx := context.Background()
+
+	messageId, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "hello", 0, "")
+	if err != nil {
+		t.Fatalf("expected the message to be sent despite the index, got %v", err)
+	}
+	if err := chatService.EditMessage(ctx, testChannelId, testAuthorId, messageId, "hello again"); err != nil {
+		t.Fatalf("expected the edit to be sent despite the index, got %v", err)
+	}
+	if err := chatService.DeleteMessage(ctx, testChannelId, testAuthorId, messageId); err != nil {
+		t.Fatalf("expected the deletion to be sent despite the index, got %v", err)
+	}
+	if len(chatRepoCache.published) != 3 {
+		t.Fatalf("expected 3 published events, got %d", len(chatRepoCache.published))
+	}
+}
+
+func TestListMessageChanges(t *testing.T) {
+	chatService, chatRepoCache := newTestChatService(t, NewMemoryMessageIndex(testMaxResults))
+	ctx := context.Background()
+
+	var messageIds []uint64
+	for _, payload := range []string{"one", "two", "three"} {
+		messageId, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, payload, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		messageIds = append(messageIds, messageId)
+	}
+	last := messageIds[1]
+
+	if err := chatService.DeleteMessage(ctx, testChannelId, testAuthorId, messageIds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := chatService.EditMessage(ctx, testChannelId, testAuthorId, messageIds[1], "two!"); err != nil {
+		t.Fatal(err)
+	}
+	// the client has not seen this message yet, the replay sends it as it is now
+	if err := chatService.EditMessage(ctx, testChannelId, testAuthorId, messageIds[2], "three!"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := chatService.ListMessageChanges(ctx, testChannelId, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(changes))
+	}
+	if changes[0].MessageId != messageIds[0] || changes[0].Event != EventDelete || !changes[0].Deleted {
+		t.Errorf("expected the deletion of message %d first, got %+v", messageIds[0], changes[0])
+	}
+	if changes[1].MessageId != messageIds[1] || changes[1].Event != EventEdit || changes[1].Payload != "two!" {
+		t.Errorf("expected the edit of message %d second, got %+v", messageIds[1], changes[1])
+	}
+
+	for i := 0; i < chatService.replayNum; i++ {
+		chatRepoCache.changes = append(chatRepoCache.changes, messageChange{messageIds[1], chatRepoCache.messages[last].Time})
+	}
+	if _, err := chatService.ListMessageChanges(ctx, testChannelId, last); !errors.Is(err, common.ErrorTooManyMessageChanges) {
+		t.Fatalf("expected %v, got %v", common.ErrorTooManyMessageChanges, err)
+	}
+}
+
+func TestResendPublishesMessageTheFirstSendCouldNot(t *testing.T) {
+	chatService, chatRepoCache := newTestChatService(t, NewMemoryMessageIndex(testMaxResults))
+	ctx := context.Background()
+
+	chatRepoCache.publishErr = errors.New("broker unavailable")
+	if _, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "hello", 0, "c1"); err == nil {
+		t.Fatal("expected the failed publish to fail the send")
+	}
+	if len(chatRepoCache.messages) != 1 || len(chatRepoCache.published) != 0 {
+		t.Fatalf("expected a stored but unpublished message, got %d stored and %d published", len(chatRepoCache.messages), len(chatRepoCache.published))
+	}
+
+	chatRepoCache.publishErr = nil
+	for range 2 {
+		messageId, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "hello", 0, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageId != 1 {
+			t.Fatalf("expected the id of the first send, got %d", messageId)
+		}
+	}
+	if len(chatRepoCache.messages) != 1 || len(chatRepoCache.published) != 1 || chatRepoCache.published[0].MessageId != 1 {
+		t.Fatalf("expected the stored message to be published once, got %d stored and %+v published", len(chatRepoCache.messages), chatRepoCache.published)
+	}
+}
+
+func TestInsertFailureReleasesClientMessageId(t *testing.T) {
+	chatService, chatRepoCache := newTestChatService(t, NewMemoryMessageIndex(testMaxResults))
+	ctx := context.Background()
+
+	chatRepoCache.insertErr = errors.New("cassandra unavailable")
+	if _, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "hello", 0, "c1"); err == nil {
+		t.Fatal("expected the failed insert to fail the send")
+	}
+
+	chatRepoCache.insertErr = nil
+	messageId, err := chatService.BroadcastTextMessage(ctx, testChannelId, testAuthorId, "hello", 0, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := chatRepoCache.messages[messageId]; !ok || messageId == 1 {
+		t.Fatalf("expected the resend to be stored as a new message, got %d", messageId)
+	}
+	if len(chatRepoCache.published) != 1 || chatRepoCache.published[0].MessageId != messageId {
+		t.Fatalf("expected the resend to be published, got %+v", chatRepoCache.published)
+	}
+}
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+)
+
+type typingKey struct {
+	channelId uint64
+	userId    uint64
+}
+
+type typingState struct {
+	deadline      time.Time
+	lastBroadcast time.Time
+	timer         *time.Timer
+}
+
+// TypingTracker keeps the typing state of the users connected to this server. Repeated istyping actions
+// within the throttle window are merged, and a state that is not refreshed before its TTL ends on its own.
+type TypingTracker struct {
+	logger   common.HttpLog
+	mu       sync.Mutex
+	states   map[typingKey]*typingState
+	ttl      time.Duration
+	throttle time.Duration
+}
+
+func NewTypingTracker(config *config.Config, logger common.HttpLog) *TypingTracker {
+	return &TypingTracker{
+		logger:   logger,
+		states:   make(map[typingKey]*typingState),
+		ttl:      time.Duration(config.Chat.Typing.TtlMilliSecond) * time.Millisecond,
+		throttle: time.Duration(config.Chat.Typing.ThrottleMilliSecond) * time.Millisecond,
+	}
+}
+
+// Start records that the user is typing and reports whether the indicator should be broadcast.
+// onExpire is called once the state expires without being refreshed or stopped.
+func (t *TypingTracker) Start(channelId uint64, userId uint64, onExpire func() error) bool {
+	key := typingKey{channelId, userId}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.states[key]; ok {
+		state.deadline = now.Add(t.ttl)
+		if now.Sub(state.lastBroadcast) < t.throttle {
+			return false
+		}
+		state.lastBroadcast = now
+		return true
+	}
+
+	state := &typingState{
+		deadline:      now.Add(t.ttl),
+		lastBroadcast: now,
+	}
+	state.timer = time.AfterFunc(t.ttl, func() {
+		t.expire(key, state, onExpire)
+	})
+	t.states[key] = state
+	return true
+}
+
+// Stop clears the typing state and reports whether the user was typing
+func (t *TypingTracker) Stop(channelId uint64, userId uint64) bool {
+	key := typingKey{channelId, userId}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[key]
+	if !ok {
+		return false
+	}
+	state.timer.Stop()
+	delete(t.states, key)
+	return true
+}
+
+// expire ends a typing state whose deadline has passed. A state refreshed since the timer was armed is
+// rescheduled to its new deadline instead.
+func (t *TypingTracker) expire(key typingKey, state *typingState, onExpire func() error) {
+	t.mu.Lock()
+	if t.states[key] != state {
+		t.mu.Unlock()
+		return
+	}
+	if remaining := time.Until(state.deadline); remaining > 0 {
+		state.timer.Reset(remaining)
+		t.mu.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mu.Unlock()
+
+	if err := onExpire(); err != nil {
+		t.logger.Error(err.Error())
+	}
+}
@@ -2,6 +2,10 @@ package chat
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/thyyl/chatr/pkg/common"
 )
 
 func DecodeToMessageDto(data []byte) (*MessageDto, error) {
@@ -19,3 +23,85 @@ func DecodeToMessage(data []byte) (*Message, error) {
 	}
 	return &msg, nil
 }
+
+func DecodeToReactionDto(data []byte) (*ReactionDto, error) {
+	var reaction ReactionDto
+	if err := json.Unmarshal(data, &reaction); err != nil {
+		return nil, err
+	}
+	return &reaction, nil
+}
+
+// parseUserIds parses user ids sent as strings, dropping duplicates
+func parseUserIds(uids []string) ([]uint64, error) {
+	seen := make(map[uint64]struct{})
+	var userIds []uint64
+	for _, uid := range uids {
+		userId, err := strconv.ParseUint(uid, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[userId]; ok {
+			continue
+		}
+		seen[userId] = struct{}{}
+		userIds = append(userIds, userId)
+	}
+	return userIds, nil
+}
+
+// parseLastMessageId reads the optional id of the last message a reconnecting client received
+func parseLastMessageId(last string) (uint64, error) {
+	if last == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(last, 10, 64)
+}
+
+func findMember(channelMembers *ChannelMembers, userId uint64) *Member {
+	for _, member := range channelMembers.Members {
+		if member.UserId == userId {
+			return member
+		}
+	}
+	return nil
+}
+
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{common.ErrorInvalidToken, ErrorCodeUnauthorized},
+	{common.ErrorUnauthorized, ErrorCodeUnauthorized},
+	{common.ErrorTokenExpired, ErrorCodeTokenExpired},
+	{common.ErrorUnknownEvent, ErrorCodeUnknownEvent},
+	{common.ErrorExceedMessageNumLimits, ErrorCodeMessageLimit},
+	{common.ErrorMessageNotFound, ErrorCodeMessageNotFound},
+	{common.ErrorMessageNotEditable, ErrorCodeMessageNotEditable},
+	{common.ErrorNotMessageAuthor, ErrorCodeNotMessageAuthor},
+	{common.ErrorInvalidReaction, ErrorCodeInvalidReaction},
+	{common.ErrorInvalidClientMessageId, ErrorCodeInvalidMessage},
+	{common.ErrorChannelOrUserNotFound, ErrorCodeNotChannelMember},
+	{common.ErrorMemberMuted, ErrorCodeMuted},
+	{common.ErrorPermissionDenied, ErrorCodePermissionDenied},
+	{common.ErrorPayloadTooLong, ErrorCodePayloadTooLong},
+	{common.ErrorChannelNotEncrypted, ErrorCodeNotEncrypted},
+}
+
+// classifyError maps a websocket message failure to its error code and the reason safe to show the client
+func classifyError(err error) (ErrorCode, error) {
+	for _, errorCode := range errorCodes {
+		if errors.Is(err, errorCode.err) {
+			return errorCode.code, errorCode.err
+		}
+	}
+
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var numError *strconv.NumError
+	if errors.As(err, &syntaxError) || errors.As(err, &typeError) || errors.As(err, &numError) {
+		return ErrorCodeInvalidMessage, common.ErrorInvalidParam
+	}
+
+	return ErrorCodeServer, common.ErrorServer
+}
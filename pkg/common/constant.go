@@ -8,14 +8,18 @@ const (
 const OAuthGoogleUrlAPI = "https://www.googleapis.com/oauth2/v3/userinfo?access_token="
 
 const (
-	JWTAuthHeader                  = "Authorization"
-	JaegerHeader                   = "Uber-Trace-Id"
-	ChannelIdHeader                = "X-Channel-Id"
-	ChannelKey      HTTPContextKey = "channel_key"
-	UserKey         HTTPContextKey = "user_key"
-	ServiceIdHeader string         = "Service-Id"
-	SessionUidKey                  = "SessionUid"
-	SessionCidKey                  = "sesscid"
+	JWTAuthHeader                     = "Authorization"
+	JaegerHeader                      = "Uber-Trace-Id"
+	ChannelIdHeader                   = "X-Channel-Id"
+	ChannelKey         HTTPContextKey = "channel_key"
+	UserKey            HTTPContextKey = "user_key"
+	MatchPreferenceKey HTTPContextKey = "match_preference_key"
+	ServiceIdHeader    string         = "Service-Id"
+	SessionUidKey                     = "SessionUid"
+	SessionCidKey                     = "sesscid"
+	SessionReplayKey                  = "sessreplay"
+	SessionPresenceKey                = "sesspresence"
+	SessionWaiterKey                  = "sesswaiter"
 )
 
 const (
@@ -23,12 +27,36 @@ const (
 	SessionRcKey          = "rc:session"
 	MatchPubSubTopicRcKey = "rc.match"
 	UserWaitListRcKey     = "rc:userwait"
+	ProposalRcKey         = "rc:proposal"
 	ForwardRcKey          = "rc:forward"
 	ChannelUsersRcKey     = "rc:chanusers"
-	OnlineUsersRcKey      = "rc:onlineusers"
+	OnlineUsersRcKey      = "rc:onlineusers" // hash of online users written before presence was tracked per session
+	OnlineSessionsRcKey   = "rc:onlinesessions"
 	RateLimitRcKey        = "rc:ratelimit"
+	SearchIndexRcKey      = "rc:search"
+	ClientMessageRcKey    = "rc:clientmsg"
+	LastSeenRcKey         = "rc:lastseen"
+	MuteRcKey             = "rc:mute"
+	BlocksRcKey           = "rc:blocks"
+	BlockedByRcKey        = "rc:blockedby"
+	FriendsRcKey          = "rc:friends"
+	FriendRequestsRcKey   = "rc:friendreqs"
+	InboxRcKey            = "rc:inbox"
+	InboxReadRcKey        = "rc:inboxread"
+	DeliveryRcKey         = "rc:delivery"
+	DeliveryScheduleRcKey = "rc:deliverysched"
+	EncryptedRcKey        = "rc:encrypted"
+	PublicKeyRcKey        = "rc:publickey"
+	MessageChangesRcKey   = "rc:msgchanges"
 )
 
 const (
 	MessagePubTopic = "rc.msg.pub"
+	ReportPubTopic  = "rc.report.pub"
+	// NotificationPubTopic carries notifications addressed to single users, which any service may publish
+	NotificationPubTopic = "rc.notification.pub"
+	// DeliveryPubTopic queues messages to deliver to channel members through external sinks. Failed and rate
+	// limited deliveries wait in DeliveryScheduleRcKey and are queued again on DeliveryRetryPubTopic once due.
+	DeliveryPubTopic      = "rc.delivery.pub"
+	DeliveryRetryPubTopic = "rc.delivery.retry.pub"
 )
@@ -11,4 +11,25 @@ var (
 	ErrorTooManyUploads         = errors.New("too many uploads")
 	ErrorChannelOrUserNotFound  = errors.New("error channel or user not found")
 	ErrorExceedMessageNumLimits = errors.New("error exceed max number of messages")
+	ErrorMessageNotFound        = errors.New("error message not found")
+	ErrorMessageNotEditable     = errors.New("error message not editable")
+	ErrorNotMessageAuthor       = errors.New("error user is not the author of the message")
+	ErrorInvalidReaction        = errors.New("error invalid reaction")
+	ErrorUnknownEvent           = errors.New("error unknown event type")
+	ErrorInvalidClientMessageId = errors.New("error invalid client message id")
+	ErrorNotGroupChannel        = errors.New("error channel is not a group channel")
+	ErrorExceedChannelMembers   = errors.New("error exceed max number of channel members")
+	ErrorPermissionDenied       = errors.New("error permission denied")
+	ErrorMemberMuted            = errors.New("error member is muted")
+	ErrorUserBanned             = errors.New("error user is banned from the channel")
+	ErrorNotMatchedChannel      = errors.New("error channel is not a matched channel")
+	ErrorNotFriend              = errors.New("error users are not friends")
+	ErrorFriendRequestNotFound  = errors.New("error friend request not found")
+	ErrorDeliveryFailed         = errors.New("error delivery failed")
+	ErrorInvalidSignature       = errors.New("error invalid signature")
+	ErrorPayloadTooLong         = errors.New("error payload too long")
+	ErrorChannelEncrypted       = errors.New("error channel is encrypted")
+	ErrorChannelNotEncrypted    = errors.New("error channel is not encrypted")
+	ErrorInvalidPublicKey       = errors.New("error invalid public key")
+	ErrorTooManyMessageChanges  = errors.New("error too many message changes to replay")
 )
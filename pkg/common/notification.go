@@ -0,0 +1,59 @@
+package common
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type NotificationKind string
+
+const (
+	MatchedNotification       NotificationKind = "matched"
+	FriendRequestNotification NotificationKind = "friendrequest"
+	BefriendedNotification    NotificationKind = "befriended"
+	InvitedNotification       NotificationKind = "invited"
+	MessageNotification       NotificationKind = "message"
+)
+
+// Notification is addressed to a single user. Services publish notifications to the notification topic, the user
+// service keeps them in the user's inbox and pushes them to the user's open notification streams.
+type Notification struct {
+	Id      string              `json:"id"`
+	UserId  uint64              `json:"userId"`
+	Kind    NotificationKind    `json:"kind"`
+	Payload NotificationPayload `json:"payload"`
+	Time    int64               `json:"time"`
+}
+
+// NotificationPayload holds what a notification is about, each kind filling in the fields it needs
+type NotificationPayload struct {
+	ChannelId   uint64 `json:"channelId,omitempty"`
+	ChannelName string `json:"channelName,omitempty"`
+	PeerId      uint64 `json:"peerId,omitempty"`
+	MessageId   uint64 `json:"messageId,omitempty"`
+}
+
+func NewNotification(userId uint64, kind NotificationKind, payload NotificationPayload) *Notification {
+	return &Notification{
+		Id:      uuid.New().String(),
+		UserId:  userId,
+		Kind:    kind,
+		Payload: payload,
+		Time:    time.Now().UnixMilli(),
+	}
+}
+
+func (n *Notification) Encode() []byte {
+	result, _ := json.Marshal(n)
+	return result
+}
+
+func DecodeToNotification(data []byte) (*Notification, error) {
+	var notification Notification
+	if err := json.Unmarshal(data, &notification); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
@@ -31,14 +31,36 @@ type ChatConfig struct {
 		Id string
 	}
 	Message struct {
-		MaxNum        int64
-		PaginationNum int
-		MaxSizeByte   int64
+		MaxNum                  int64
+		PaginationNum           int
+		QueryNum                int
+		ReplayNum               int
+		IdempotencyWindowSecond int64
+		MaxSizeByte             int64
+	}
+	Channel struct {
+		MaxMembers int
+	}
+	Report struct {
+		EvidenceNum int
+	}
+	Presence struct {
+		HeartbeatSecond int64
+		TtlSecond       int64
+	}
+	Typing struct {
+		TtlMilliSecond      int64
+		ThrottleMilliSecond int64
 	}
 	JWT struct {
 		Secret           string
 		ExpirationSecond int64
 	}
+	Search struct {
+		Index      string
+		MaxResults int
+		TtlHour    int64
+	}
 }
 
 func SetDefaultChatConfig() {
@@ -51,7 +73,19 @@ func SetDefaultChatConfig() {
 	viper.SetDefault("chat.subscriber.id", "rc.msg."+os.Getenv("HOSTNAME"))
 	viper.SetDefault("chat.message.maxNum", 5000)
 	viper.SetDefault("chat.message.paginationNum", 5000)
+	viper.SetDefault("chat.message.queryNum", 50)
+	viper.SetDefault("chat.message.replayNum", 500)
+	viper.SetDefault("chat.message.idempotencyWindowSecond", 600)
 	viper.SetDefault("chat.message.maxSizeByte", 4096)
+	viper.SetDefault("chat.channel.maxMembers", 100)
+	viper.SetDefault("chat.report.evidenceNum", 50)
+	viper.SetDefault("chat.presence.heartbeatSecond", 30)
+	viper.SetDefault("chat.presence.ttlSecond", 90)
+	viper.SetDefault("chat.typing.ttlMilliSecond", 6000)
+	viper.SetDefault("chat.typing.throttleMilliSecond", 3000)
 	viper.SetDefault("chat.jwt.secret", "mysecret")
 	viper.SetDefault("chat.jwt.expirationSecond", 86400)
+	viper.SetDefault("chat.search.index", "redis")
+	viper.SetDefault("chat.search.maxResults", 50)
+	viper.SetDefault("chat.search.ttlHour", 720)
 }
@@ -26,6 +26,12 @@ func NewConfig() (*Config, error) {
 		log.Fatalf("failed to unmarshal configuration: %v", err)
 		return nil, err
 	}
+	if err := config.Forwarder.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Match.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/spf13/viper"
 )
 
@@ -9,9 +11,47 @@ type ForwarderConfig struct {
 		Server struct {
 			Port string
 		}
+		Client struct {
+			Chat struct {
+				Endpoint string
+			}
+		}
+	}
+	Delivery struct {
+		MaxAttempts             int
+		RetryBackoffMilliSecond int
+		// RedeliverIntervalMilliSecond is how often deliveries due for another attempt are queued again
+		RedeliverIntervalMilliSecond int
+		RateLimit                    RateLimitConfig
+		Webhook                      struct {
+			Url           string
+			Secret        string
+			TimeoutSecond int
+		}
 	}
 }
 
 func SetDefaultForwarderConfig() {
 	viper.SetDefault("forwarder.grpc.server.port", "4000")
+	viper.SetDefault("forwarder.grpc.client.chat.endpoint", "reverse-proxy:80")
+	viper.SetDefault("forwarder.delivery.maxAttempts", 5)
+	viper.SetDefault("forwarder.delivery.retryBackoffMilliSecond", 500)
+	viper.SetDefault("forwarder.delivery.redeliverIntervalMilliSecond", 500)
+	viper.SetDefault("forwarder.delivery.rateLimit.ratePerSecond", 1)
+	viper.SetDefault("forwarder.delivery.rateLimit.burst", 5)
+	viper.SetDefault("forwarder.delivery.webhook.url", "")
+	viper.SetDefault("forwarder.delivery.webhook.secret", "")
+	viper.SetDefault("forwarder.delivery.webhook.timeoutSecond", 5)
+}
+
+// Validate rejects intervals and rates the forwarder cannot schedule deliveries with
+func (c *ForwarderConfig) Validate() error {
+	if c.Delivery.RedeliverIntervalMilliSecond <= 0 {
+		return fmt.Errorf("error invalid forwarder.delivery.redeliverIntervalMilliSecond %d: must be positive", c.Delivery.RedeliverIntervalMilliSecond)
+	}
+	// a rate limited delivery is put off until the member gets a token again
+	if c.Delivery.RateLimit.RatePerSecond <= 0 {
+		return fmt.Errorf("error invalid forwarder.delivery.rateLimit.ratePerSecond %d: must be positive", c.Delivery.RateLimit.RatePerSecond)
+	}
+	return nil
 }
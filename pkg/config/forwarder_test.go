@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestForwarderConfigValidate(t *testing.T) {
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Forwarder.Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		edit  func(config *ForwarderConfig)
+		valid bool
+	}{
+		{"zero redeliver interval", func(config *ForwarderConfig) { config.Delivery.RedeliverIntervalMilliSecond = 0 }, false},
+		{"zero delivery rate", func(config *ForwarderConfig) { config.Delivery.RateLimit.RatePerSecond = 0 }, false},
+	}
+	for _, c := range cases {
+		forwarderConfig := *config.Forwarder
+		c.edit(&forwarderConfig)
+		if err := forwarderConfig.Validate(); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
 
 type MatchConfig struct {
 	Http struct {
@@ -20,6 +24,23 @@ type MatchConfig struct {
 			}
 		}
 	}
+	Matching struct {
+		FallbackSecond       int64
+		MaxTags              int
+		ScanLimit            int64
+		StatusIntervalSecond int64
+		MaxWaitSecond        int64
+		Confirm              bool
+		ConfirmTimeoutSecond int64
+	}
+	Sharding struct {
+		Shards                  int
+		OverflowShards          int
+		RebalanceDelaySecond    int64
+		RebalanceIntervalSecond int64
+		RebalanceBatch          int64
+		ClaimTimeoutSecond      int64
+	}
 }
 
 func SetDefaultMatchConfig() {
@@ -28,4 +49,33 @@ func SetDefaultMatchConfig() {
 	viper.SetDefault("match.http.server.swag", false)
 	viper.SetDefault("match.grpc.client.chat.endpoint", "reverse-proxy:80")
 	viper.SetDefault("match.grpc.client.user.endpoint", "reverse-proxy:80")
+	viper.SetDefault("match.matching.fallbackSecond", 30)
+	viper.SetDefault("match.matching.maxTags", 5)
+	viper.SetDefault("match.matching.scanLimit", 500)
+	viper.SetDefault("match.matching.statusIntervalSecond", 5)
+	viper.SetDefault("match.matching.maxWaitSecond", 300)
+	viper.SetDefault("match.matching.confirm", false)
+	viper.SetDefault("match.matching.confirmTimeoutSecond", 15)
+	viper.SetDefault("match.sharding.shards", 1)
+	viper.SetDefault("match.sharding.rebalanceDelaySecond", 10)
+	viper.SetDefault("match.sharding.rebalanceIntervalSecond", 2)
+	viper.SetDefault("match.sharding.rebalanceBatch", 100)
+	viper.SetDefault("match.sharding.overflowShards", 4)
+	viper.SetDefault("match.sharding.claimTimeoutSecond", 30)
+}
+
+// Validate rejects intervals the match service cannot tick with
+func (c *MatchConfig) Validate() error {
+	if c.Matching.StatusIntervalSecond <= 0 {
+		return fmt.Errorf("error invalid match.matching.statusIntervalSecond %d: must be positive", c.Matching.StatusIntervalSecond)
+	}
+	// the interval is only used to rebalance a sharded wait list
+	if c.Sharding.Shards > 1 && c.Sharding.RebalanceIntervalSecond <= 0 {
+		return fmt.Errorf("error invalid match.sharding.rebalanceIntervalSecond %d: must be positive", c.Sharding.RebalanceIntervalSecond)
+	}
+	// a claim taken over right away would be merged twice
+	if c.Sharding.Shards > 1 && c.Sharding.ClaimTimeoutSecond <= 0 {
+		return fmt.Errorf("error invalid match.sharding.claimTimeoutSecond %d: must be positive", c.Sharding.ClaimTimeoutSecond)
+	}
+	return nil
 }
@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestMatchConfigValidate(t *testing.T) {
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Match.Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		edit  func(config *MatchConfig)
+		valid bool
+	}{
+		{"zero status interval", func(config *MatchConfig) { config.Matching.StatusIntervalSecond = 0 }, false},
+		{"negative status interval", func(config *MatchConfig) { config.Matching.StatusIntervalSecond = -1 }, false},
+		{"zero rebalance interval when sharded", func(config *MatchConfig) {
+			config.Sharding.Shards = 4
+			config.Sharding.RebalanceIntervalSecond = 0
+		}, false},
+		{"zero rebalance interval when unsharded", func(config *MatchConfig) {
+			config.Sharding.Shards = 1
+			config.Sharding.RebalanceIntervalSecond = 0
+		}, true},
+		{"zero claim timeout when sharded", func(config *MatchConfig) {
+			config.Sharding.Shards = 4
+			config.Sharding.ClaimTimeoutSecond = 0
+		}, false},
+	}
+	for _, c := range cases {
+		matchConfig := *config.Match
+		c.edit(&matchConfig)
+		if err := matchConfig.Validate(); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
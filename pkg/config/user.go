@@ -21,6 +21,11 @@ type UsersConfig struct {
 		Server struct {
 			Port string
 		}
+		Client struct {
+			Chat struct {
+				Endpoint string
+			}
+		}
 	}
 	OAuth struct {
 		Cookie CookieConfig
@@ -34,12 +39,17 @@ type UsersConfig struct {
 	Auth struct {
 		Cookie CookieConfig
 	}
+	Notification struct {
+		InboxSize int64
+		PageSize  int64
+	}
 }
 
 func SetDefaultUserConfig() {
 	viper.SetDefault("users.http.server.port", "80")
 	viper.SetDefault("users.http.server.swag", false)
 	viper.SetDefault("users.grpc.server.port", "4000")
+	viper.SetDefault("users.grpc.client.chat.endpoint", "reverse-proxy:80")
 	viper.SetDefault("users.oauth.cookie.maxAge", 3600)
 	viper.SetDefault("users.oauth.cookie.path", "/")
 	viper.SetDefault("users.oauth.cookie.domain", "localhost")
@@ -50,4 +60,6 @@ func SetDefaultUserConfig() {
 	viper.SetDefault("users.auth.cookie.maxAge", 86400)
 	viper.SetDefault("users.auth.cookie.path", "/")
 	viper.SetDefault("users.auth.cookie.domain", "localhost")
+	viper.SetDefault("users.notification.inboxSize", 200)
+	viper.SetDefault("users.notification.pageSize", 50)
 }
@@ -9,5 +9,9 @@ func NewInfraCloser() *InfraCloser {
 }
 
 func (c *InfraCloser) Close() error {
+	if err := ChatConn.Conn.Close(); err != nil {
+		return err
+	}
+
 	return infra.RedisClient.Close()
 }
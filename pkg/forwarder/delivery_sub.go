@@ -0,0 +1,119 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/thyyl/chatr/pkg/chat"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+)
+
+// deliveryConsumerGroup is shared by every forwarder, so that each message is scheduled and each delivery is
+// sent by a single forwarder
+const deliveryConsumerGroup = "chatr_delivery"
+
+// DeliverySubscriber schedules deliveries for the members a message cannot reach and sends them through the sinks.
+// Deliveries put off by the forwarder service are queued again every redeliver interval.
+type DeliverySubscriber struct {
+	logger            common.GrpcLog
+	router            *message.Router
+	subscriber        message.Subscriber
+	forwarderService  ForwarderService
+	redeliverInterval time.Duration
+	done              chan struct{}
+}
+
+func NewDeliverySubscriber(name string, logger common.GrpcLog, config *config.Config, forwarderService ForwarderService) (*DeliverySubscriber, error) {
+	router, err := infra.NewBrokerRouter(name)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber, err := infra.NewKafkaGroupSubscriber(config, deliveryConsumerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeliverySubscriber{
+		logger:            logger,
+		router:            router,
+		subscriber:        subscriber,
+		forwarderService:  forwarderService,
+		redeliverInterval: time.Duration(config.Forwarder.Delivery.RedeliverIntervalMilliSecond) * time.Millisecond,
+		done:              make(chan struct{}),
+	}, nil
+}
+
+func (s *DeliverySubscriber) HandleMessage(message *message.Message) error {
+	chatMessage, err := chat.DecodeToMessage([]byte(message.Payload))
+	if err != nil {
+		return err
+	}
+
+	return s.forwarderService.ScheduleDeliveries(message.Context(), chatMessage)
+}
+
+// HandleDelivery sends a delivery, retried deliveries are only queued once due. A delivery that failed for good
+// is logged and acknowledged, anything else is left for the broker to redeliver.
+func (s *DeliverySubscriber) HandleDelivery(message *message.Message) error {
+	delivery, err := DecodeToDelivery([]byte(message.Payload))
+	if err != nil {
+		return err
+	}
+
+	err = s.forwarderService.Deliver(message.Context(), delivery)
+	if errors.Is(err, common.ErrorDeliveryFailed) {
+		s.logger.Error(err.Error())
+		return nil
+	}
+	return err
+}
+
+func (s *DeliverySubscriber) RegisterHandler() {
+	s.router.AddNoPublisherHandler(
+		"chatr_delivery_scheduler",
+		common.MessagePubTopic,
+		s.subscriber,
+		s.HandleMessage,
+	)
+	s.router.AddNoPublisherHandler(
+		"chatr_delivery_handler",
+		common.DeliveryPubTopic,
+		s.subscriber,
+		s.HandleDelivery,
+	)
+	s.router.AddNoPublisherHandler(
+		"chatr_delivery_retry_handler",
+		common.DeliveryRetryPubTopic,
+		s.subscriber,
+		s.HandleDelivery,
+	)
+}
+
+func (s *DeliverySubscriber) Run() error {
+	go func() {
+		ticker := time.NewTicker(s.redeliverInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := s.forwarderService.RedeliverDue(context.Background(), time.Now()); err != nil {
+					s.logger.Error(err.Error())
+				}
+			}
+		}
+	}()
+
+	return s.router.Run(context.Background())
+}
+
+func (s *DeliverySubscriber) GracefulStop() error {
+	close(s.done)
+	return s.router.Close()
+}
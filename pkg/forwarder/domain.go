@@ -0,0 +1,45 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/thyyl/chatr/pkg/chat"
+)
+
+// Subscribers maps the users with a session on a channel to the subscriber their session listens on
+type Subscribers map[uint64]string
+
+// Delivery is a message to deliver to a channel member through a sink. Its id names the message, the member and
+// the sink, so that a delivery queued twice is only sent once.
+type Delivery struct {
+	Id      string        `json:"id"`
+	Sink    string        `json:"sink"`
+	UserId  uint64        `json:"userId"`
+	Message *chat.Message `json:"message"`
+	Attempt int           `json:"attempt"`
+	// NotBefore is the time in milliseconds a retried delivery waits for
+	NotBefore int64 `json:"notBefore"`
+}
+
+func NewDelivery(sink string, userId uint64, chatMessage *chat.Message) *Delivery {
+	return &Delivery{
+		Id:      sink + ":" + strconv.FormatUint(chatMessage.MessageId, 10) + ":" + strconv.FormatUint(userId, 10),
+		Sink:    sink,
+		UserId:  userId,
+		Message: chatMessage,
+	}
+}
+
+func (d *Delivery) Encode() []byte {
+	result, _ := json.Marshal(d)
+	return result
+}
+
+func DecodeToDelivery(data []byte) (*Delivery, error) {
+	var delivery Delivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
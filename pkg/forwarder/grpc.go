@@ -12,21 +12,41 @@ import (
 	"google.golang.org/grpc"
 )
 
+var (
+	ChatConn *ChatClientConn
+)
+
+type ChatClientConn struct {
+	Conn *grpc.ClientConn
+}
+
+func NewChatClientConn(config *config.Config) (*ChatClientConn, error) {
+	conn, err := transport.InitializeGrpcClient(config.Forwarder.Grpc.Client.Chat.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	ChatConn = &ChatClientConn{Conn: conn}
+	return ChatConn, nil
+}
+
 type GrpcServer struct {
-	grpcPort          string
-	logger            common.GrpcLog
-	server            *grpc.Server
-	forwarderService  ForwarderService
-	messageSubscriber *MessageSubscriber
+	grpcPort           string
+	logger             common.GrpcLog
+	server             *grpc.Server
+	forwarderService   ForwarderService
+	messageSubscriber  *MessageSubscriber
+	deliverySubscriber *DeliverySubscriber
 	forwarderProto.UnimplementedForwarderServiceServer
 }
 
-func NewGrpcServer(name string, logger common.GrpcLog, config *config.Config, forwarderService ForwarderService, messageSubscriber *MessageSubscriber) *GrpcServer {
+func NewGrpcServer(name string, logger common.GrpcLog, config *config.Config, forwarderService ForwarderService, messageSubscriber *MessageSubscriber, deliverySubscriber *DeliverySubscriber) *GrpcServer {
 	grpcServer := &GrpcServer{
-		grpcPort:          config.Forwarder.Grpc.Server.Port,
-		logger:            logger,
-		forwarderService:  forwarderService,
-		messageSubscriber: messageSubscriber,
+		grpcPort:           config.Forwarder.Grpc.Server.Port,
+		logger:             logger,
+		forwarderService:   forwarderService,
+		messageSubscriber:  messageSubscriber,
+		deliverySubscriber: deliverySubscriber,
 	}
 	grpcServer.server = transport.InitializeGrpcServer(name, grpcServer.logger)
 	return grpcServer
@@ -34,6 +54,7 @@ func NewGrpcServer(name string, logger common.GrpcLog, config *config.Config, fo
 
 func (s *GrpcServer) RegisterServices() {
 	s.messageSubscriber.RegisterHandler()
+	s.deliverySubscriber.RegisterHandler()
 	forwarderProto.RegisterForwarderServiceServer(s.server, s)
 }
 
@@ -61,9 +82,20 @@ func (s *GrpcServer) Run() {
 			os.Exit(1)
 		}
 	}()
+
+	go func() {
+		err := s.deliverySubscriber.Run()
+		if err != nil {
+			s.logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}()
 }
 
 func (s *GrpcServer) GracefulStop() error {
 	s.server.GracefulStop()
-	return s.messageSubscriber.GracefulStop()
+	if err := s.messageSubscriber.GracefulStop(); err != nil {
+		return err
+	}
+	return s.deliverySubscriber.GracefulStop()
 }
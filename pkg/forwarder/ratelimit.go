@@ -0,0 +1,25 @@
+package forwarder
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+)
+
+// DeliveryRateLimiter limits the deliveries a channel member gets through each sink
+type DeliveryRateLimiter struct {
+	*common.RateLimiter
+}
+
+func NewDeliveryRateLimiter(rc redis.UniversalClient, config *config.Config) DeliveryRateLimiter {
+	return DeliveryRateLimiter{
+		common.NewRateLimiter(
+			rc,
+			config.Forwarder.Delivery.RateLimit.RatePerSecond,
+			config.Forwarder.Delivery.RateLimit.Burst,
+			time.Duration(config.Redis.ExpirationHours)*time.Hour,
+		),
+	}
+}
@@ -2,22 +2,37 @@ package forwarder
 
 import (
 	"context"
+	"errors"
 	"strconv"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/thyyl/chatr/pkg/chat"
 	"github.com/thyyl/chatr/pkg/common"
 	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/transport"
+	chatProto "github.com/thyyl/chatr/proto/chat"
 )
 
-type Subscribers map[string]struct{}
+// deliveredTTL is how long a sent delivery is remembered, which covers redeliveries of the delivery topics
+const deliveredTTL = 24 * time.Hour
 
 type ForwarderRepo interface {
 	RegisterChannelSession(ctx context.Context, channelId uint64, userId uint64, subscriber string) error
 	RemoveChannelSession(ctx context.Context, channelId uint64, userId uint64) error
 	GetSubscribers(ctx context.Context, channelId uint64) (Subscribers, error)
 	ForwardMessage(ctx context.Context, chatMessage *chat.Message, subscribers Subscribers) error
+	PublishDelivery(ctx context.Context, delivery *Delivery, retry bool) error
+	ScheduleDelivery(ctx context.Context, delivery *Delivery) error
+	TakeDueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*Delivery, error)
+	IsDelivered(ctx context.Context, deliveryId string) (bool, error)
+	MarkDelivered(ctx context.Context, deliveryId string) error
+}
+
+type ChannelRepo interface {
+	GetChannelUserIds(ctx context.Context, channelId uint64) ([]uint64, error)
 }
 
 type ForwarderRepoImpl struct {
@@ -32,6 +47,22 @@ func NewForwarderRepoImpl(redis infra.RedisCache, publisher message.Publisher) *
 	}
 }
 
+type ChannelRepoImpl struct {
+	getChannelUserIds endpoint.Endpoint
+}
+
+func NewChannelRepoImpl(chatConn *ChatClientConn) *ChannelRepoImpl {
+	return &ChannelRepoImpl{
+		getChannelUserIds: transport.NewGrpcEndpoint(
+			chatConn.Conn,
+			"chat",
+			"chat.UserService",
+			"GetChannelUserIds",
+			&chatProto.GetChannelUserIdsResponse{},
+		),
+	}
+}
+
 func (repo *ForwarderRepoImpl) RegisterChannelSession(ctx context.Context, channelId uint64, userId uint64, subscriber string) error {
 	key := constructKey(channelId)
 	return repo.redis.HSet(ctx, key, strconv.FormatUint(userId, 10), subscriber)
@@ -50,21 +81,30 @@ func (repo *ForwarderRepoImpl) GetSubscribers(ctx context.Context, channelId uin
 	}
 
 	subscribers := make(Subscribers)
-	for _, subscriber := range result {
-		subscribers[subscriber] = struct{}{}
+	for field, subscriber := range result {
+		userId, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		subscribers[userId] = subscriber
 	}
 	return subscribers, nil
 }
 
+// ForwardMessage publishes the message once to every subscriber, however many users listen on it
 func (repo *ForwarderRepoImpl) ForwardMessage(ctx context.Context, chatMessage *chat.Message, subscribers Subscribers) error {
-	var err error
+	forwarded := make(map[string]struct{})
+
+	for _, subscriber := range subscribers {
+		if _, ok := forwarded[subscriber]; ok {
+			continue
+		}
+		forwarded[subscriber] = struct{}{}
 
-	for subscriber := range subscribers {
-		err = repo.publisher.Publish(subscriber, message.NewMessage(
+		err := repo.publisher.Publish(subscriber, message.NewMessage(
 			watermill.NewUUID(),
 			chatMessage.Encode(),
 		))
-
 		if err != nil {
 			return err
 		}
@@ -73,6 +113,70 @@ func (repo *ForwarderRepoImpl) ForwardMessage(ctx context.Context, chatMessage *
 	return nil
 }
 
+func (repo *ForwarderRepoImpl) PublishDelivery(ctx context.Context, delivery *Delivery, retry bool) error {
+	topic := common.DeliveryPubTopic
+	if retry {
+		topic = common.DeliveryRetryPubTopic
+	}
+
+	return repo.publisher.Publish(topic, message.NewMessage(
+		watermill.NewUUID(),
+		delivery.Encode(),
+	))
+}
+
+// ScheduleDelivery puts a delivery off until its NotBefore, see TakeDueDeliveries
+func (repo *ForwarderRepoImpl) ScheduleDelivery(ctx context.Context, delivery *Delivery) error {
+	return repo.redis.ZAdd(ctx, common.DeliveryScheduleRcKey, float64(delivery.NotBefore), string(delivery.Encode()))
+}
+
+// TakeDueDeliveries removes at most limit scheduled deliveries that are due by now, each is taken by a single
+// forwarder. Deliveries that cannot be decoded are dropped and reported along with the others.
+func (repo *ForwarderRepoImpl) TakeDueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*Delivery, error) {
+	members, err := repo.redis.ZPopByScore(ctx, common.DeliveryScheduleRcKey, float64(now.UnixMilli()), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, 0, len(members))
+	var errs []error
+	for _, member := range members {
+		delivery, err := DecodeToDelivery([]byte(member))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, errors.Join(errs...)
+}
+
+// IsDelivered reports whether the delivery was sent already
+func (repo *ForwarderRepoImpl) IsDelivered(ctx context.Context, deliveryId string) (bool, error) {
+	var delivered int
+	return repo.redis.Get(ctx, constructDeliveryKey(deliveryId), &delivered)
+}
+
+// MarkDelivered remembers a sent delivery, so that it is not sent again when queued twice
+func (repo *ForwarderRepoImpl) MarkDelivered(ctx context.Context, deliveryId string) error {
+	return repo.redis.SetEx(ctx, constructDeliveryKey(deliveryId), 1, deliveredTTL)
+}
+
+func (repo *ChannelRepoImpl) GetChannelUserIds(ctx context.Context, channelId uint64) ([]uint64, error) {
+	response, err := repo.getChannelUserIds(ctx, &chatProto.GetChannelUserIdsRequest{
+		ChannelId: channelId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.(*chatProto.GetChannelUserIdsResponse).UserIds, nil
+}
+
 func constructKey(id uint64) string {
 	return common.Join(common.ForwardRcKey, ":", strconv.FormatUint(id, 10))
 }
+
+func constructDeliveryKey(deliveryId string) string {
+	return common.Join(common.DeliveryRcKey, ":", deliveryId)
+}
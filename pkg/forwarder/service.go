@@ -2,23 +2,47 @@ package forwarder
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/thyyl/chatr/pkg/chat"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 )
 
 type ForwarderService interface {
 	RegisterChannelSession(ctx context.Context, channelId uint64, userId uint64, subscriber string) error
 	RemoveChannelSession(ctx context.Context, channelId uint64, userId uint64) error
 	ForwardMessage(ctx context.Context, chatMessage *chat.Message) error
+	ScheduleDeliveries(ctx context.Context, chatMessage *chat.Message) error
+	Deliver(ctx context.Context, delivery *Delivery) error
+	RedeliverDue(ctx context.Context, now time.Time) error
 }
 
+// redeliverBatch is the most deliveries queued again at once
+const redeliverBatch = 100
+
 type ForwarderServiceImpl struct {
 	forwarderRepo ForwarderRepo
+	channelRepo   ChannelRepo
+	sinks         Sinks
+	rateLimiter   DeliveryRateLimiter
+	maxAttempts   int
+	retryBackoff  time.Duration
+	// rateLimitDelay is how long a member waits for another delivery token
+	rateLimitDelay time.Duration
 }
 
-func NewForwarderServiceImpl(forwardRepo ForwarderRepo) *ForwarderServiceImpl {
+func NewForwarderServiceImpl(forwardRepo ForwarderRepo, channelRepo ChannelRepo, sinks Sinks, rateLimiter DeliveryRateLimiter, config *config.Config) *ForwarderServiceImpl {
 	return &ForwarderServiceImpl{
-		forwardRepo,
+		forwarderRepo:  forwardRepo,
+		channelRepo:    channelRepo,
+		sinks:          sinks,
+		rateLimiter:    rateLimiter,
+		maxAttempts:    config.Forwarder.Delivery.MaxAttempts,
+		retryBackoff:   time.Duration(config.Forwarder.Delivery.RetryBackoffMilliSecond) * time.Millisecond,
+		rateLimitDelay: time.Second / time.Duration(config.Forwarder.Delivery.RateLimit.RatePerSecond),
 	}
 }
 
@@ -37,3 +61,109 @@ func (s *ForwarderServiceImpl) ForwardMessage(ctx context.Context, chatMessage *
 	}
 	return s.forwarderRepo.ForwardMessage(ctx, chatMessage, subscribers)
 }
+
+// ScheduleDeliveries queues a delivery through every sink for each member of the channel with no subscriber,
+// as the message cannot reach them otherwise. This is the only path that notifies such members, the chat service
+// leaves them to the forwarder. Only texts and files are delivered.
+func (s *ForwarderServiceImpl) ScheduleDeliveries(ctx context.Context, chatMessage *chat.Message) error {
+	if len(s.sinks) == 0 || (chatMessage.Event != chat.EventText && chatMessage.Event != chat.EventFile) {
+		return nil
+	}
+
+	subscribers, err := s.forwarderRepo.GetSubscribers(ctx, chatMessage.ChannelId)
+	if err != nil {
+		return fmt.Errorf("error get subscribers of channel %d: %w", chatMessage.ChannelId, err)
+	}
+	userIds, err := s.channelRepo.GetChannelUserIds(ctx, chatMessage.ChannelId)
+	if err != nil {
+		return fmt.Errorf("error get user ids in channel %d: %w", chatMessage.ChannelId, err)
+	}
+
+	for _, userId := range userIds {
+		if _, ok := subscribers[userId]; ok || userId == chatMessage.UserId {
+			continue
+		}
+
+		for name := range s.sinks {
+			if err := s.forwarderRepo.PublishDelivery(ctx, NewDelivery(name, userId, chatMessage), false); err != nil {
+				return fmt.Errorf("error publish delivery of message %d to user %d: %w", chatMessage.MessageId, userId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Deliver sends a delivery through its sink, unless it was sent already. A first attempt is put off while the
+// member got too many deliveries lately, a failed attempt is put off with an exponential backoff until the
+// attempts run out. Deliveries put off are queued again by RedeliverDue.
+func (s *ForwarderServiceImpl) Deliver(ctx context.Context, delivery *Delivery) error {
+	sink, ok := s.sinks[delivery.Sink]
+	if !ok {
+		return fmt.Errorf("%w: delivery %s has unknown sink", common.ErrorDeliveryFailed, delivery.Id)
+	}
+
+	delivered, err := s.forwarderRepo.IsDelivered(ctx, delivery.Id)
+	if err != nil {
+		return fmt.Errorf("error check delivery %s: %w", delivery.Id, err)
+	}
+	if delivered {
+		return nil
+	}
+
+	if delivery.Attempt == 0 {
+		allowed, err := s.rateLimiter.Allow(ctx, common.Join("delivery:", delivery.Sink, ":", strconv.FormatUint(delivery.UserId, 10)))
+		if err != nil {
+			return fmt.Errorf("error rate limit delivery %s: %w", delivery.Id, err)
+		}
+		if !allowed {
+			later := *delivery
+			later.NotBefore = time.Now().Add(s.rateLimitDelay).UnixMilli()
+			if err := s.forwarderRepo.ScheduleDelivery(ctx, &later); err != nil {
+				return fmt.Errorf("error schedule rate limited delivery %s: %w", delivery.Id, err)
+			}
+			return nil
+		}
+	}
+
+	deliverErr := sink.Deliver(ctx, delivery)
+	if deliverErr == nil {
+		if err := s.forwarderRepo.MarkDelivered(ctx, delivery.Id); err != nil {
+			return fmt.Errorf("error mark delivery %s as sent: %w", delivery.Id, err)
+		}
+		return nil
+	}
+	if delivery.Attempt+1 >= s.maxAttempts {
+		return fmt.Errorf("%w: delivery %s gave up after %d attempts: %w", common.ErrorDeliveryFailed, delivery.Id, delivery.Attempt+1, deliverErr)
+	}
+
+	retry := *delivery
+	retry.Attempt++
+	retry.NotBefore = time.Now().Add(s.retryBackoff << delivery.Attempt).UnixMilli()
+	if err := s.forwarderRepo.ScheduleDelivery(ctx, &retry); err != nil {
+		return fmt.Errorf("error schedule retry of delivery %s: %w", delivery.Id, err)
+	}
+	return nil
+}
+
+// RedeliverDue queues the deliveries put off by Deliver that are due by now again. A delivery that cannot be
+// queued is put back in the schedule along with the ones after it.
+func (s *ForwarderServiceImpl) RedeliverDue(ctx context.Context, now time.Time) error {
+	deliveries, takeErr := s.forwarderRepo.TakeDueDeliveries(ctx, now, redeliverBatch)
+	if takeErr != nil {
+		takeErr = fmt.Errorf("error take due deliveries: %w", takeErr)
+	}
+
+	for i, delivery := range deliveries {
+		publishErr := s.forwarderRepo.PublishDelivery(ctx, delivery, true)
+		if publishErr == nil {
+			continue
+		}
+		for _, rest := range deliveries[i:] {
+			if err := s.forwarderRepo.ScheduleDelivery(ctx, rest); err != nil {
+				return fmt.Errorf("error schedule delivery %s again: %w", rest.Id, err)
+			}
+		}
+		return fmt.Errorf("error publish due delivery %s: %w", delivery.Id, publishErr)
+	}
+	return takeErr
+}
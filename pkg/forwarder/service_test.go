@@ -0,0 +1,196 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/infra/infratest"
+)
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	messages map[string][]*message.Message
+}
+
+func (p *fakePublisher) Publish(topic string, messages ...*message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.messages == nil {
+		p.messages = make(map[string][]*message.Message)
+	}
+	p.messages[topic] = append(p.messages[topic], messages...)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+// take removes the deliveries published to the topic
+func (p *fakePublisher) take(t *testing.T, topic string) []*Delivery {
+	t.Helper()
+
+	p.mu.Lock()
+	messages := p.messages[topic]
+	delete(p.messages, topic)
+	p.mu.Unlock()
+
+	deliveries := make([]*Delivery, len(messages))
+	for i, message := range messages {
+		delivery, err := DecodeToDelivery(message.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries[i] = delivery
+	}
+	return deliveries
+}
+
+// newTestWebhook counts the requests to a webhook that fails the first failures of them
+func newTestWebhook(t *testing.T, failures int64) (string, *atomic.Int64) {
+	t.Helper()
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+// newTestForwarderService sends deliveries to the webhook and keeps its schedule in miniredis
+func newTestForwarderService(t *testing.T, url string, maxAttempts int, burst int) (*ForwarderServiceImpl, *miniredis.Miniredis, *fakePublisher) {
+	t.Helper()
+
+	config, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Forwarder.Delivery.MaxAttempts = maxAttempts
+	config.Forwarder.Delivery.RateLimit.Burst = burst
+	config.Forwarder.Delivery.Webhook.Url = url
+	config.Forwarder.Delivery.Webhook.Secret = testWebhookSecret
+
+	client, mr := infratest.NewRedisClient(t)
+	publisher := &fakePublisher{}
+	forwarderRepo := NewForwarderRepoImpl(infra.NewRedisCacheImpl(client), publisher)
+	sinks := Sinks{WebhookSinkName: NewWebhookSink(config)}
+	forwarderService := NewForwarderServiceImpl(forwarderRepo, nil, sinks, NewDeliveryRateLimiter(client, config), config)
+	return forwarderService, mr, publisher
+}
+
+// redeliver returns the scheduled deliveries queued again as of now
+func redeliver(t *testing.T, forwarderService *ForwarderServiceImpl, publisher *fakePublisher, now time.Time) []*Delivery {
+	t.Helper()
+
+	if err := forwarderService.RedeliverDue(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	return publisher.take(t, common.DeliveryRetryPubTopic)
+}
+
+func TestDeliverRetriesUntilAttemptsRunOut(t *testing.T) {
+	url, requests := newTestWebhook(t, 10)
+	forwarderService, _, publisher := newTestForwarderService(t, url, 3, 5)
+	ctx := context.Background()
+
+	delivery := newTestDelivery(10, 3)
+	for attempt := range 2 {
+		if err := forwarderService.Deliver(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+		retries := redeliver(t, forwarderService, publisher, time.Now().Add(time.Minute))
+		if len(retries) != 1 || retries[0].Id != delivery.Id || retries[0].Attempt != attempt+1 {
+			t.Fatalf("expected attempt %d of delivery %s to be queued again, got %+v", attempt+1, delivery.Id, retries)
+		}
+		delivery = retries[0]
+	}
+
+	if err := forwarderService.Deliver(ctx, delivery); !errors.Is(err, common.ErrorDeliveryFailed) {
+		t.Fatalf("expected the last attempt to give up, got %v", err)
+	}
+	if retries := redeliver(t, forwarderService, publisher, time.Now().Add(time.Minute)); len(retries) != 0 {
+		t.Fatalf("expected no retry after the last attempt, got %+v", retries)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests.Load())
+	}
+}
+
+func TestDeliverSendsOnce(t *testing.T) {
+	url, requests := newTestWebhook(t, 1)
+	forwarderService, _, publisher := newTestForwarderService(t, url, 3, 5)
+	ctx := context.Background()
+
+	delivery := newTestDelivery(10, 3)
+	if err := forwarderService.Deliver(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+	retries := redeliver(t, forwarderService, publisher, time.Now().Add(time.Minute))
+	if len(retries) != 1 {
+		t.Fatalf("expected the failed delivery to be queued again, got %+v", retries)
+	}
+	if err := forwarderService.Deliver(ctx, retries[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broker redelivers both attempts
+	for _, redelivered := range []*Delivery{delivery, retries[0]} {
+		if err := forwarderService.Deliver(ctx, redelivered); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected a failed and a successful request, got %d", requests.Load())
+	}
+}
+
+func TestDeliverPutsOffRateLimited(t *testing.T) {
+	url, requests := newTestWebhook(t, 0)
+	forwarderService, mr, publisher := newTestForwarderService(t, url, 3, 1)
+	ctx := context.Background()
+
+	for _, delivery := range []*Delivery{newTestDelivery(10, 3), newTestDelivery(11, 3)} {
+		if err := forwarderService.Deliver(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("expected the member to get a single delivery, got %d", requests.Load())
+	}
+
+	scheduled, err := mr.ZMembers(common.DeliveryScheduleRcKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 1 {
+		t.Fatalf("expected the rate limited delivery to be put off, got %v", scheduled)
+	}
+	delivery, err := DecodeToDelivery([]byte(scheduled[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Id != newTestDelivery(11, 3).Id || delivery.Attempt != 0 {
+		t.Fatalf("expected the first attempt of the second delivery, got %+v", delivery)
+	}
+	// the member gets a token again only after a second
+	if retries := redeliver(t, forwarderService, publisher, time.Now()); len(retries) != 0 {
+		t.Fatalf("expected the delivery to wait for the rate limit, got %+v", retries)
+	}
+	if retries := redeliver(t, forwarderService, publisher, time.Now().Add(time.Second)); len(retries) != 1 || retries[0].Id != delivery.Id {
+		t.Fatalf("expected the delivery to be queued again once the member gets a token, got %+v", retries)
+	}
+}
@@ -0,0 +1,177 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/thyyl/chatr/pkg/chat"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+)
+
+const (
+	InboxSinkName          = "inbox"
+	WebhookSinkName        = "webhook"
+	WebhookSignatureHeader = "X-Chatr-Signature"
+	WebhookDeliveryHeader  = "X-Chatr-Delivery"
+)
+
+// Sink delivers messages to channel members outside of their chat sessions
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, delivery *Delivery) error
+}
+
+// Sinks holds the configured sinks by name. The forwarder owns delivery to the members a message cannot reach:
+// the inbox sink always notifies them, and the webhook sink also posts them the message when one is configured.
+type Sinks map[string]Sink
+
+func NewSinks(config *config.Config, publisher message.Publisher) Sinks {
+	inboxSink := NewInboxSink(publisher)
+	sinks := Sinks{inboxSink.Name(): inboxSink}
+	if config.Forwarder.Delivery.Webhook.Url != "" {
+		webhookSink := NewWebhookSink(config)
+		sinks[webhookSink.Name()] = webhookSink
+	}
+	return sinks
+}
+
+// InboxSink notifies members of the message through the notification topic, the user service keeps the
+// notification in their inbox
+type InboxSink struct {
+	publisher message.Publisher
+}
+
+func NewInboxSink(publisher message.Publisher) *InboxSink {
+	return &InboxSink{
+		publisher: publisher,
+	}
+}
+
+func (s *InboxSink) Name() string {
+	return InboxSinkName
+}
+
+func (s *InboxSink) Deliver(ctx context.Context, delivery *Delivery) error {
+	notification := common.NewNotification(delivery.UserId, common.MessageNotification, common.NotificationPayload{
+		ChannelId: delivery.Message.ChannelId,
+		PeerId:    delivery.Message.UserId,
+		MessageId: delivery.Message.MessageId,
+	})
+	return s.publisher.Publish(common.NotificationPubTopic, message.NewMessage(
+		watermill.NewUUID(),
+		notification.Encode(),
+	))
+}
+
+// WebhookEvent is the body posted to the webhook
+type WebhookEvent struct {
+	DeliveryId string           `json:"deliveryId"`
+	UserId     string           `json:"userId"`
+	Message    *chat.MessageDto `json:"message"`
+}
+
+// WebhookSink posts deliveries to an HTTP endpoint. Every request is signed with the shared secret, see
+// VerifyWebhookSignature.
+type WebhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhookSink(config *config.Config) *WebhookSink {
+	return &WebhookSink{
+		url:    config.Forwarder.Delivery.Webhook.Url,
+		secret: []byte(config.Forwarder.Delivery.Webhook.Secret),
+		client: &http.Client{
+			Timeout: time.Duration(config.Forwarder.Delivery.Webhook.TimeoutSecond) * time.Second,
+		},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return WebhookSinkName
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, delivery *Delivery) error {
+	body, err := json.Marshal(&WebhookEvent{
+		DeliveryId: delivery.Id,
+		UserId:     strconv.FormatUint(delivery.UserId, 10),
+		Message:    delivery.Message.ToPresenter(),
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookDeliveryHeader, delivery.Id)
+	request.Header.Set(WebhookSignatureHeader, signWebhook(s.secret, time.Now(), body))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook signs the time along with the body, so that a captured request cannot be replayed later on.
+// The signature reads t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">.
+func signWebhook(secret []byte, now time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + computeWebhookMac(secret, timestamp, body)
+}
+
+func computeWebhookMac(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature header of a webhook request, for receivers written in Go. Requests
+// signed longer than tolerance ago are rejected.
+func VerifyWebhookSignature(secret string, signature string, body []byte, tolerance time.Duration) error {
+	var timestamp, mac string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			mac = value
+		}
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return common.ErrorInvalidSignature
+	}
+	if age := time.Since(time.Unix(signedAt, 0)); age > tolerance || age < -tolerance {
+		return common.ErrorInvalidSignature
+	}
+	if !hmac.Equal([]byte(mac), []byte(computeWebhookMac([]byte(secret), timestamp, body))) {
+		return common.ErrorInvalidSignature
+	}
+	return nil
+}
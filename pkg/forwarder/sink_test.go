@@ -0,0 +1,103 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thyyl/chatr/pkg/chat"
+	"github.com/thyyl/chatr/pkg/config"
+)
+
+const testWebhookSecret = "secret"
+
+func newTestWebhookSink(t *testing.T, url string) *WebhookSink {
+	t.Helper()
+
+	config, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Forwarder.Delivery.Webhook.Url = url
+	config.Forwarder.Delivery.Webhook.Secret = testWebhookSecret
+	return NewWebhookSink(config)
+}
+
+func newTestDelivery(messageId uint64, userId uint64) *Delivery {
+	return NewDelivery(WebhookSinkName, userId, &chat.Message{
+		MessageId: messageId,
+		Event:     chat.EventText,
+		ChannelId: 1,
+		UserId:    2,
+		Payload:   "hello",
+	})
+}
+
+func TestWebhookSinkDeliver(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+
+	delivery := newTestDelivery(10, 3)
+	if err := newTestWebhookSink(t, server.URL).Deliver(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyWebhookSignature(testWebhookSecret, header.Get(WebhookSignatureHeader), body, time.Minute); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	if id := header.Get(WebhookDeliveryHeader); id != delivery.Id {
+		t.Fatalf("expected delivery id %s, got %s", delivery.Id, id)
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.DeliveryId != delivery.Id || event.UserId != "3" || event.Message.MessageId != "10" || event.Message.Payload != "hello" {
+		t.Fatalf("expected the event of delivery %s, got %+v", delivery.Id, event)
+	}
+}
+
+func TestWebhookSinkDeliverFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	if err := newTestWebhookSink(t, server.URL).Deliver(context.Background(), newTestDelivery(10, 3)); err == nil {
+		t.Fatal("expected an error status to fail the delivery")
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"deliveryId":"1"}`)
+	now := time.Now()
+
+	cases := []struct {
+		name      string
+		secret    string
+		signature string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", testWebhookSecret, signWebhook([]byte(testWebhookSecret), now, body), body, true},
+		{"other body", testWebhookSecret, signWebhook([]byte(testWebhookSecret), now, body), []byte(`{"deliveryId":"2"}`), false},
+		{"other secret", "other", signWebhook([]byte(testWebhookSecret), now, body), body, false},
+		{"expired", testWebhookSecret, signWebhook([]byte(testWebhookSecret), now.Add(-10*time.Minute), body), body, false},
+		{"signed in the future", testWebhookSecret, signWebhook([]byte(testWebhookSecret), now.Add(10*time.Minute), body), body, false},
+		{"malformed", testWebhookSecret, "v1=abc", body, false},
+	}
+	for _, c := range cases {
+		if err := VerifyWebhookSignature(c.secret, c.signature, c.body, 5*time.Minute); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
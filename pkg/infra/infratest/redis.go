@@ -0,0 +1,21 @@
+// Package infratest runs the infrastructure the services depend on in memory for tests
+package infratest
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient starts a miniredis server for the test and connects a client to it, both are closed when the
+// test ends. The server lets tests inspect keys and fast forward expiry.
+func NewRedisClient(t testing.TB) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return client, mr
+}
@@ -31,7 +31,14 @@ func NewKafkaPublisher(config *config.Config) (message.Publisher, error) {
 	return kafkaPublisher, nil
 }
 
+// NewKafkaSubscriber subscribes with a consumer group of its own, so that every server gets every message
 func NewKafkaSubscriber(config *config.Config) (message.Subscriber, error) {
+	return NewKafkaGroupSubscriber(config, watermill.NewUUID())
+}
+
+// NewKafkaGroupSubscriber subscribes with the given consumer group, so that servers sharing the group split
+// the messages between them
+func NewKafkaGroupSubscriber(config *config.Config, consumerGroup string) (message.Subscriber, error) {
 	saramaConfig := sarama.NewConfig()
 	saramaVersion, err := sarama.ParseKafkaVersion(config.Kafka.Version)
 	if err != nil {
@@ -47,7 +54,7 @@ func NewKafkaSubscriber(config *config.Config) (message.Subscriber, error) {
 		kafka.SubscriberConfig{
 			Brokers:                common.GetServerAddress(config.Kafka.Address),
 			Unmarshaler:            kafka.DefaultMarshaler{},
-			ConsumerGroup:          watermill.NewUUID(),
+			ConsumerGroup:          consumerGroup,
 			InitializeTopicDetails: &sarama.TopicDetail{NumPartitions: 1, ReplicationFactor: 2},
 			OverwriteSaramaConfig:  saramaConfig,
 		},
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/extra/redisotel/v9"
@@ -17,7 +18,7 @@ var (
 	//ErrRedisUnlockFail is redis unlock fail error
 	ErrRedisUnlockFail = errors.New("redis unlock fail")
 	// ErrRedisPipelineCmdNotFound is redis command not found error
-	ErrRedisPipelineCmdNotFound = errors.New("redis pipeline command not found; supports only SET and DELETE")
+	ErrRedisPipelineCmdNotFound = errors.New("redis pipeline command not found; supports only DELETE, HSETONE, RPUSH, SADD, SREM and EXPIRE")
 
 	expiration time.Duration
 )
@@ -25,7 +26,10 @@ var (
 // RedisCache is the interface of redis cache
 type RedisCache interface {
 	Get(ctx context.Context, key string, dst interface{}) (bool, error)
+	GetMany(ctx context.Context, keys []string) (map[string]string, error)
 	Set(ctx context.Context, key string, val interface{}) error
+	SetNX(ctx context.Context, key string, val interface{}, ttl time.Duration) (bool, error)
+	SetEx(ctx context.Context, key string, val interface{}, ttl time.Duration) error
 	Delete(ctx context.Context, key string) error
 	HGet(ctx context.Context, key, field string, dst interface{}) (bool, error)
 	HMGet(ctx context.Context, key string, fields []string) ([]interface{}, error)
@@ -34,9 +38,30 @@ type RedisCache interface {
 	HDel(ctx context.Context, key, field string) error
 	RPush(ctx context.Context, key string, val interface{}) error
 	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+	SInter(ctx context.Context, keys ...string) ([]string, error)
 	Publish(ctx context.Context, topic string, payload interface{}) error
-	ZPopMinOrAddOne(ctx context.Context, key string, score float64, member interface{}) (bool, string, error)
-	ZRemOne(ctx context.Context, key string, member interface{}) error
+	MatchOrWait(ctx context.Context, keys WaitListKeys, request *MatchRequest) (bool, string, error)
+	ClaimWaiting(ctx context.Context, keys WaitListKeys, request *ClaimRequest) ([]*WaitingMember, error)
+	SettleWaiting(ctx context.Context, keys WaitListKeys, member string, claim string) (bool, error)
+	LeaveWaitList(ctx context.Context, keys WaitListKeys, member string) (bool, string, error)
+	MarkLeft(ctx context.Context, keys WaitListKeys, member string, claim string, now float64) error
+	ZRemOne(ctx context.Context, key string, member interface{}) (bool, error)
+	ZRank(ctx context.Context, key string, member string) (int64, bool, error)
+	OpenBallot(ctx context.Context, key string, voters []string, ttl time.Duration) error
+	CastBallot(ctx context.Context, key string, voter string, approve bool) (BallotStatus, map[string]bool, error)
+	CloseBallot(ctx context.Context, key string) (BallotStatus, map[string]bool, error)
+	ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error)
+	ZRevRangeByScore(ctx context.Context, key string, max, min string, limit int64) ([]string, error)
+	ZCount(ctx context.Context, key string, min, max string) (int64, error)
+	ZAdd(ctx context.Context, key string, score float64, member string) error
+	ZPopByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error)
+	ZAddAndTrim(ctx context.Context, key string, score float64, member string, maxLen int64) error
+	ZAddAndPrune(ctx context.Context, key string, score float64, member string, maxExpiredScore float64) ([]string, []string, error)
+	ZRemAndPrune(ctx context.Context, key string, member string, maxExpiredScore float64) ([]string, []string, error)
 	HGetIfKeyExists(ctx context.Context, key, field string, dst interface{}) (bool, bool, error)
 	ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) error
 }
@@ -54,6 +79,9 @@ const (
 	DELETE RedisOpType = iota
 	HSETONE
 	RPUSH
+	SADD
+	SREM
+	EXPIRE
 )
 
 // RedisPayload is a abstract interface for payload type
@@ -80,10 +108,31 @@ type RedisRpushPayload struct {
 	Val interface{}
 }
 
+type RedisSaddPayload struct {
+	RedisPayload
+	Key     string
+	Members []interface{}
+}
+
+type RedisSremPayload struct {
+	RedisPayload
+	Key     string
+	Members []interface{}
+}
+
+type RedisExpirePayload struct {
+	RedisPayload
+	Key string
+	Ttl time.Duration
+}
+
 // Payload implements abstract interface
 func (RedisDeletePayload) Payload()  {}
 func (RedisHsetOnePayload) Payload() {}
 func (RedisRpushPayload) Payload()   {}
+func (RedisSaddPayload) Payload()    {}
+func (RedisSremPayload) Payload()    {}
+func (RedisExpirePayload) Payload()  {}
 
 // RedisCmd represents an operation and its payload
 type RedisCmd struct {
@@ -141,6 +190,32 @@ func (rc *RedisCacheImpl) Get(ctx context.Context, key string, dst interface{})
 	return true, nil
 }
 
+// GetMany returns the values of the keys that exist. The keys are read in one pipeline rather than with MGET,
+// since they may live in different cluster slots.
+func (rc *RedisCacheImpl) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	if _, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[keys[i]] = val
+	}
+	return values, nil
+}
+
 // Set sets a key-value pair
 func (rc *RedisCacheImpl) Set(ctx context.Context, key string, val interface{}) error {
 	if err := rc.client.Set(ctx, key, val, expiration).Err(); err != nil {
@@ -149,6 +224,16 @@ func (rc *RedisCacheImpl) Set(ctx context.Context, key string, val interface{})
 	return nil
 }
 
+// SetNX sets a key-value pair expiring after ttl only if the key does not exist yet, and reports whether it did
+func (rc *RedisCacheImpl) SetNX(ctx context.Context, key string, val interface{}, ttl time.Duration) (bool, error) {
+	return rc.client.SetNX(ctx, key, val, ttl).Result()
+}
+
+// SetEx sets a key-value pair expiring after ttl instead of the global expiration
+func (rc *RedisCacheImpl) SetEx(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	return rc.client.Set(ctx, key, val, ttl).Err()
+}
+
 // Delete deletes a key
 func (rc *RedisCacheImpl) Delete(ctx context.Context, key string) error {
 	if err := rc.client.Del(ctx, key).Err(); err != nil {
@@ -195,39 +280,243 @@ func (rc *RedisCacheImpl) LRange(ctx context.Context, key string, start, stop in
 	return rc.client.LRange(ctx, key, start, stop).Result()
 }
 
+func (rc *RedisCacheImpl) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return rc.client.SAdd(ctx, key, members...).Err()
+}
+
+func (rc *RedisCacheImpl) SRem(ctx context.Context, key string, members ...interface{}) error {
+	return rc.client.SRem(ctx, key, members...).Err()
+}
+
+func (rc *RedisCacheImpl) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return rc.client.SIsMember(ctx, key, member).Result()
+}
+
+func (rc *RedisCacheImpl) SMembers(ctx context.Context, key string) ([]string, error) {
+	return rc.client.SMembers(ctx, key).Result()
+}
+
+// SInter requires all keys to hash to the same slot in cluster mode
+func (rc *RedisCacheImpl) SInter(ctx context.Context, keys ...string) ([]string, error) {
+	return rc.client.SInter(ctx, keys...).Result()
+}
+
 func (rc *RedisCacheImpl) Publish(ctx context.Context, topic string, payload interface{}) error {
 	return rc.client.Publish(ctx, topic, payload).Err()
 }
 
-var zPopMinOrAddOne = redis.NewScript(`
+// ZRemOne removes a member and reports whether it was in the set
+func (rc *RedisCacheImpl) ZRemOne(ctx context.Context, key string, member interface{}) (bool, error) {
+	removed, err := rc.client.ZRem(ctx, key, member).Result()
+	if err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// BallotStatus is the outcome of a ballot that needs every voter to approve
+type BallotStatus string
+
+const (
+	// BallotMissing means the ballot was already decided or expired, or the voter is not on it
+	BallotMissing  BallotStatus = "missing"
+	BallotPending  BallotStatus = "pending"
+	BallotApproved BallotStatus = "approved"
+	BallotRejected BallotStatus = "rejected"
+)
+
+var castBallot = redis.NewScript(`
 local key = KEYS[1]
-local score = ARGV[1]
-local member = ARGV[2]
-local popmembers = {}
+local voter = ARGV[1]
+local approve = ARGV[2] == "1"
 
-local existed_score = redis.call("ZSCORE", key, member)
-if existed_score then
-  return ""
+local fields = redis.call("HGETALL", key)
+if #fields == 0 then
+  return {"missing"}
+end
+local votes = {}
+for i = 1, #fields, 2 do
+  votes[fields[i]] = fields[i + 1]
+end
+if voter ~= "" and votes[voter] == nil then
+  return {"missing"}
 end
 
-popmembers = redis.call("ZPOPMIN", key)
-if popmembers[1] then
-  return popmembers[1]
+local status = "rejected"
+if approve then
+  votes[voter] = "1"
+  redis.call("HSET", key, voter, "1")
+  status = "approved"
+  for _, vote in pairs(votes) do
+    if vote ~= "1" then
+      return {"pending"}
+    end
+  end
+elseif voter ~= "" then
+  votes[voter] = "0"
 end
 
-redis.call("ZADD", key, score, member)
-return ""
+redis.call("DEL", key)
+local result = {status}
+for member, vote in pairs(votes) do
+  table.insert(result, member)
+  table.insert(result, vote)
+end
+return result
 `)
 
-func (rc *RedisCacheImpl) ZPopMinOrAddOne(ctx context.Context, key string, score float64, member interface{}) (bool, string, error) {
-	poppedMember, err := zPopMinOrAddOne.Run(ctx, rc.client, []string{key}, score, member).Text()
+// OpenBallot starts a ballot between the voters that lapses after ttl
+func (rc *RedisCacheImpl) OpenBallot(ctx context.Context, key string, voters []string, ttl time.Duration) error {
+	values := make([]interface{}, 0, 2*len(voters))
+	for _, voter := range voters {
+		values = append(values, voter, "0")
+	}
+
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, values...)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// CastBallot records the vote of a voter. A ballot is decided, and removed, once every voter approved or
+// any voter rejected; the votes are returned together with the decision.
+func (rc *RedisCacheImpl) CastBallot(ctx context.Context, key string, voter string, approve bool) (BallotStatus, map[string]bool, error) {
+	vote := "0"
+	if approve {
+		vote = "1"
+	}
+	return rc.castBallot(ctx, key, voter, vote)
+}
+
+// CloseBallot rejects a ballot still undecided, returning the votes cast so far
+func (rc *RedisCacheImpl) CloseBallot(ctx context.Context, key string) (BallotStatus, map[string]bool, error) {
+	return rc.castBallot(ctx, key, "", "0")
+}
+
+func (rc *RedisCacheImpl) castBallot(ctx context.Context, key string, voter string, vote string) (BallotStatus, map[string]bool, error) {
+	result, err := castBallot.Run(ctx, rc.client, []string{key}, voter, vote).StringSlice()
 	if err != nil {
-		return false, "", err
+		return "", nil, err
 	}
-	return (poppedMember != ""), poppedMember, nil
+
+	votes := make(map[string]bool)
+	for i := 1; i+1 < len(result); i += 2 {
+		votes[result[i]] = result[i+1] == "1"
+	}
+	return BallotStatus(result[0]), votes, nil
+}
+
+// ZRank returns the 0-based rank of a member by ascending score and whether the member is in the set
+func (rc *RedisCacheImpl) ZRank(ctx context.Context, key string, member string) (int64, bool, error) {
+	rank, err := rc.client.ZRank(ctx, key, member).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return rank, true, nil
+}
+
+func (rc *RedisCacheImpl) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	return rc.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: min,
+		Max: max,
+	}).Result()
+}
+
+// ZRevRangeByScore returns at most limit members scored between max and min, highest score first
+func (rc *RedisCacheImpl) ZRevRangeByScore(ctx context.Context, key string, max, min string, limit int64) ([]string, error) {
+	return rc.client.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   min,
+		Max:   max,
+		Count: limit,
+	}).Result()
+}
+
+func (rc *RedisCacheImpl) ZCount(ctx context.Context, key string, min, max string) (int64, error) {
+	return rc.client.ZCount(ctx, key, min, max).Result()
+}
+
+func (rc *RedisCacheImpl) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return rc.client.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+var zPopByScore = redis.NewScript(`
+local key = KEYS[1]
+local max = ARGV[1]
+local limit = tonumber(ARGV[2])
+
+local members = redis.call("ZRANGEBYSCORE", key, "-inf", max, "LIMIT", 0, limit)
+if #members > 0 then
+  redis.call("ZREM", key, unpack(members))
+end
+return members
+`)
+
+// ZPopByScore removes and returns at most limit members scored at most max, lowest score first. Every member is
+// returned to a single caller.
+func (rc *RedisCacheImpl) ZPopByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error) {
+	return zPopByScore.Run(ctx, rc.client, []string{key}, strconv.FormatFloat(max, 'f', -1, 64), limit).StringSlice()
+}
+
+// ZAddAndTrim adds a member and drops the lowest scored members beyond maxLen in one transaction
+func (rc *RedisCacheImpl) ZAddAndTrim(ctx context.Context, key string, score float64, member string, maxLen int64) error {
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: member})
+		pipe.ZRemRangeByRank(ctx, key, 0, -maxLen-1)
+		return nil
+	})
+	return err
+}
+
+var zUpdateAndPrune = redis.NewScript(`
+local key = KEYS[1]
+local member = ARGV[1]
+local score = ARGV[2]
+local maxExpiredScore = ARGV[3]
+
+local expired = redis.call("ZRANGEBYSCORE", key, "-inf", maxExpiredScore)
+if #expired > 0 then
+  redis.call("ZREMRANGEBYSCORE", key, "-inf", maxExpiredScore)
+end
+
+if score == "" then
+  redis.call("ZREM", key, member)
+else
+  redis.call("ZADD", key, score, member)
+end
+
+return {expired, redis.call("ZRANGE", key, 0, -1)}
+`)
+
+// ZAddAndPrune upserts a member after removing every member scored at most maxExpiredScore.
+// It returns the removed members and the members left in the set.
+func (rc *RedisCacheImpl) ZAddAndPrune(ctx context.Context, key string, score float64, member string, maxExpiredScore float64) ([]string, []string, error) {
+	return rc.zUpdateAndPrune(ctx, key, member, strconv.FormatFloat(score, 'f', -1, 64), maxExpiredScore)
 }
-func (rc *RedisCacheImpl) ZRemOne(ctx context.Context, key string, member interface{}) error {
-	return rc.client.ZRem(ctx, key, member).Err()
+
+// ZRemAndPrune removes a member along with every member scored at most maxExpiredScore.
+// It returns the expired members and the members left in the set.
+func (rc *RedisCacheImpl) ZRemAndPrune(ctx context.Context, key string, member string, maxExpiredScore float64) ([]string, []string, error) {
+	return rc.zUpdateAndPrune(ctx, key, member, "", maxExpiredScore)
+}
+
+func (rc *RedisCacheImpl) zUpdateAndPrune(ctx context.Context, key string, member string, score string, maxExpiredScore float64) ([]string, []string, error) {
+	result, err := zUpdateAndPrune.Run(ctx, rc.client, []string{key}, member, score, strconv.FormatFloat(maxExpiredScore, 'f', -1, 64)).Slice()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var members [2][]string
+	for i := range members {
+		values, _ := result[i].([]interface{})
+		for _, value := range values {
+			members[i] = append(members[i], value.(string))
+		}
+	}
+	return members[0], members[1], nil
 }
 
 var hgetIfKeyExists = redis.NewScript(`
@@ -279,6 +568,24 @@ func (rc *RedisCacheImpl) ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) er
 				OpType: RPUSH,
 				Cmd:    pipe.RPush(ctx, payload.Key, payload.Val),
 			})
+		case SADD:
+			payload := cmd.Payload.(RedisSaddPayload)
+			pipelineCmds = append(pipelineCmds, RedisPipelineCmd{
+				OpType: SADD,
+				Cmd:    pipe.SAdd(ctx, payload.Key, payload.Members...),
+			})
+		case SREM:
+			payload := cmd.Payload.(RedisSremPayload)
+			pipelineCmds = append(pipelineCmds, RedisPipelineCmd{
+				OpType: SREM,
+				Cmd:    pipe.SRem(ctx, payload.Key, payload.Members...),
+			})
+		case EXPIRE:
+			payload := cmd.Payload.(RedisExpirePayload)
+			pipelineCmds = append(pipelineCmds, RedisPipelineCmd{
+				OpType: EXPIRE,
+				Cmd:    pipe.Expire(ctx, payload.Key, payload.Ttl),
+			})
 		default:
 			return ErrRedisPipelineCmdNotFound
 		}
@@ -302,6 +609,14 @@ func (rc *RedisCacheImpl) ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) er
 			if err := executedCmd.Cmd.(*redis.IntCmd).Err(); err != nil {
 				return err
 			}
+		case SADD, SREM:
+			if err := executedCmd.Cmd.(*redis.IntCmd).Err(); err != nil {
+				return err
+			}
+		case EXPIRE:
+			if err := executedCmd.Cmd.(*redis.BoolCmd).Err(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,53 @@
+package infra
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestGetManySkipsMissingKeys(t *testing.T) {
+	rc, mr := newTestRedisCache(t)
+
+	mr.Set("user:1", `{"id":1}`)
+	mr.Set("user:3", `{"id":3}`)
+
+	values, err := rc.GetMany(context.Background(), []string{"user:1", "user:2", "user:3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"user:1": `{"id":1}`, "user:3": `{"id":3}`}
+	if !maps.Equal(values, expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+}
+
+func TestZPopByScore(t *testing.T) {
+	rc, mr := newTestRedisCache(t)
+	ctx := context.Background()
+
+	for score, member := range []string{"a", "b", "c", "d"} {
+		if err := rc.ZAdd(ctx, "schedule", float64(score), member); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	members, err := rc.ZPopByScore(ctx, "schedule", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"a", "b"}) {
+		t.Fatalf("expected the two lowest scored members, got %v", members)
+	}
+	members, err = rc.ZPopByScore(ctx, "schedule", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"c"}) {
+		t.Fatalf("expected the last member that is due, got %v", members)
+	}
+	if remaining, _ := mr.ZMembers("schedule"); !slices.Equal(remaining, []string{"d"}) {
+		t.Fatalf("expected only the member not due to remain, got %v", remaining)
+	}
+}
@@ -0,0 +1,432 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WaitListKeys name the keys of a wait list. Waiting members are also kept in buckets by interest, members
+// claimed to move to another wait list in the moving hash, and members who left while moving into this wait list
+// in the left set. The scripts derive those keys from List, so List must carry a hash tag that every key of the
+// wait list shares.
+type WaitListKeys struct {
+	List        string
+	Preferences string
+	Waits       string
+	// Legacy optionally names the wait list of older servers, whose members have no preferences and are scored
+	// in seconds. It must hash to the same slot as List.
+	Legacy string
+}
+
+func (keys WaitListKeys) matchKeys() []string {
+	matchKeys := []string{keys.List, keys.Preferences, keys.Waits}
+	if keys.Legacy != "" {
+		matchKeys = append(matchKeys, keys.Legacy)
+	}
+	return matchKeys
+}
+
+// MatchPreferences are the optional interest tags and language a waiting user wants to share with a peer
+type MatchPreferences struct {
+	Tags     []string `json:"tags"`
+	Language string   `json:"language"`
+}
+
+// waitingPreferences are kept for each waiting member, along with the members it must not be matched with
+// should it move to another wait list
+type waitingPreferences struct {
+	MatchPreferences
+	Excluded []string `json:"excluded,omitempty"`
+}
+
+// MatchRequest asks for a peer from a wait list, or a place on it when there is none
+type MatchRequest struct {
+	Member      string
+	Score       float64
+	Now         float64
+	Preferences MatchPreferences
+	// OpenScore is the score at or below which a waiting member accepts any peer
+	OpenScore float64
+	// Retry matches a member already waiting instead of adding a new one
+	Retry bool
+	// ScanLimit bounds how many of the longest waiting members of each bucket are considered, so that the
+	// script does not block Redis for long on a long wait list
+	ScanLimit int64
+	Excluded  []string
+	// Claim is set for a member moving in from another wait list under that claim. The member is neither matched
+	// nor added when it left while moving.
+	Claim string
+}
+
+// leftTTL is how long a member who left while moving is kept out of the wait list it was moving to
+const leftTTL = 10 * time.Minute
+
+// waiters keeps every member of a wait list in the sorted set of the list and in buckets by interest: one per
+// tag for members with tags, and the notags bucket for the others, of which the unconstrained ones are also in
+// the any bucket. Languages are kept apart so that checking one needs no decoding.
+const waiters = `
+local function decode(raw)
+  if not raw then
+    return {tags = {}, language = ""}
+  end
+  return cjson.decode(raw)
+end
+
+local function unconstrained(p)
+  return #p.tags == 0 and p.language == ""
+end
+
+local function bucketKeys(list, p)
+  local keys = {}
+  for _, tag in ipairs(p.tags) do
+    table.insert(keys, list .. ":tag:" .. tag)
+  end
+  if #p.tags == 0 then
+    table.insert(keys, list .. ":notags")
+    if p.language == "" then
+      table.insert(keys, list .. ":any")
+    end
+  end
+  return keys
+end
+
+local function addWaiter(list, preferences, member, score, raw, p)
+  redis.call("ZADD", list, score, member)
+  redis.call("HSET", preferences, member, raw)
+  if p.language ~= "" then
+    redis.call("HSET", list .. ":langs", member, p.language)
+  end
+  for _, key in ipairs(bucketKeys(list, p)) do
+    redis.call("ZADD", key, score, member)
+  end
+end
+
+-- removeWaiter returns the raw preferences of a member taken off the list, and false when it was not waiting
+local function removeWaiter(list, preferences, member)
+  if redis.call("ZREM", list, member) == 0 then
+    return false
+  end
+  local raw = redis.call("HGET", preferences, member)
+  for _, key in ipairs(bucketKeys(list, decode(raw))) do
+    redis.call("ZREM", key, member)
+  end
+  redis.call("HDEL", preferences, member)
+  redis.call("HDEL", list .. ":langs", member)
+  return raw or ""
+end
+`
+
+var matchOrWait = redis.NewScript(waiters + `
+local list = KEYS[1]
+local preferences = KEYS[2]
+local waits = KEYS[3]
+local legacy = KEYS[4]
+local member = ARGV[1]
+local score = ARGV[2]
+local rawPreferences = ARGV[3]
+local openScore = ARGV[4]
+local retry = ARGV[5] == "1"
+local now = tonumber(ARGV[6])
+local scanLimit = tonumber(ARGV[7])
+local claim = ARGV[8]
+local leftBefore = ARGV[9]
+local excluded = {}
+excluded[member] = true
+for i = 10, #ARGV do
+  excluded[ARGV[i]] = true
+end
+
+if claim ~= "" then
+  local left = list .. ":left"
+  redis.call("ZREMRANGEBYSCORE", left, "-inf", leftBefore)
+  if redis.call("ZREM", left, member .. ":" .. claim) == 1 then
+    return ""
+  end
+end
+
+local ownScore = redis.call("ZSCORE", list, member)
+if (retry and not ownScore) or (not retry and ownScore) then
+  return ""
+end
+
+local own = cjson.decode(rawPreferences)
+local ownOpen = unconstrained(own) or (ownScore and tonumber(ownScore) <= tonumber(openScore))
+
+local function speaksOwnLanguage(candidate)
+  if own.language == "" then
+    return true
+  end
+  local language = redis.call("HGET", list .. ":langs", candidate)
+  return not language or language == own.language
+end
+
+-- first returns the longest waiting member of a bucket that is not excluded and passes the check
+local function first(key, check)
+  local offset = 0
+  while offset < scanLimit do
+    local candidates = redis.call("ZRANGE", key, offset, math.min(offset + 100, scanLimit) - 1, "WITHSCORES")
+    if #candidates == 0 then
+      return nil
+    end
+    for i = 1, #candidates, 2 do
+      local candidate = candidates[i]
+      if not excluded[candidate] and (not check or check(candidate)) then
+        return candidate, tonumber(candidates[i + 1])
+      end
+    end
+    offset = offset + #candidates / 2
+  end
+  return nil
+end
+
+local best, bestScore, bestLegacy
+local function consider(candidate, candidateScore, fromLegacy)
+  if candidate and (not best or candidateScore < bestScore) then
+    best, bestScore, bestLegacy = candidate, candidateScore, fromLegacy
+  end
+end
+
+if #own.tags == 0 then
+  consider(first(list .. ":notags", speaksOwnLanguage))
+else
+  for _, tag in ipairs(own.tags) do
+    consider(first(list .. ":tag:" .. tag, speaksOwnLanguage))
+  end
+end
+
+if ownOpen then
+  -- unconstrained members accept any peer, and so do members who waited long enough
+  consider(first(list .. ":any"))
+  local candidates = redis.call("ZRANGEBYSCORE", list, "-inf", openScore, "WITHSCORES", "LIMIT", 0, scanLimit)
+  for i = 1, #candidates, 2 do
+    if not excluded[candidates[i]] then
+      consider(candidates[i], tonumber(candidates[i + 1]))
+      break
+    end
+  end
+  if legacy then
+    local candidate, candidateScore = first(legacy)
+    if candidate then
+      consider(candidate, candidateScore * 1000, true)
+    end
+  end
+end
+
+if best then
+  if bestLegacy then
+    redis.call("ZREM", legacy, best)
+  else
+    removeWaiter(list, preferences, best)
+  end
+  if retry then
+    removeWaiter(list, preferences, member)
+  end
+  -- members requeued at the front have no join time to measure their wait from
+  if bestScore > 0 then
+    redis.call("LPUSH", waits, now - bestScore)
+    redis.call("LTRIM", waits, 0, 99)
+  end
+  return best
+end
+
+if not retry then
+  addWaiter(list, preferences, member, score, rawPreferences, own)
+end
+return ""
+`)
+
+// MatchOrWait pairs the member with the longest waiting compatible member, or puts it on the wait list.
+// Members are compatible when they share a tag in a common language, or when both accept any peer. Only the
+// first ScanLimit members of each bucket the member may match in are considered. The legacy wait list, when
+// given, is drained by members who accept any peer. The last 100 waits of matched members are kept in a list.
+func (rc *RedisCacheImpl) MatchOrWait(ctx context.Context, keys WaitListKeys, request *MatchRequest) (bool, string, error) {
+	preferences := waitingPreferences{
+		MatchPreferences: request.Preferences,
+		Excluded:         request.Excluded,
+	}
+	if preferences.Tags == nil {
+		preferences.Tags = []string{}
+	}
+	rawPreferences, err := json.Marshal(preferences)
+	if err != nil {
+		return false, "", err
+	}
+
+	retry := "0"
+	if request.Retry {
+		retry = "1"
+	}
+	leftBefore := request.Now - float64(leftTTL.Milliseconds())
+	args := []interface{}{request.Member, request.Score, rawPreferences, request.OpenScore, retry, request.Now, request.ScanLimit, request.Claim, leftBefore}
+	for _, excludedMember := range request.Excluded {
+		args = append(args, excludedMember)
+	}
+
+	peer, err := matchOrWait.Run(ctx, rc.client, keys.matchKeys(), args...).Text()
+	if err != nil {
+		return false, "", err
+	}
+	return (peer != ""), peer, nil
+}
+
+var leaveWaitList = redis.NewScript(waiters + `
+if removeWaiter(KEYS[1], KEYS[2], ARGV[1]) then
+  return {1, ""}
+end
+local moving = KEYS[1] .. ":moving"
+local raw = redis.call("HGET", moving, ARGV[1])
+if raw then
+  redis.call("HDEL", moving, ARGV[1])
+  return {1, cjson.decode(raw).id}
+end
+return {0, ""}
+`)
+
+// LeaveWaitList takes the member off the wait list and its buckets, and reports whether it was waiting. A member
+// claimed to move to another wait list also counts as waiting, its claim is returned so that the member can be
+// kept out of the other wait list with MarkLeft.
+func (rc *RedisCacheImpl) LeaveWaitList(ctx context.Context, keys WaitListKeys, member string) (bool, string, error) {
+	result, err := leaveWaitList.Run(ctx, rc.client, []string{keys.List, keys.Preferences}, member).Slice()
+	if err != nil {
+		return false, "", err
+	}
+	if len(result) != 2 {
+		return false, "", fmt.Errorf("error unexpected leave wait list reply %v", result)
+	}
+	left, _ := result[0].(int64)
+	claim, _ := result[1].(string)
+	return left == 1, claim, nil
+}
+
+// MarkLeft keeps a member who left while moving under claim out of the wait list it was moving to
+func (rc *RedisCacheImpl) MarkLeft(ctx context.Context, keys WaitListKeys, member string, claim string, now float64) error {
+	return rc.client.ZAdd(ctx, keys.List+":left", redis.Z{Score: now, Member: member + ":" + claim}).Err()
+}
+
+// WaitingMember is a member claimed to move off a wait list together with what it needs to wait elsewhere
+type WaitingMember struct {
+	Member      string
+	Score       float64
+	Preferences MatchPreferences
+	Excluded    []string
+	// Claim identifies the move, it settles the member with SettleWaiting
+	Claim string
+}
+
+// ClaimRequest asks for members to move off a wait list
+type ClaimRequest struct {
+	// MaxScore is the score up to which waiting members are claimed
+	MaxScore float64
+	Now      float64
+	// StaleBefore is the time before which a claim that was never settled is taken over. Claims are named after the
+	// time they were taken, so Now must differ between claims of the same wait list.
+	StaleBefore float64
+	Limit       int64
+}
+
+var claimWaiting = redis.NewScript(waiters + `
+local list = KEYS[1]
+local preferences = KEYS[2]
+local moving = list .. ":moving"
+local maxScore = ARGV[1]
+local now = ARGV[2]
+local staleBefore = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+
+local result = {}
+local function claimed(member, score, raw, id)
+  table.insert(result, member)
+  table.insert(result, score)
+  table.insert(result, raw)
+  table.insert(result, id)
+end
+
+-- members whose move stopped halfway are claimed again first, under a new claim so that the stopped move can
+-- tell it was taken over
+local claims = redis.call("HGETALL", moving)
+for i = 1, #claims, 2 do
+  if #result / 4 >= limit then
+    break
+  end
+  local claim = cjson.decode(claims[i + 1])
+  if tonumber(claim.id) <= staleBefore then
+    claim.id = now
+    redis.call("HSET", moving, claims[i], cjson.encode(claim))
+    claimed(claims[i], claim.score, claim.preferences, claim.id)
+  end
+end
+
+local remaining = limit - #result / 4
+if remaining <= 0 then
+  return result
+end
+local members = redis.call("ZRANGEBYSCORE", list, "-inf", maxScore, "WITHSCORES", "LIMIT", 0, remaining)
+for i = 1, #members, 2 do
+  local member = members[i]
+  local raw = removeWaiter(list, preferences, member)
+  local claim = {id = now, score = members[i + 1], preferences = raw}
+  redis.call("HSET", moving, member, cjson.encode(claim))
+  claimed(member, claim.score, raw, claim.id)
+end
+return result
+`)
+
+// ClaimWaiting atomically moves at most Limit members with a score up to MaxScore off a wait list into its
+// moving hash, together with members whose claim went stale. A claimed member can no longer be matched on the
+// list, but still counts as waiting for LeaveWaitList until SettleWaiting settles it.
+func (rc *RedisCacheImpl) ClaimWaiting(ctx context.Context, keys WaitListKeys, request *ClaimRequest) ([]*WaitingMember, error) {
+	result, err := claimWaiting.Run(ctx, rc.client, []string{keys.List, keys.Preferences}, request.MaxScore, request.Now, request.StaleBefore, request.Limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*WaitingMember, 0, len(result)/4)
+	for i := 0; i+3 < len(result); i += 4 {
+		score, err := strconv.ParseFloat(result[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		member := &WaitingMember{
+			Member: result[i],
+			Score:  score,
+			Claim:  result[i+3],
+		}
+		if result[i+2] != "" {
+			var preferences waitingPreferences
+			if err := json.Unmarshal([]byte(result[i+2]), &preferences); err != nil {
+				return nil, err
+			}
+			member.Preferences = preferences.MatchPreferences
+			member.Excluded = preferences.Excluded
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+var settleWaiting = redis.NewScript(`
+local moving = KEYS[1] .. ":moving"
+local raw = redis.call("HGET", moving, ARGV[1])
+if not raw then
+  return 1
+end
+if cjson.decode(raw).id == ARGV[2] then
+  redis.call("HDEL", moving, ARGV[1])
+end
+return 0
+`)
+
+// SettleWaiting ends the claim of a member that moved off the wait list, and reports whether the member left the
+// wait list while moving. A claim taken over since is left for its new owner to settle.
+func (rc *RedisCacheImpl) SettleWaiting(ctx context.Context, keys WaitListKeys, member string, claim string) (bool, error) {
+	left, err := settleWaiting.Run(ctx, rc.client, []string{keys.List}, member, claim).Int()
+	if err != nil {
+		return false, err
+	}
+	return left == 1, nil
+}
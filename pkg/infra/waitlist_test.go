@@ -0,0 +1,240 @@
+package infra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/thyyl/chatr/pkg/infra/infratest"
+)
+
+const testOpenScore = 1000
+
+var testWaitListKeys = WaitListKeys{
+	List:        "{waitlist}",
+	Preferences: "{waitlist}:prefs",
+	Waits:       "{waitlist}:waits",
+	Legacy:      "waitlist",
+}
+
+func newTestRedisCache(t *testing.T) (*RedisCacheImpl, *miniredis.Miniredis) {
+	t.Helper()
+
+	client, mr := infratest.NewRedisClient(t)
+	return NewRedisCacheImpl(client), mr
+}
+
+func matchOrWaitAt(t *testing.T, rc *RedisCacheImpl, member string, score float64, preferences MatchPreferences, excluded ...string) string {
+	t.Helper()
+
+	_, peer, err := rc.MatchOrWait(context.Background(), testWaitListKeys, &MatchRequest{
+		Member:      member,
+		Score:       score,
+		Now:         score,
+		Preferences: preferences,
+		OpenScore:   testOpenScore,
+		ScanLimit:   10,
+		Excluded:    excluded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func TestMatchOrWaitByInterest(t *testing.T) {
+	rc, _ := newTestRedisCache(t)
+
+	if peer := matchOrWaitAt(t, rc, "1", 2000, MatchPreferences{Tags: []string{"go"}, Language: "en"}); peer != "" {
+		t.Fatalf("expected the first user to wait, got peer %s", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "2", 2001, MatchPreferences{Tags: []string{"rust"}, Language: "en"}); peer != "" {
+		t.Fatalf("expected a user without a shared tag to wait, got peer %s", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "3", 2002, MatchPreferences{Tags: []string{"go"}, Language: "fr"}); peer != "" {
+		t.Fatalf("expected a user of another language to wait, got peer %s", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "4", 2003, MatchPreferences{Tags: []string{"go", "rust"}}, "1"); peer != "2" {
+		t.Fatalf("expected the longest waiting compatible user who is not blocked, got peer %q", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "5", 2004, MatchPreferences{Tags: []string{"go"}}); peer != "1" {
+		t.Fatalf("expected user 1, got peer %q", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "6", 2005, MatchPreferences{Tags: []string{"go"}}); peer != "3" {
+		t.Fatalf("expected user 3, got peer %q", peer)
+	}
+}
+
+func TestMatchOrWaitOpenMembers(t *testing.T) {
+	rc, _ := newTestRedisCache(t)
+
+	if peer := matchOrWaitAt(t, rc, "1", 500, MatchPreferences{Tags: []string{"go"}}); peer != "" {
+		t.Fatalf("expected the first user to wait, got peer %s", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "2", 2000, MatchPreferences{Tags: []string{"rust"}}); peer != "" {
+		t.Fatalf("expected a constrained user to wait, got peer %s", peer)
+	}
+	// an unconstrained user accepts any peer, and user 1 has waited long enough to accept any peer too
+	if peer := matchOrWaitAt(t, rc, "3", 2001, MatchPreferences{}); peer != "1" {
+		t.Fatalf("expected user 1, got peer %q", peer)
+	}
+}
+
+func TestLeaveWaitListClearsBuckets(t *testing.T) {
+	rc, mr := newTestRedisCache(t)
+	ctx := context.Background()
+
+	matchOrWaitAt(t, rc, "1", 2000, MatchPreferences{Tags: []string{"go", "rust"}, Language: "en"})
+	left, _, err := rc.LeaveWaitList(ctx, testWaitListKeys, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !left {
+		t.Fatal("expected the user to be waiting")
+	}
+	left, _, err = rc.LeaveWaitList(ctx, testWaitListKeys, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left {
+		t.Fatal("expected the user to be gone")
+	}
+
+	for _, key := range []string{testWaitListKeys.List, testWaitListKeys.Preferences, "{waitlist}:tag:go", "{waitlist}:tag:rust", "{waitlist}:langs"} {
+		if mr.Exists(key) {
+			t.Errorf("expected key %s to be empty", key)
+		}
+	}
+}
+
+func TestMatchOrWaitDrainsLegacyWaitList(t *testing.T) {
+	rc, mr := newTestRedisCache(t)
+
+	// waiters of older servers are scored in seconds
+	if _, err := mr.ZAdd(testWaitListKeys.Legacy, 3, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if peer := matchOrWaitAt(t, rc, "2", 2000, MatchPreferences{Tags: []string{"go"}}); peer != "" {
+		t.Fatalf("expected a constrained user to wait, got peer %s", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "3", 2500, MatchPreferences{Tags: []string{"go"}}); peer != "2" {
+		t.Fatalf("expected the user who joined first, got peer %q", peer)
+	}
+	if peer := matchOrWaitAt(t, rc, "4", 5000, MatchPreferences{}); peer != "1" {
+		t.Fatalf("expected the legacy waiter, got peer %q", peer)
+	}
+	if mr.Exists(testWaitListKeys.Legacy) {
+		t.Fatal("expected the legacy waiter to be gone")
+	}
+}
+
+func claimAt(t *testing.T, rc *RedisCacheImpl, keys WaitListKeys, maxScore float64, now float64, staleBefore float64) []*WaitingMember {
+	t.Helper()
+
+	members, err := rc.ClaimWaiting(context.Background(), keys, &ClaimRequest{
+		MaxScore:    maxScore,
+		Now:         now,
+		StaleBefore: staleBefore,
+		Limit:       10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return members
+}
+
+func TestClaimWaiting(t *testing.T) {
+	rc, mr := newTestRedisCache(t)
+	ctx := context.Background()
+
+	matchOrWaitAt(t, rc, "1", 2000, MatchPreferences{Tags: []string{"go"}, Language: "en"}, "9")
+	matchOrWaitAt(t, rc, "2", 3000, MatchPreferences{Tags: []string{"rust"}})
+
+	members := claimAt(t, rc, testWaitListKeys, 2500, 4000, 0)
+	if len(members) != 1 || members[0].Member != "1" || members[0].Score != 2000 || members[0].Claim == "" {
+		t.Fatalf("expected user 1 to be claimed, got %+v", members)
+	}
+	if members[0].Preferences.Language != "en" || len(members[0].Excluded) != 1 || members[0].Excluded[0] != "9" {
+		t.Fatalf("expected the preferences of user 1, got %+v", members[0])
+	}
+	if mr.Exists("{waitlist}:tag:go") {
+		t.Fatal("expected the claimed user to leave its buckets")
+	}
+
+	// a claim that is not settled in time is taken over with the same preferences
+	if members := claimAt(t, rc, testWaitListKeys, 2500, 5000, 3999); len(members) != 0 {
+		t.Fatalf("expected a fresh claim to be left alone, got %+v", members)
+	}
+	reclaimed := claimAt(t, rc, testWaitListKeys, 2500, 5000, 4000)
+	if len(reclaimed) != 1 || reclaimed[0].Claim == members[0].Claim || reclaimed[0].Preferences.Language != "en" {
+		t.Fatalf("expected a new claim of user 1, got %+v", reclaimed)
+	}
+
+	// the stopped move leaves the claim to its new owner
+	for _, claim := range []string{members[0].Claim, reclaimed[0].Claim} {
+		left, err := rc.SettleWaiting(ctx, testWaitListKeys, "1", claim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left {
+			t.Fatalf("expected claim %s to settle a moving user", claim)
+		}
+	}
+	if mr.Exists("{waitlist}:moving") {
+		t.Fatal("expected the settled user to stop moving")
+	}
+}
+
+func TestLeaveWhileMoving(t *testing.T) {
+	rc, _ := newTestRedisCache(t)
+	ctx := context.Background()
+	target := WaitListKeys{
+		List:        "{target}",
+		Preferences: "{target}:prefs",
+		Waits:       "{target}:waits",
+	}
+
+	matchOrWaitAt(t, rc, "1", 2000, MatchPreferences{})
+	members := claimAt(t, rc, testWaitListKeys, 2500, 4000, 0)
+	if len(members) != 1 {
+		t.Fatalf("expected user 1 to be claimed, got %+v", members)
+	}
+
+	left, claim, err := rc.LeaveWaitList(ctx, testWaitListKeys, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !left || claim != members[0].Claim {
+		t.Fatalf("expected the moving user to leave with its claim, got %v %q", left, claim)
+	}
+	if err := rc.MarkLeft(ctx, target, "1", claim, 4000); err != nil {
+		t.Fatal(err)
+	}
+
+	// a user waiting in the target must not be matched with the user who left
+	if _, _, err := rc.MatchOrWait(ctx, target, &MatchRequest{Member: "2", Score: 4000, Now: 4000, ScanLimit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	matched, _, err := rc.MatchOrWait(ctx, target, &MatchRequest{Member: "1", Score: 2000, Now: 4001, ScanLimit: 10, Claim: claim})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched {
+		t.Fatal("expected the user who left to stay out of the target")
+	}
+	_, waiting, err := rc.ZRank(ctx, target.List, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiting {
+		t.Fatal("expected the user who left not to wait in the target")
+	}
+
+	left, err = rc.SettleWaiting(ctx, testWaitListKeys, "1", claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !left {
+		t.Fatal("expected the user to have left while moving")
+	}
+}
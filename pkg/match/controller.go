@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thyyl/chatr/pkg/common"
@@ -29,6 +30,18 @@ func (s *HttpServer) Match(ctx *gin.Context) {
 		return
 	}
 
+	var request MatchRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+	preferences, err := parseMatchPreferences(&request, s.maxTags)
+	if err != nil {
+		common.Response(ctx, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+	ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), common.MatchPreferenceKey, preferences))
+
 	if err := s.melodyMatch.HandleRequest(ctx.Writer, ctx.Request); err != nil {
 		s.logger.Error("upgrade websocket error" + err.Error())
 		common.Response(ctx, http.StatusInternalServerError, common.ErrorServer)
@@ -43,20 +56,102 @@ func (s *HttpServer) HandleMatchOnConnect(session *melody.Session) {
 		return
 	}
 
+	preferences := sessionPreferences(session)
+
 	err := s.initializeMatchSession(session, userId)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return
 	}
 
+	// the waiter is in place before matching so that a proposal falling through can requeue the user
+	w := newWaiter()
+	session.Set(common.SessionWaiterKey, w)
+
+	ctx := context.Background()
+	matchResult, err := s.matchService.Match(ctx, userId, preferences)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	if matchResult.Matched {
+		s.publishMatchResult(ctx, matchResult)
+		return
+	}
+
+	// matching only runs when someone joins, so the waiter keeps the user informed and retries on its own
+	go s.wait(session, w, userId, preferences)
+	s.publishMatchResult(ctx, matchResult)
+}
+
+func (s *HttpServer) HandleMatchOnMessage(session *melody.Session, data []byte) {
+	userId, ok := session.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		return
+	}
+
+	response, err := DecodeToProposalResponseDto(data)
+	if err != nil || response.ProposalId == "" {
+		return
+	}
+
+	var accept bool
+	switch response.Event {
+	case EventAccept:
+		accept = true
+	case EventDecline:
+		accept = false
+	default:
+		return
+	}
+
+	ctx := context.Background()
+	matchResult, err := s.matchService.RespondProposal(ctx, response.ProposalId, userId, accept)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	s.publishMatchResult(ctx, matchResult)
+}
+
+func (s *HttpServer) rematch(userId uint64, preferences *MatchPreferences) {
 	ctx := context.Background()
-	matchResult, err := s.matchService.Match(ctx, userId)
+	matchResult, err := s.matchService.Rematch(ctx, userId, preferences)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return
 	}
+	s.publishMatchResult(ctx, matchResult)
+}
+
+func (s *HttpServer) requeue(userId uint64, preferences *MatchPreferences) {
+	ctx := context.Background()
+	matchResult, err := s.matchService.Requeue(ctx, userId, preferences)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	s.publishMatchResult(ctx, matchResult)
+}
+
+// rebalanceWaitList matches users left over in their wait list shard. Every replica rebalances, claiming a
+// leftover is atomic so that no user is matched twice.
+func (s *HttpServer) rebalanceWaitList() {
+	ctx := context.Background()
+	matchResults, err := s.matchService.RebalanceWaitList(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	for _, matchResult := range matchResults {
+		s.publishMatchResult(ctx, matchResult)
+	}
+}
 
-	if !matchResult.Matched {
+// publishMatchResult tells the users about the result. A proposal is cancelled once its timeout passes
+// without both users accepting.
+func (s *HttpServer) publishMatchResult(ctx context.Context, matchResult *MatchResult) {
+	if !matchResult.IsPublishable() {
 		return
 	}
 
@@ -64,6 +159,19 @@ func (s *HttpServer) HandleMatchOnConnect(session *melody.Session) {
 		s.logger.Error(err.Error())
 		return
 	}
+
+	if matchResult.Proposal != nil {
+		proposalId := matchResult.Proposal.Id
+		time.AfterFunc(s.confirmTimeout, func() {
+			ctx := context.Background()
+			expiredResult, err := s.matchService.ExpireProposal(ctx, proposalId)
+			if err != nil {
+				s.logger.Error(err.Error())
+				return
+			}
+			s.publishMatchResult(ctx, expiredResult)
+		})
+	}
 }
 
 func (s *HttpServer) initializeMatchSession(session *melody.Session, userId uint64) error {
@@ -77,5 +185,18 @@ func (s *HttpServer) HandleClose(session *melody.Session, i int, str string) err
 		return nil
 	}
 
-	return s.matchService.RemoveUserFromWaitList(session.Request.Context(), userId)
+	if w, exist := session.Get(common.SessionWaiterKey); exist {
+		w.(*waiter).stop()
+	}
+
+	_, err := s.matchService.RemoveUserFromWaitList(session.Request.Context(), userId, sessionPreferences(session))
+	return err
+}
+
+func sessionPreferences(session *melody.Session) *MatchPreferences {
+	preferences, ok := session.Request.Context().Value(common.MatchPreferenceKey).(*MatchPreferences)
+	if !ok {
+		return &MatchPreferences{}
+	}
+	return preferences
 }
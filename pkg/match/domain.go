@@ -1,20 +1,94 @@
 package match
 
+import "time"
+
+// events of the frames sent over the match websocket
+const (
+	EventMatched     = "matched"
+	EventQueueStatus = "status"
+	EventNoMatch     = "nomatch"
+	EventProposed    = "proposed"
+	EventCancelled   = "cancelled"
+	EventAccept      = "accept"
+	EventDecline     = "decline"
+)
+
 type User struct {
 	Id   uint64
 	Name string
 }
 
+// MatchPreferences are the interest tags and language a user opts into when asking for a match.
+// Users without any are matched with whoever has waited longest.
+type MatchPreferences struct {
+	Tags     []string
+	Language string
+	// Region only routes the user to a wait list shard, peers from other regions are still possible
+	Region string
+}
+
+func (p *MatchPreferences) IsEmpty() bool {
+	return len(p.Tags) == 0 && p.Language == ""
+}
+
+// RoutingKey decides the wait list shard of the user
+func (p *MatchPreferences) RoutingKey() string {
+	return p.Region + ":" + p.Language
+}
+
+// Leftover is a user claimed in a wait list shard to be matched across shards
+type Leftover struct {
+	UserId      uint64
+	JoinedAt    time.Time
+	Preferences *MatchPreferences
+	// BlockedIds are the blocked users as of when the user joined
+	BlockedIds []uint64
+	// shard and claim tell where the user was claimed, until the user is settled in the overflow shard
+	shard waitListShard
+	claim string
+}
+
 type MatchResult struct {
 	Matched     bool
 	UserId      uint64
 	PeerId      uint64
 	ChannelId   uint64
 	AccessToken string
+	// Proposal is set instead of a channel while the pair still has to accept the match
+	Proposal *Proposal
+	// Cancellation is set when a proposal was declined or not accepted in time
+	Cancellation *Cancellation
+}
+
+// IsPublishable reports whether the users of the result have to be told about it
+func (r *MatchResult) IsPublishable() bool {
+	return r.Matched || r.Proposal != nil || r.Cancellation != nil
+}
+
+type Proposal struct {
+	Id        string
+	UserName  string
+	PeerName  string
+	ExpiresAt int64
+}
+
+type Cancellation struct {
+	ProposalId string
+	// Requeued are the users who accepted and go back to the front of the wait list
+	Requeued []uint64
 }
 
 func (r *MatchResult) ToDto() *MatchResultDto {
 	return &MatchResultDto{
+		Event:       EventMatched,
 		AccessToken: r.AccessToken,
 	}
 }
+
+// QueueStatus is where a user stands on the wait list
+type QueueStatus struct {
+	// Position is 1 for the user who has waited longest
+	Position int64
+	// AverageWait is how long recently matched users waited, 0 when nobody was matched yet
+	AverageWait time.Duration
+}
@@ -2,7 +2,14 @@ package match
 
 import "encoding/json"
 
+type MatchRequest struct {
+	Tags     string `form:"tags"`
+	Language string `form:"lang" binding:"max=16"`
+	Region   string `form:"region" binding:"max=32"`
+}
+
 type MatchResultDto struct {
+	Event       string `json:"event"`
 	AccessToken string `json:"accessToken"`
 }
 
@@ -10,3 +17,59 @@ func (m *MatchResultDto) Encode() []byte {
 	result, _ := json.Marshal(m)
 	return result
 }
+
+type QueueStatusDto struct {
+	Event               string `json:"event"`
+	Position            int64  `json:"position"`
+	ElapsedSecond       int64  `json:"elapsedSecond"`
+	EstimatedWaitSecond *int64 `json:"estimatedWaitSecond,omitempty"`
+}
+
+func (m *QueueStatusDto) Encode() []byte {
+	result, _ := json.Marshal(m)
+	return result
+}
+
+type NoMatchDto struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+func (m *NoMatchDto) Encode() []byte {
+	result, _ := json.Marshal(m)
+	return result
+}
+
+type PeerDto struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ProposalDto struct {
+	Event      string  `json:"event"`
+	ProposalId string  `json:"proposalId"`
+	Peer       PeerDto `json:"peer"`
+	ExpiresAt  int64   `json:"expiresAt"`
+}
+
+func (m *ProposalDto) Encode() []byte {
+	result, _ := json.Marshal(m)
+	return result
+}
+
+type CancellationDto struct {
+	Event      string `json:"event"`
+	ProposalId string `json:"proposalId"`
+	Requeued   bool   `json:"requeued"`
+}
+
+func (m *CancellationDto) Encode() []byte {
+	result, _ := json.Marshal(m)
+	return result
+}
+
+// ProposalResponseDto is the answer of a user to a match proposal, either accept or decline
+type ProposalResponseDto struct {
+	Event      string `json:"event"`
+	ProposalId string `json:"proposalId"`
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thyyl/chatr/pkg/common"
@@ -31,6 +32,14 @@ type HttpServer struct {
 	userService     UserService
 	matchService    MatchService
 	serveSwag       bool
+	fallback        time.Duration
+	maxTags         int
+	statusInterval  time.Duration
+	maxWait         time.Duration
+	confirmTimeout  time.Duration
+	// rebalanceInterval is zero when the wait list is not sharded
+	rebalanceInterval time.Duration
+	done              chan struct{}
 }
 
 func NewMelodyMatchConn() MelodyMatchConn {
@@ -51,7 +60,7 @@ func NewGinServer(name string, logger common.HttpLog, config *config.Config) *gi
 }
 
 func NewHttpServer(name string, logger common.HttpLog, config *config.Config, server *gin.Engine, melodyMatch MelodyMatchConn, matchSubscriber *MatchSubscriber, userService UserService, matchService MatchService) *HttpServer {
-	return &HttpServer{
+	httpServer := &HttpServer{
 		name:            name,
 		logger:          logger,
 		server:          server,
@@ -61,7 +70,17 @@ func NewHttpServer(name string, logger common.HttpLog, config *config.Config, se
 		userService:     userService,
 		matchService:    matchService,
 		serveSwag:       config.Match.Http.Server.Swag,
+		fallback:        time.Duration(config.Match.Matching.FallbackSecond) * time.Second,
+		maxTags:         config.Match.Matching.MaxTags,
+		statusInterval:  time.Duration(config.Match.Matching.StatusIntervalSecond) * time.Second,
+		maxWait:         time.Duration(config.Match.Matching.MaxWaitSecond) * time.Second,
+		confirmTimeout:  time.Duration(config.Match.Matching.ConfirmTimeoutSecond) * time.Second,
+		done:            make(chan struct{}),
 	}
+	if config.Match.Sharding.Shards > 1 {
+		httpServer.rebalanceInterval = time.Duration(config.Match.Sharding.RebalanceIntervalSecond) * time.Second
+	}
+	return httpServer
 }
 
 func (s *HttpServer) CookieAuth() gin.HandlerFunc {
@@ -92,6 +111,7 @@ func (s *HttpServer) RegisterRoutes() {
 	}
 
 	s.melodyMatch.HandleConnect(s.HandleMatchOnConnect)
+	s.melodyMatch.HandleMessage(s.HandleMatchOnMessage)
 	s.melodyMatch.HandleClose(s.HandleClose)
 }
 
@@ -118,9 +138,26 @@ func (s *HttpServer) Run() {
 			os.Exit(1)
 		}
 	}()
+
+	if s.rebalanceInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(s.rebalanceInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-ticker.C:
+					s.rebalanceWaitList()
+				}
+			}
+		}()
+	}
 }
 
 func (s *HttpServer) GracefulStop(ctx context.Context) error {
+	close(s.done)
+
 	err := MelodyMatch.Close()
 	if err != nil {
 		return err
@@ -2,7 +2,8 @@ package match
 
 import (
 	"context"
-	"log/slog"
+	"slices"
+	"strconv"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/thyyl/chatr/pkg/common"
@@ -12,15 +13,13 @@ import (
 type MatchSubscriber struct {
 	melodyMatch MelodyMatchConn
 	router      *message.Router
-	userService UserService
 	subscriber  message.Subscriber
 }
 
-func NewMatchSubscriber(name string, melodyMatch MelodyMatchConn, router *message.Router, userService UserService, subscriber message.Subscriber) *MatchSubscriber {
+func NewMatchSubscriber(name string, melodyMatch MelodyMatchConn, router *message.Router, subscriber message.Subscriber) *MatchSubscriber {
 	return &MatchSubscriber{
 		melodyMatch: melodyMatch,
 		router:      router,
-		userService: userService,
 		subscriber:  subscriber,
 	}
 }
@@ -48,10 +47,92 @@ func (s *MatchSubscriber) HandleMatchResult(message *message.Message) error {
 		return err
 	}
 
-	ctx := context.Background()
-	return s.sendMatchResult(ctx, result)
+	switch {
+	case result.Proposal != nil:
+		return s.sendProposal(result)
+	case result.Cancellation != nil:
+		return s.sendCancellation(result)
+	default:
+		return s.sendMatchResult(result)
+	}
+}
+
+// sendProposal shows each user of the proposal who they were matched with
+func (s *MatchSubscriber) sendProposal(result *MatchResult) error {
+	proposal := result.Proposal
+	peers := map[uint64]PeerDto{
+		result.UserId: {Id: strconv.FormatUint(result.PeerId, 10), Name: proposal.PeerName},
+		result.PeerId: {Id: strconv.FormatUint(result.UserId, 10), Name: proposal.UserName},
+	}
+
+	for userId, peer := range peers {
+		proposalDto := &ProposalDto{
+			Event:      EventProposed,
+			ProposalId: proposal.Id,
+			Peer:       peer,
+			ExpiresAt:  proposal.ExpiresAt,
+		}
+		if err := s.melodyMatch.BroadcastFilter(proposalDto.Encode(), func(session *melody.Session) bool {
+			sessionUserId, exist := session.Get(common.SessionUidKey)
+			return exist && sessionUserId.(uint64) == userId
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
-func (s *MatchSubscriber) sendMatchResult(ctx context.Context, result *MatchResult) error {
+
+// sendCancellation tells both users the proposal fell through. Users who accepted go back to the wait list,
+// the others are done waiting and disconnected.
+func (s *MatchSubscriber) sendCancellation(result *MatchResult) error {
+	cancellation := result.Cancellation
+	for _, userId := range []uint64{result.UserId, result.PeerId} {
+		requeued := slices.Contains(cancellation.Requeued, userId)
+		cancellationDto := &CancellationDto{
+			Event:      EventCancelled,
+			ProposalId: cancellation.ProposalId,
+			Requeued:   requeued,
+		}
+		if err := s.melodyMatch.BroadcastFilter(cancellationDto.Encode(), func(session *melody.Session) bool {
+			sessionUserId, exist := session.Get(common.SessionUidKey)
+			if !exist || sessionUserId.(uint64) != userId {
+				return false
+			}
+			if w, exist := session.Get(common.SessionWaiterKey); exist {
+				if requeued {
+					w.(*waiter).requeue()
+				} else {
+					w.(*waiter).stop()
+				}
+			}
+			return true
+		}); err != nil {
+			return err
+		}
+		if !requeued {
+			if err := s.closeUserSessions(userId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// closeUserSessions closes the sessions of the user. The hub handles broadcasts in order, so the close is
+// queued behind the frame broadcast right before it.
+func (s *MatchSubscriber) closeUserSessions(userId uint64) error {
+	return s.melodyMatch.BroadcastFilter(nil, func(session *melody.Session) bool {
+		sessionUserId, exist := session.Get(common.SessionUidKey)
+		if exist && sessionUserId.(uint64) == userId {
+			session.Close()
+		}
+		return false
+	})
+}
+
+// sendMatchResult tells the sessions of both users about the match, the match service already added them to the
+// channel
+func (s *MatchSubscriber) sendMatchResult(result *MatchResult) error {
 	return s.melodyMatch.BroadcastFilter(result.ToDto().Encode(), func(session *melody.Session) bool {
 		sessionUserId, exist := session.Get(common.SessionUidKey)
 		if !exist {
@@ -60,9 +141,8 @@ func (s *MatchSubscriber) sendMatchResult(ctx context.Context, result *MatchResu
 
 		userID := sessionUserId.(uint64)
 		if (userID == result.PeerId) || (userID == result.UserId) {
-			if err := s.userService.AddUserToChannel(ctx, result.ChannelId, userID); err != nil {
-				slog.Error(err.Error())
-				return false
+			if w, exist := session.Get(common.SessionWaiterKey); exist {
+				w.(*waiter).stop()
 			}
 			return true
 		}
@@ -9,6 +9,7 @@ import (
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 	"github.com/thyyl/chatr/pkg/infra"
 	"github.com/thyyl/chatr/pkg/transport"
 	chatProto "github.com/thyyl/chatr/proto/chat"
@@ -26,12 +27,23 @@ type UserRepo interface {
 	GetUserById(ctx context.Context, userId uint64) (*User, error)
 	GetUserIdBySession(ctx context.Context, session string) (uint64, error)
 	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error
+	GetBlockedUserIds(ctx context.Context, userId uint64) ([]uint64, error)
 }
 
 type MatchRepo interface {
-	PopOrPushWaitList(ctx context.Context, userId uint64) (bool, uint64, error)
+	PopOrPushWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error)
+	RetryWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error)
+	RequeueWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error)
+	OpenProposal(ctx context.Context, proposalId string, userIds []uint64, ttl time.Duration) error
+	RespondProposal(ctx context.Context, proposalId string, userId uint64, accept bool) (infra.BallotStatus, map[uint64]bool, error)
+	CloseProposal(ctx context.Context, proposalId string) (infra.BallotStatus, map[uint64]bool, error)
+	TakeLeftovers(ctx context.Context, joinedBefore time.Time, claimedBefore time.Time, limit int64) ([]*Leftover, error)
+	MergeWaitList(ctx context.Context, leftover *Leftover) (bool, uint64, error)
 	PublishMatchResult(ctx context.Context, result *MatchResult) error
-	RemoveFromWaitList(ctx context.Context, userId uint64) error
+	PublishNotification(ctx context.Context, notification *common.Notification) error
+	RemoveFromWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences) (bool, error)
+	GetWaitListRank(ctx context.Context, userId uint64, preferences *MatchPreferences) (int64, bool, error)
+	GetRecentWaits(ctx context.Context, preferences *MatchPreferences) ([]int64, error)
 }
 
 // ============================
@@ -57,6 +69,7 @@ type UserRepoImpl struct {
 	getUserById        endpoint.Endpoint
 	getUserIdBySession endpoint.Endpoint
 	addUserToChannel   endpoint.Endpoint
+	getBlockedUsers    endpoint.Endpoint
 }
 
 func NewUserRepoImpl(userConn *UserClientConn, chatConn *ChatClientConn) *UserRepoImpl {
@@ -82,16 +95,32 @@ func NewUserRepoImpl(userConn *UserClientConn, chatConn *ChatClientConn) *UserRe
 			"AddUserToChannel",
 			&chatProto.AddUserResponse{},
 		),
+		getBlockedUsers: transport.NewGrpcEndpoint(
+			userConn.Conn,
+			"user",
+			"user.UserService",
+			"GetBlockedUsers",
+			&userProto.GetBlockedUsersResponse{},
+		),
 	}
 }
 
 type MatchRepoImpl struct {
 	redis     infra.RedisCache
 	publisher message.Publisher
+	fallback  time.Duration
+	scanLimit int64
+	shards    *waitListShards
 }
 
-func NewMatchRepoImpl(redis infra.RedisCache, publisher message.Publisher) *MatchRepoImpl {
-	return &MatchRepoImpl{redis, publisher}
+func NewMatchRepoImpl(redis infra.RedisCache, publisher message.Publisher, config *config.Config) *MatchRepoImpl {
+	return &MatchRepoImpl{
+		redis:     redis,
+		publisher: publisher,
+		fallback:  time.Duration(config.Match.Matching.FallbackSecond) * time.Second,
+		scanLimit: config.Match.Matching.ScanLimit,
+		shards:    newWaitListShards(common.UserWaitListRcKey, config.Match.Sharding.Shards, config.Match.Sharding.OverflowShards),
+	}
 }
 
 // ============================
@@ -151,9 +180,139 @@ func (repo *UserRepoImpl) AddUserToChannel(ctx context.Context, channelId uint64
 	return nil
 }
 
-func (repo *MatchRepoImpl) PopOrPushWaitList(ctx context.Context, userId uint64) (bool, uint64, error) {
-	currentTime := time.Now().Unix()
-	match, peerIdString, err := repo.redis.ZPopMinOrAddOne(ctx, common.UserWaitListRcKey, float64(currentTime), userId)
+func (repo *UserRepoImpl) GetBlockedUserIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	response, err := repo.getBlockedUsers(ctx, &userProto.GetBlockedUsersRequest{
+		Id: userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.(*userProto.GetBlockedUsersResponse).Ids, nil
+}
+
+// PopOrPushWaitList pairs the user with the longest waiting compatible user who is not blocked, or puts the user on the wait list
+func (repo *MatchRepoImpl) PopOrPushWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error) {
+	return repo.matchOrWait(ctx, repo.shards.home(preferences), userId, preferences, blockedIds, false, "", time.Now())
+}
+
+// RetryWaitList matches a user still on the wait list again, once the user may have waited long enough to accept any peer
+func (repo *MatchRepoImpl) RetryWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error) {
+	// the retry is a no-op in the shards the user is not waiting in
+	for _, shard := range repo.shards.candidates(preferences) {
+		matched, peerId, err := repo.matchOrWait(ctx, shard, userId, preferences, blockedIds, true, "", time.Now())
+		if err != nil || matched {
+			return matched, peerId, err
+		}
+	}
+	return false, 0, nil
+}
+
+// RequeueWaitList puts a user whose proposal fell through back at the front of the wait list, unless a peer
+// is already waiting. Being at the front also means having waited long enough to accept any peer.
+func (repo *MatchRepoImpl) RequeueWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences, blockedIds []uint64) (bool, uint64, error) {
+	return repo.matchOrWait(ctx, repo.shards.home(preferences), userId, preferences, blockedIds, false, "", time.Time{})
+}
+
+// TakeLeftovers claims at most limit users who joined before joinedBefore in their shards, along with users whose
+// claim was taken before claimedBefore and never settled. Each of them must be merged into an overflow shard
+// afterwards, until then they still count as waiting when they leave. An unsharded wait list has no leftovers.
+func (repo *MatchRepoImpl) TakeLeftovers(ctx context.Context, joinedBefore time.Time, claimedBefore time.Time, limit int64) ([]*Leftover, error) {
+	if !repo.shards.isSharded() {
+		return nil, nil
+	}
+
+	var leftovers []*Leftover
+	for _, shard := range repo.shards.shards {
+		if int64(len(leftovers)) >= limit {
+			break
+		}
+
+		members, err := repo.redis.ClaimWaiting(ctx, shard.WaitListKeys, &infra.ClaimRequest{
+			MaxScore:    float64(joinedBefore.UnixMilli()),
+			Now:         float64(time.Now().UnixMilli()),
+			StaleBefore: float64(claimedBefore.UnixMilli()),
+			Limit:       limit - int64(len(leftovers)),
+		})
+		if err != nil {
+			return leftovers, err
+		}
+		for _, member := range members {
+			userId, err := strconv.ParseUint(member.Member, 10, 64)
+			if err != nil {
+				return leftovers, err
+			}
+			blockedIds := make([]uint64, 0, len(member.Excluded))
+			for _, excluded := range member.Excluded {
+				blockedId, err := strconv.ParseUint(excluded, 10, 64)
+				if err != nil {
+					return leftovers, err
+				}
+				blockedIds = append(blockedIds, blockedId)
+			}
+			leftovers = append(leftovers, &Leftover{
+				UserId:   userId,
+				JoinedAt: time.UnixMilli(int64(member.Score)),
+				Preferences: &MatchPreferences{
+					Tags:     member.Preferences.Tags,
+					Language: member.Preferences.Language,
+				},
+				BlockedIds: blockedIds,
+				shard:      shard,
+				claim:      member.Claim,
+			})
+		}
+	}
+	return leftovers, nil
+}
+
+// MergeWaitList pairs a leftover user with a compatible user left over in another shard, or keeps it waiting in
+// the overflow shard of its language, and then settles the claim. A user who left the wait list while moving is
+// not matched, and taken off the overflow shard again when the user left after arriving there. A match is
+// returned even when settling fails, the peer being off the wait list already.
+func (repo *MatchRepoImpl) MergeWaitList(ctx context.Context, leftover *Leftover) (bool, uint64, error) {
+	overflow := repo.shards.overflowOf(leftover.Preferences)
+	matched, peerId, err := repo.matchOrWait(ctx, overflow, leftover.UserId, leftover.Preferences, leftover.BlockedIds, false, leftover.claim, leftover.JoinedAt)
+	if err != nil {
+		return false, 0, err
+	}
+
+	member := strconv.FormatUint(leftover.UserId, 10)
+	left, err := repo.redis.SettleWaiting(ctx, leftover.shard.WaitListKeys, member, leftover.claim)
+	if err != nil {
+		return matched, peerId, err
+	}
+	if left && !matched {
+		if _, _, err := repo.redis.LeaveWaitList(ctx, overflow.WaitListKeys, member); err != nil {
+			return false, 0, err
+		}
+	}
+	return matched, peerId, nil
+}
+
+// matchOrWait puts a user who is not matched on the wait list shard as of joinedAt, the zero time being the front
+// of the list. The claim is set for a leftover user moving in from another shard.
+func (repo *MatchRepoImpl) matchOrWait(ctx context.Context, shard waitListShard, userId uint64, preferences *MatchPreferences, blockedIds []uint64, retry bool, claim string, joinedAt time.Time) (bool, uint64, error) {
+	excluded := make([]string, len(blockedIds))
+	for i, blockedId := range blockedIds {
+		excluded[i] = strconv.FormatUint(blockedId, 10)
+	}
+
+	now := time.Now()
+	match, peerIdString, err := repo.redis.MatchOrWait(ctx, shard.WaitListKeys, &infra.MatchRequest{
+		Member: strconv.FormatUint(userId, 10),
+		Score:  float64(max(joinedAt.UnixMilli(), 0)),
+		Preferences: infra.MatchPreferences{
+			Tags:     preferences.Tags,
+			Language: preferences.Language,
+		},
+		Now:       float64(now.UnixMilli()),
+		OpenScore: float64(now.Add(-repo.fallback).UnixMilli()),
+		Retry:     retry,
+		ScanLimit: repo.scanLimit,
+		Excluded:  excluded,
+		Claim:     claim,
+	})
 	if err != nil {
 		return false, 0, err
 	}
@@ -169,8 +328,100 @@ func (repo *MatchRepoImpl) PopOrPushWaitList(ctx context.Context, userId uint64)
 	return true, peerId, nil
 }
 
-func (repo *MatchRepoImpl) RemoveFromWaitList(ctx context.Context, userId uint64) error {
-	return repo.redis.ZRemOne(ctx, common.UserWaitListRcKey, userId)
+func (repo *MatchRepoImpl) OpenProposal(ctx context.Context, proposalId string, userIds []uint64, ttl time.Duration) error {
+	voters := make([]string, len(userIds))
+	for i, userId := range userIds {
+		voters[i] = strconv.FormatUint(userId, 10)
+	}
+	return repo.redis.OpenBallot(ctx, constructProposalKey(proposalId), voters, ttl)
+}
+
+func (repo *MatchRepoImpl) RespondProposal(ctx context.Context, proposalId string, userId uint64, accept bool) (infra.BallotStatus, map[uint64]bool, error) {
+	status, votes, err := repo.redis.CastBallot(ctx, constructProposalKey(proposalId), strconv.FormatUint(userId, 10), accept)
+	if err != nil {
+		return "", nil, err
+	}
+	return parseVotes(status, votes)
+}
+
+func (repo *MatchRepoImpl) CloseProposal(ctx context.Context, proposalId string) (infra.BallotStatus, map[uint64]bool, error) {
+	status, votes, err := repo.redis.CloseBallot(ctx, constructProposalKey(proposalId))
+	if err != nil {
+		return "", nil, err
+	}
+	return parseVotes(status, votes)
+}
+
+func parseVotes(status infra.BallotStatus, votes map[string]bool) (infra.BallotStatus, map[uint64]bool, error) {
+	userVotes := make(map[uint64]bool, len(votes))
+	for voter, accepted := range votes {
+		userId, err := strconv.ParseUint(voter, 10, 64)
+		if err != nil {
+			return "", nil, err
+		}
+		userVotes[userId] = accepted
+	}
+	return status, userVotes, nil
+}
+
+func constructProposalKey(proposalId string) string {
+	return common.Join(common.ProposalRcKey, ":", proposalId)
+}
+
+// RemoveFromWaitList takes the user off the wait list and reports whether the user was still waiting. A leftover
+// user moving to the overflow shard is still waiting, and is kept out of the overflow shard should it get there later.
+func (repo *MatchRepoImpl) RemoveFromWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences) (bool, error) {
+	member := strconv.FormatUint(userId, 10)
+	removed, claim, err := repo.redis.LeaveWaitList(ctx, repo.shards.home(preferences).WaitListKeys, member)
+	if err != nil {
+		return false, err
+	}
+	if !repo.shards.isSharded() {
+		return removed, nil
+	}
+
+	overflow := repo.shards.overflowOf(preferences)
+	removedFromOverflow, _, err := repo.redis.LeaveWaitList(ctx, overflow.WaitListKeys, member)
+	if err != nil {
+		return false, err
+	}
+	if claim != "" && !removedFromOverflow {
+		if err := repo.redis.MarkLeft(ctx, overflow.WaitListKeys, member, claim, float64(time.Now().UnixMilli())); err != nil {
+			return false, err
+		}
+	}
+	return removed || removedFromOverflow, nil
+}
+
+// GetWaitListRank returns the rank of the user in the shard the user is waiting in
+func (repo *MatchRepoImpl) GetWaitListRank(ctx context.Context, userId uint64, preferences *MatchPreferences) (int64, bool, error) {
+	for _, shard := range repo.shards.candidates(preferences) {
+		rank, waiting, err := repo.redis.ZRank(ctx, shard.List, strconv.FormatUint(userId, 10))
+		if err != nil || waiting {
+			return rank, waiting, err
+		}
+	}
+	return 0, false, nil
+}
+
+// GetRecentWaits returns how many milliseconds the last matched users spent in the shards the user may wait in
+func (repo *MatchRepoImpl) GetRecentWaits(ctx context.Context, preferences *MatchPreferences) ([]int64, error) {
+	var waits []int64
+	for _, shard := range repo.shards.candidates(preferences) {
+		values, err := repo.redis.LRange(ctx, shard.Waits, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+			wait, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			waits = append(waits, wait)
+		}
+	}
+	return waits, nil
 }
 
 func (repo *MatchRepoImpl) PublishMatchResult(ctx context.Context, result *MatchResult) error {
@@ -179,3 +430,10 @@ func (repo *MatchRepoImpl) PublishMatchResult(ctx context.Context, result *Match
 		common.Encode(result),
 	))
 }
+
+func (repo *MatchRepoImpl) PublishNotification(ctx context.Context, notification *common.Notification) error {
+	return repo.publisher.Publish(common.NotificationPubTopic, message.NewMessage(
+		watermill.NewUUID(),
+		notification.Encode(),
+	))
+}
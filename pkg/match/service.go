@@ -2,7 +2,15 @@ package match
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
 )
 
 // ============================
@@ -11,13 +19,18 @@ import (
 type UserService interface {
 	GetUserById(ctx context.Context, uid uint64) (*User, error)
 	GetUserIdBySession(ctx context.Context, sid string) (uint64, error)
-	AddUserToChannel(ctx context.Context, channelID, userID uint64) error
 }
 
 type MatchService interface {
-	Match(ctx context.Context, userId uint64) (*MatchResult, error)
+	Match(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error)
+	Rematch(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error)
+	Requeue(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error)
+	RespondProposal(ctx context.Context, proposalId string, userId uint64, accept bool) (*MatchResult, error)
+	ExpireProposal(ctx context.Context, proposalId string) (*MatchResult, error)
 	BroadcastMatchResult(ctx context.Context, result *MatchResult) error
-	RemoveUserFromWaitList(ctx context.Context, userId uint64) error
+	RemoveUserFromWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences) (bool, error)
+	GetQueueStatus(ctx context.Context, userId uint64, preferences *MatchPreferences) (*QueueStatus, bool, error)
+	RebalanceWaitList(ctx context.Context) ([]*MatchResult, error)
 }
 
 // ============================
@@ -32,12 +45,27 @@ func NewUserServiceImpl(userRepo UserRepo) *UserServiceImpl {
 }
 
 type MatchServiceImpl struct {
-	matchRepo MatchRepo
-	chanRepo  ChannelRepo
+	matchRepo      MatchRepo
+	chanRepo       ChannelRepo
+	userRepo       UserRepo
+	confirm        bool
+	confirmTimeout time.Duration
+	rebalanceDelay time.Duration
+	rebalanceBatch int64
+	claimTimeout   time.Duration
 }
 
-func NewMatchServiceImpl(matchRepo MatchRepo, chanRepo ChannelRepo) *MatchServiceImpl {
-	return &MatchServiceImpl{matchRepo, chanRepo}
+func NewMatchServiceImpl(matchRepo MatchRepo, chanRepo ChannelRepo, userRepo UserRepo, config *config.Config) *MatchServiceImpl {
+	return &MatchServiceImpl{
+		matchRepo:      matchRepo,
+		chanRepo:       chanRepo,
+		userRepo:       userRepo,
+		confirm:        config.Match.Matching.Confirm,
+		confirmTimeout: time.Duration(config.Match.Matching.ConfirmTimeoutSecond) * time.Second,
+		rebalanceDelay: time.Duration(config.Match.Sharding.RebalanceDelaySecond) * time.Second,
+		rebalanceBatch: config.Match.Sharding.RebalanceBatch,
+		claimTimeout:   time.Duration(config.Match.Sharding.ClaimTimeoutSecond) * time.Second,
+	}
 }
 
 // ============================
@@ -59,49 +87,246 @@ func (s *UserServiceImpl) GetUserIdBySession(ctx context.Context, sid string) (u
 	return userID, nil
 }
 
-func (s *UserServiceImpl) AddUserToChannel(ctx context.Context, channelID, userID uint64) error {
-	if err := s.userRepo.AddUserToChannel(ctx, channelID, userID); err != nil {
-		return fmt.Errorf("error add user %d to channel %d: %w", userID, channelID, err)
+func (s *MatchServiceImpl) Match(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error) {
+	blockedIds, err := s.userRepo.GetBlockedUserIds(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get blocked users of user %d: %w", userId, err)
 	}
-	return nil
-}
 
-func (s *MatchServiceImpl) Match(ctx context.Context, userId uint64) (*MatchResult, error) {
-	matched, peerId, err := s.matchRepo.PopOrPushWaitList(ctx, userId)
+	matched, peerId, err := s.matchRepo.PopOrPushWaitList(ctx, userId, preferences, blockedIds)
 	if err != nil {
 		return nil, fmt.Errorf("error match user %d: %w", userId, err)
 	}
 
-	if matched {
-		newChannelId, accessToken, err := s.chanRepo.CreateChannel(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("error create channel for user %d: %w", userId, err)
+	return s.newMatchResult(ctx, userId, matched, peerId)
+}
+
+// Rematch tries again for a user still waiting, who by now falls back to the global pool
+func (s *MatchServiceImpl) Rematch(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error) {
+	blockedIds, err := s.userRepo.GetBlockedUserIds(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get blocked users of user %d: %w", userId, err)
+	}
+
+	matched, peerId, err := s.matchRepo.RetryWaitList(ctx, userId, preferences, blockedIds)
+	if err != nil {
+		return nil, fmt.Errorf("error rematch user %d: %w", userId, err)
+	}
+
+	return s.newMatchResult(ctx, userId, matched, peerId)
+}
+
+// Requeue puts a user who accepted a proposal that fell through back at the front of the wait list
+func (s *MatchServiceImpl) Requeue(ctx context.Context, userId uint64, preferences *MatchPreferences) (*MatchResult, error) {
+	blockedIds, err := s.userRepo.GetBlockedUserIds(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get blocked users of user %d: %w", userId, err)
+	}
+
+	matched, peerId, err := s.matchRepo.RequeueWaitList(ctx, userId, preferences, blockedIds)
+	if err != nil {
+		return nil, fmt.Errorf("error requeue user %d: %w", userId, err)
+	}
+
+	return s.newMatchResult(ctx, userId, matched, peerId)
+}
+
+// RespondProposal records the answer of a user to a proposal. The channel is created once both users accepted,
+// and the proposal is cancelled as soon as one declines.
+func (s *MatchServiceImpl) RespondProposal(ctx context.Context, proposalId string, userId uint64, accept bool) (*MatchResult, error) {
+	status, votes, err := s.matchRepo.RespondProposal(ctx, proposalId, userId, accept)
+	if err != nil {
+		return nil, fmt.Errorf("error respond to proposal %s by user %d: %w", proposalId, userId, err)
+	}
+
+	return s.decideProposal(ctx, proposalId, status, votes)
+}
+
+// ExpireProposal cancels a proposal not accepted by both users in time
+func (s *MatchServiceImpl) ExpireProposal(ctx context.Context, proposalId string) (*MatchResult, error) {
+	status, votes, err := s.matchRepo.CloseProposal(ctx, proposalId)
+	if err != nil {
+		return nil, fmt.Errorf("error close proposal %s: %w", proposalId, err)
+	}
+
+	return s.decideProposal(ctx, proposalId, status, votes)
+}
+
+func (s *MatchServiceImpl) decideProposal(ctx context.Context, proposalId string, status infra.BallotStatus, votes map[uint64]bool) (*MatchResult, error) {
+	var userIds []uint64
+	for userId := range votes {
+		userIds = append(userIds, userId)
+	}
+	slices.Sort(userIds)
+
+	switch status {
+	case infra.BallotApproved:
+		return s.createMatch(ctx, userIds[0], userIds[1])
+	case infra.BallotRejected:
+		cancellation := &Cancellation{ProposalId: proposalId}
+		for _, userId := range userIds {
+			if votes[userId] {
+				cancellation.Requeued = append(cancellation.Requeued, userId)
+			}
 		}
+		return &MatchResult{
+			UserId:       userIds[0],
+			PeerId:       userIds[1],
+			Cancellation: cancellation,
+		}, nil
+	default:
+		return &MatchResult{}, nil
+	}
+}
 
+func (s *MatchServiceImpl) newMatchResult(ctx context.Context, userId uint64, matched bool, peerId uint64) (*MatchResult, error) {
+	if !matched {
 		return &MatchResult{
-			Matched:     true,
-			UserId:      userId,
-			PeerId:      peerId,
-			ChannelId:   newChannelId,
-			AccessToken: accessToken,
+			Matched: false,
 		}, nil
 	}
 
+	if s.confirm {
+		return s.propose(ctx, userId, peerId)
+	}
+	return s.createMatch(ctx, userId, peerId)
+}
+
+// propose asks both users to accept the match before a channel is created for them
+func (s *MatchServiceImpl) propose(ctx context.Context, userId uint64, peerId uint64) (*MatchResult, error) {
+	user, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get user %d: %w", userId, err)
+	}
+	peer, err := s.userRepo.GetUserById(ctx, peerId)
+	if err != nil {
+		return nil, fmt.Errorf("error get user %d: %w", peerId, err)
+	}
+
+	proposalId := uuid.New().String()
+	// the proposal outlives its timeout so that the expiry can still find the users who accepted
+	if err := s.matchRepo.OpenProposal(ctx, proposalId, []uint64{userId, peerId}, 2*s.confirmTimeout); err != nil {
+		return nil, fmt.Errorf("error open proposal for users %d and %d: %w", userId, peerId, err)
+	}
+
 	return &MatchResult{
-		Matched: false,
+		UserId: userId,
+		PeerId: peerId,
+		Proposal: &Proposal{
+			Id:        proposalId,
+			UserName:  user.Name,
+			PeerName:  peer.Name,
+			ExpiresAt: time.Now().Add(s.confirmTimeout).UnixMilli(),
+		},
 	}, nil
 }
 
+// createMatch creates the channel of a match with both users in it, so that the channel is theirs even when they
+// closed their match session before the result reached it
+func (s *MatchServiceImpl) createMatch(ctx context.Context, userId uint64, peerId uint64) (*MatchResult, error) {
+	newChannelId, accessToken, err := s.chanRepo.CreateChannel(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error create channel for user %d: %w", userId, err)
+	}
+	for _, memberId := range []uint64{userId, peerId} {
+		if err := s.userRepo.AddUserToChannel(ctx, newChannelId, memberId); err != nil {
+			return nil, fmt.Errorf("error add user %d to channel %d: %w", memberId, newChannelId, err)
+		}
+	}
+
+	return &MatchResult{
+		Matched:     true,
+		UserId:      userId,
+		PeerId:      peerId,
+		ChannelId:   newChannelId,
+		AccessToken: accessToken,
+	}, nil
+}
+
+// BroadcastMatchResult tells the match sessions about the result. Users also get notified of their new channel,
+// since they may have closed their match session by the time the channel is ready.
 func (s *MatchServiceImpl) BroadcastMatchResult(ctx context.Context, result *MatchResult) error {
 	if err := s.matchRepo.PublishMatchResult(ctx, result); err != nil {
 		return fmt.Errorf("error publish match result: %w", err)
 	}
+	if result.ChannelId == 0 {
+		return nil
+	}
+
+	peers := map[uint64]uint64{result.UserId: result.PeerId, result.PeerId: result.UserId}
+	for userId, peerId := range peers {
+		notification := common.NewNotification(userId, common.MatchedNotification, common.NotificationPayload{
+			ChannelId: result.ChannelId,
+			PeerId:    peerId,
+		})
+		if err := s.matchRepo.PublishNotification(ctx, notification); err != nil {
+			return fmt.Errorf("error publish match notification to user %d: %w", userId, err)
+		}
+	}
 	return nil
 }
 
-func (s *MatchServiceImpl) RemoveUserFromWaitList(ctx context.Context, userId uint64) error {
-	if err := s.matchRepo.RemoveFromWaitList(ctx, userId); err != nil {
-		return fmt.Errorf("error remove user %d from wait list: %w", userId, err)
+// RebalanceWaitList pairs users left over in their wait list shard for a while with users left over in the
+// other shards. Users claimed in their shard are always merged, even when an earlier merge failed, and users
+// whose merge did not finish are claimed again once their claim times out.
+func (s *MatchServiceImpl) RebalanceWaitList(ctx context.Context) ([]*MatchResult, error) {
+	now := time.Now()
+	leftovers, err := s.matchRepo.TakeLeftovers(ctx, now.Add(-s.rebalanceDelay), now.Add(-s.claimTimeout), s.rebalanceBatch)
+	if err != nil {
+		err = fmt.Errorf("error take wait list leftovers: %w", err)
+	}
+
+	var results []*MatchResult
+	for _, leftover := range leftovers {
+		matched, peerId, mergeErr := s.matchRepo.MergeWaitList(ctx, leftover)
+		if mergeErr != nil {
+			err = errors.Join(err, fmt.Errorf("error merge user %d into the overflow wait list: %w", leftover.UserId, mergeErr))
+			if !matched {
+				continue
+			}
+		}
+
+		result, resultErr := s.newMatchResult(ctx, leftover.UserId, matched, peerId)
+		if resultErr != nil {
+			err = errors.Join(err, resultErr)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, err
+}
+
+// RemoveUserFromWaitList reports whether the user was still waiting. A user no longer waiting has been matched.
+func (s *MatchServiceImpl) RemoveUserFromWaitList(ctx context.Context, userId uint64, preferences *MatchPreferences) (bool, error) {
+	removed, err := s.matchRepo.RemoveFromWaitList(ctx, userId, preferences)
+	if err != nil {
+		return false, fmt.Errorf("error remove user %d from wait list: %w", userId, err)
 	}
-	return nil
+	return removed, nil
+}
+
+// GetQueueStatus returns where the user stands on the wait list, and false when the user is not waiting
+func (s *MatchServiceImpl) GetQueueStatus(ctx context.Context, userId uint64, preferences *MatchPreferences) (*QueueStatus, bool, error) {
+	rank, waiting, err := s.matchRepo.GetWaitListRank(ctx, userId, preferences)
+	if err != nil {
+		return nil, false, fmt.Errorf("error get wait list rank of user %d: %w", userId, err)
+	}
+	if !waiting {
+		return nil, false, nil
+	}
+
+	waits, err := s.matchRepo.GetRecentWaits(ctx, preferences)
+	if err != nil {
+		return nil, false, fmt.Errorf("error get recent waits: %w", err)
+	}
+
+	status := &QueueStatus{Position: rank + 1}
+	if len(waits) > 0 {
+		var total int64
+		for _, wait := range waits {
+			total += wait
+		}
+		status.AverageWait = time.Duration(total/int64(len(waits))) * time.Millisecond
+	}
+	return status, true, nil
 }
@@ -0,0 +1,264 @@
+package match
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thyyl/chatr/pkg/config"
+	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/infra/infratest"
+)
+
+type fakeUserRepo struct {
+	UserRepo
+	blockedIds map[uint64][]uint64
+	mu         sync.Mutex
+	members    map[uint64][]uint64
+}
+
+func (repo *fakeUserRepo) GetBlockedUserIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	return repo.blockedIds[userId], nil
+}
+
+func (repo *fakeUserRepo) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.members == nil {
+		repo.members = make(map[uint64][]uint64)
+	}
+	repo.members[channelId] = append(repo.members[channelId], userId)
+	return nil
+}
+
+type fakeChannelRepo struct {
+	ChannelRepo
+	mu     sync.Mutex
+	lastId uint64
+}
+
+func (repo *fakeChannelRepo) CreateChannel(ctx context.Context) (uint64, string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.lastId++
+	return repo.lastId, "token", nil
+}
+
+// newTestMatchService runs the match service against a wait list in miniredis. Users become leftovers as soon as
+// they join.
+func newTestMatchService(t *testing.T, shards int, blockedIds map[uint64][]uint64, claimTimeoutSecond int64) (*MatchServiceImpl, *MatchRepoImpl, *fakeUserRepo) {
+	t.Helper()
+
+	config, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Match.Sharding.Shards = shards
+	config.Match.Sharding.RebalanceDelaySecond = 0
+	config.Match.Sharding.RebalanceBatch = 1000
+	config.Match.Sharding.ClaimTimeoutSecond = claimTimeoutSecond
+
+	client, _ := infratest.NewRedisClient(t)
+	matchRepo := NewMatchRepoImpl(infra.NewRedisCacheImpl(client), nil, config)
+	userRepo := &fakeUserRepo{blockedIds: blockedIds}
+	matchService := NewMatchServiceImpl(matchRepo, &fakeChannelRepo{}, userRepo, config)
+	return matchService, matchRepo, userRepo
+}
+
+// testCompatible mirrors the rules of the matching script for users who have not waited long enough to accept
+// any peer
+func testCompatible(a *MatchPreferences, b *MatchPreferences) bool {
+	if a.Language != "" && b.Language != "" && a.Language != b.Language {
+		return false
+	}
+	if len(a.Tags) == 0 && len(b.Tags) == 0 {
+		return true
+	}
+	for _, tag := range a.Tags {
+		if slices.Contains(b.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMatchAndRebalanceConcurrently(t *testing.T) {
+	const userNum = 300
+	random := rand.New(rand.NewPCG(1, 1))
+	pick := func(values []string) string {
+		if random.IntN(4) == 0 {
+			return ""
+		}
+		return values[random.IntN(len(values))]
+	}
+
+	preferences := make(map[uint64]*MatchPreferences, userNum)
+	for userId := uint64(1); userId <= userNum; userId++ {
+		preferences[userId] = &MatchPreferences{
+			Tags:     []string{},
+			Language: pick([]string{"en", "fr", "de"}),
+			Region:   pick([]string{"eu", "us", "asia"}),
+		}
+		if tag := pick([]string{"go", "rust", "music"}); tag != "" {
+			preferences[userId].Tags = append(preferences[userId].Tags, tag)
+		}
+	}
+	// blocks go both ways, just like the blocked users the user service returns
+	blockedIds := make(map[uint64][]uint64)
+	for userId := uint64(10); userId < userNum; userId += 10 {
+		blockedIds[userId] = append(blockedIds[userId], userId+1)
+		blockedIds[userId+1] = append(blockedIds[userId+1], userId)
+	}
+
+	matchService, _, userRepo := newTestMatchService(t, 4, blockedIds, 30)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var results []*MatchResult
+	collect := func(result *MatchResult) {
+		if result.Matched {
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for userId := uint64(1); userId <= userNum; userId++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := matchService.Match(ctx, userId, preferences[userId])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			collect(result)
+		}()
+	}
+	wg.Wait()
+
+	// several replicas rebalance at the same time
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rebalanced, err := matchService.RebalanceWaitList(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			for _, result := range rebalanced {
+				collect(result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	paired := make(map[uint64]int)
+	for _, result := range results {
+		userId, peerId := result.UserId, result.PeerId
+		switch {
+		case userId == peerId:
+			t.Errorf("user %d was matched with itself", userId)
+		case slices.Contains(blockedIds[userId], peerId):
+			t.Errorf("user %d was matched with blocked user %d", userId, peerId)
+		case !testCompatible(preferences[userId], preferences[peerId]):
+			t.Errorf("user %d was matched with incompatible user %d", userId, peerId)
+		}
+		paired[userId]++
+		paired[peerId]++
+
+		// the users are in the channel whether or not their match session is still open
+		members := userRepo.members[result.ChannelId]
+		slices.Sort(members)
+		if !slices.Equal(members, []uint64{min(userId, peerId), max(userId, peerId)}) {
+			t.Errorf("expected users %d and %d in channel %d, got %v", userId, peerId, result.ChannelId, members)
+		}
+	}
+
+	for userId := uint64(1); userId <= userNum; userId++ {
+		_, waiting, err := matchService.GetQueueStatus(ctx, userId, preferences[userId])
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case paired[userId] > 1:
+			t.Errorf("user %d was matched %d times", userId, paired[userId])
+		case paired[userId] == 1 && waiting:
+			t.Errorf("user %d is still waiting after being matched", userId)
+		case paired[userId] == 0 && !waiting:
+			t.Errorf("user %d is neither matched nor waiting", userId)
+		}
+	}
+}
+
+func TestGiveUpWhileMoving(t *testing.T) {
+	matchService, matchRepo, _ := newTestMatchService(t, 2, nil, 30)
+	ctx := context.Background()
+	preferences := &MatchPreferences{Tags: []string{}, Language: "en"}
+
+	if _, err := matchService.Match(ctx, 1, preferences); err != nil {
+		t.Fatal(err)
+	}
+	leftovers, err := matchRepo.TakeLeftovers(ctx, time.Now(), time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 1 {
+		t.Fatalf("expected user 1 to be claimed, got %d leftovers", len(leftovers))
+	}
+
+	// the user gives up between the claim and the merge
+	removed, err := matchService.RemoveUserFromWaitList(ctx, 1, preferences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("expected a moving user to still be waiting")
+	}
+
+	// a compatible user in the overflow shard is not matched with the user who left
+	overflow := matchRepo.shards.overflowOf(preferences)
+	if _, _, err := matchRepo.matchOrWait(ctx, overflow, 2, preferences, nil, false, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	matched, _, err := matchRepo.MergeWaitList(ctx, leftovers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched {
+		t.Fatal("expected the user who left not to be matched")
+	}
+	if _, waiting, err := matchService.GetQueueStatus(ctx, 1, preferences); err != nil || waiting {
+		t.Fatalf("expected the user who left not to wait, got %v %v", waiting, err)
+	}
+}
+
+func TestRebalanceTakesOverStaleClaims(t *testing.T) {
+	// an unsettled claim is taken over right away
+	matchService, matchRepo, _ := newTestMatchService(t, 2, nil, 0)
+	ctx := context.Background()
+	preferences := &MatchPreferences{Tags: []string{}, Language: "en"}
+
+	if _, err := matchService.Match(ctx, 1, preferences); err != nil {
+		t.Fatal(err)
+	}
+	// a replica claims the user and stops before merging it
+	if _, err := matchRepo.TakeLeftovers(ctx, time.Now(), time.Time{}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := matchService.RebalanceWaitList(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, waiting, err := matchService.GetQueueStatus(ctx, 1, preferences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waiting {
+		t.Fatal("expected the user of a stale claim to wait in the overflow shard")
+	}
+}
@@ -0,0 +1,95 @@
+package match
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"github.com/thyyl/chatr/pkg/infra"
+)
+
+const (
+	firstShardName = "0"
+	// overflowShardPrefix names the shards where users left over in their own shard meet users of every other shard
+	overflowShardPrefix = "overflow:"
+)
+
+// waitListShard holds the keys of one part of the wait list. The keys share a hash tag, so matching within a
+// shard runs as one script on a single cluster slot while different shards spread over the cluster.
+type waitListShard struct {
+	infra.WaitListKeys
+}
+
+// newWaitListShard names the keys of a shard. The first shard is tagged with the prefix itself, which puts it
+// in the slot of the unsharded wait list of older servers, so that their waiters can still be matched.
+func newWaitListShard(prefix string, name string) waitListShard {
+	waitList := "{" + prefix + ":" + name + "}"
+	legacy := ""
+	if name == firstShardName {
+		waitList = "{" + prefix + "}"
+		legacy = prefix
+	}
+	return waitListShard{
+		WaitListKeys: infra.WaitListKeys{
+			List:        waitList,
+			Preferences: waitList + ":prefs",
+			Waits:       waitList + ":waits",
+			Legacy:      legacy,
+		},
+	}
+}
+
+// waitListShards routes users to the shards of a wait list by their region and language, so that users who are
+// likely to be compatible wait in the same shard
+type waitListShards struct {
+	shards   []waitListShard
+	overflow []waitListShard
+}
+
+func newWaitListShards(prefix string, shardNum int, overflowShardNum int) *waitListShards {
+	shards := make([]waitListShard, max(shardNum, 1))
+	for i := range shards {
+		shards[i] = newWaitListShard(prefix, strconv.Itoa(i))
+	}
+	overflow := make([]waitListShard, max(overflowShardNum, 1))
+	for i := range overflow {
+		overflow[i] = newWaitListShard(prefix, overflowShardPrefix+strconv.Itoa(i))
+	}
+	return &waitListShards{
+		shards:   shards,
+		overflow: overflow,
+	}
+}
+
+// isSharded reports whether users are spread over several shards, an unsharded wait list has no overflow
+func (s *waitListShards) isSharded() bool {
+	return len(s.shards) > 1
+}
+
+// home returns the shard a user joins the wait list in
+func (s *waitListShards) home(preferences *MatchPreferences) waitListShard {
+	return pick(s.shards, preferences.RoutingKey())
+}
+
+// overflowOf returns the shard a user left over in the home shard moves to. Leftovers are spread by language
+// only, so that the leftovers of every region who speak the same language still meet.
+func (s *waitListShards) overflowOf(preferences *MatchPreferences) waitListShard {
+	return pick(s.overflow, preferences.Language)
+}
+
+// candidates returns the shards a waiting user may be in, starting with the home shard
+func (s *waitListShards) candidates(preferences *MatchPreferences) []waitListShard {
+	if !s.isSharded() {
+		return s.shards
+	}
+	return []waitListShard{s.home(preferences), s.overflowOf(preferences)}
+}
+
+func pick(shards []waitListShard, key string) waitListShard {
+	if len(shards) == 1 {
+		return shards[0]
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return shards[hash.Sum32()%uint32(len(shards))]
+}
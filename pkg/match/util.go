@@ -1,6 +1,14 @@
 package match
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/thyyl/chatr/pkg/common"
+)
+
+// maxTagBytes bounds a single interest tag
+const maxTagBytes = 32
 
 func DecodeToMatchResult(data []byte) (*MatchResult, error) {
 	var result MatchResult
@@ -9,3 +17,41 @@ func DecodeToMatchResult(data []byte) (*MatchResult, error) {
 	}
 	return &result, nil
 }
+
+func DecodeToProposalResponseDto(data []byte) (*ProposalResponseDto, error) {
+	var response ProposalResponseDto
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// parseMatchPreferences normalizes the comma separated tags and the language of a match request
+func parseMatchPreferences(request *MatchRequest, maxTags int) (*MatchPreferences, error) {
+	preferences := &MatchPreferences{
+		Tags:     []string{},
+		Language: strings.ToLower(strings.TrimSpace(request.Language)),
+		Region:   strings.ToLower(strings.TrimSpace(request.Region)),
+	}
+
+	seen := make(map[string]struct{})
+	for _, tag := range strings.Split(request.Tags, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxTagBytes {
+			return nil, common.ErrorInvalidParam
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		preferences.Tags = append(preferences.Tags, tag)
+	}
+	if len(preferences.Tags) > maxTags {
+		return nil, common.ErrorInvalidParam
+	}
+
+	return preferences, nil
+}
@@ -0,0 +1,129 @@
+package match
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/olahol/melody.v1"
+)
+
+// waiter follows a session while its user is on the wait list. It reports the queue status, retries the match once
+// the user falls back to the global pool and gives up after the maximum wait. A user whose proposal fell through
+// is requeued by the waiter as well.
+type waiter struct {
+	done     chan struct{}
+	requeued chan struct{}
+	stopOnce sync.Once
+}
+
+func newWaiter() *waiter {
+	return &waiter{
+		done:     make(chan struct{}),
+		requeued: make(chan struct{}, 1),
+	}
+}
+
+// requeue asks the waiter to put the user back at the front of the wait list, it never blocks
+func (w *waiter) requeue() {
+	select {
+	case w.requeued <- struct{}{}:
+	default:
+	}
+}
+
+// stop ends the wait, it is safe to call more than once
+func (w *waiter) stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (s *HttpServer) wait(session *melody.Session, w *waiter, userId uint64, preferences *MatchPreferences) {
+	joinedAt := time.Now()
+
+	ticker := time.NewTicker(s.statusInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(s.maxWait)
+	defer timeout.Stop()
+
+	// users without preferences are in the global pool from the start
+	var fallback <-chan time.Time
+	if !preferences.IsEmpty() {
+		fallbackTimer := time.NewTimer(s.fallback)
+		defer fallbackTimer.Stop()
+		fallback = fallbackTimer.C
+	}
+
+	s.sendQueueStatus(session, userId, preferences, joinedAt)
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-fallback:
+			s.rematch(userId, preferences)
+		case <-w.requeued:
+			s.requeue(userId, preferences)
+		case <-ticker.C:
+			s.sendQueueStatus(session, userId, preferences, joinedAt)
+		case <-timeout.C:
+			if s.giveUp(session, userId, preferences) {
+				return
+			}
+			// the user is in a pending proposal, which ends with a match or a requeue
+			timeout.Reset(s.statusInterval)
+		}
+	}
+}
+
+// sendQueueStatus writes the queue status of the user, skipping users off the wait list for a proposal
+func (s *HttpServer) sendQueueStatus(session *melody.Session, userId uint64, preferences *MatchPreferences, joinedAt time.Time) {
+	status, waiting, err := s.matchService.GetQueueStatus(context.Background(), userId, preferences)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if !waiting {
+		return
+	}
+
+	elapsed := time.Since(joinedAt)
+	statusDto := &QueueStatusDto{
+		Event:         EventQueueStatus,
+		Position:      status.Position,
+		ElapsedSecond: int64(elapsed / time.Second),
+	}
+	if status.AverageWait > 0 {
+		estimatedWaitSecond := int64(max(status.AverageWait-elapsed, 0) / time.Second)
+		statusDto.EstimatedWaitSecond = &estimatedWaitSecond
+	}
+
+	if err := session.Write(statusDto.Encode()); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// giveUp takes the user off the wait list after the maximum wait and reports whether it did. A user matched
+// in the meantime is left to receive the match result instead.
+func (s *HttpServer) giveUp(session *melody.Session, userId uint64, preferences *MatchPreferences) bool {
+	removed, err := s.matchService.RemoveUserFromWaitList(context.Background(), userId, preferences)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return true
+	}
+	if !removed {
+		return false
+	}
+
+	noMatchDto := &NoMatchDto{
+		Event:   EventNoMatch,
+		Message: "no match found",
+	}
+	if err := session.Write(noMatchDto.Encode()); err != nil {
+		s.logger.Error(err.Error())
+	}
+	if err := session.Close(); err != nil {
+		s.logger.Error(err.Error())
+	}
+	return true
+}
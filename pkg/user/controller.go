@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/thyyl/chatr/pkg/common"
+	"gopkg.in/olahol/melody.v1"
 )
 
 func (s *HttpServer) CreateLocalUser(context *gin.Context) {
@@ -104,6 +105,394 @@ func (s *HttpServer) GetUserMe(context *gin.Context) {
 	})
 }
 
+func (s *HttpServer) BlockUser(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var blockUserRequest BlockUserRequest
+	if err := context.ShouldBindJSON(&blockUserRequest); err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	peerId, err := strconv.ParseUint(blockUserRequest.UserId, 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.userService.BlockUser(context.Request.Context(), userId, peerId); err != nil {
+		if errors.Is(err, common.ErrorInvalidParam) {
+			common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+			return
+		}
+		if errors.Is(err, common.ErrorUserNotFound) {
+			common.Response(context, http.StatusNotFound, common.ErrorUserNotFound)
+			return
+		}
+
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) UnblockUser(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	peerId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.userService.UnblockUser(context.Request.Context(), userId, peerId); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) ListFriends(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	friends, err := s.userService.ListFriends(context.Request.Context(), userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, toUsersDto(friends))
+}
+
+func (s *HttpServer) RemoveFriend(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	friendId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.userService.RemoveFriend(context.Request.Context(), userId, friendId); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) OpenDirectChannel(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	friendId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	channel, err := s.userService.OpenDirectChannel(context.Request.Context(), userId, friendId)
+	if err != nil {
+		if errors.Is(err, common.ErrorNotFriend) {
+			common.Response(context, http.StatusForbidden, common.ErrorNotFriend)
+			return
+		}
+		if errors.Is(err, common.ErrorPermissionDenied) {
+			common.Response(context, http.StatusForbidden, common.ErrorPermissionDenied)
+			return
+		}
+
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, &ChannelDto{
+		ChannelId:   strconv.FormatUint(channel.Id, 10),
+		AccessToken: channel.AccessToken,
+	})
+}
+
+func (s *HttpServer) ListFriendRequests(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	requesters, err := s.userService.ListFriendRequests(context.Request.Context(), userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, toUsersDto(requesters))
+}
+
+func (s *HttpServer) AcceptFriendRequest(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	requesterId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.userService.AcceptFriendRequest(context.Request.Context(), userId, requesterId); err != nil {
+		if errors.Is(err, common.ErrorFriendRequestNotFound) {
+			common.Response(context, http.StatusNotFound, common.ErrorFriendRequestNotFound)
+			return
+		}
+		if errors.Is(err, common.ErrorPermissionDenied) {
+			common.Response(context, http.StatusForbidden, common.ErrorPermissionDenied)
+			return
+		}
+
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) DeclineFriendRequest(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	requesterId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.userService.DeclineFriendRequest(context.Request.Context(), userId, requesterId); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+func (s *HttpServer) ListNotifications(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var request ListNotificationsRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	notifications, err := s.notificationService.ListNotifications(context.Request.Context(), userId, request.Before)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	unreadCount, readUntil, err := s.notificationService.GetUnreadCount(context.Request.Context(), userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	notificationsDto := &NotificationsDto{
+		Notifications: []*NotificationDto{},
+		UnreadCount:   unreadCount,
+		ReadUntil:     readUntil,
+	}
+	for _, notification := range notifications {
+		notificationsDto.Notifications = append(notificationsDto.Notifications, toNotificationDto(notification))
+	}
+	context.JSON(http.StatusOK, notificationsDto)
+}
+
+func (s *HttpServer) ReadNotifications(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var request ReadNotificationsRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	if err := s.notificationService.MarkNotificationsRead(context.Request.Context(), userId, request.Until); err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, common.SuccessMessage{
+		Message: "ok",
+	})
+}
+
+// StreamNotifications upgrades to a websocket that receives the notifications of the user as they come
+func (s *HttpServer) StreamNotifications(context *gin.Context) {
+	if err := s.melodyNotification.HandleRequest(context.Writer, context.Request); err != nil {
+		s.logger.Error("upgrade websocket error" + err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+}
+
+// HandleNotificationOnConnect tells a new stream how many notifications the user missed, which the client
+// lists from the inbox
+func (s *HttpServer) HandleNotificationOnConnect(session *melody.Session) {
+	userId, ok := session.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		s.logger.Error("user session not found")
+		return
+	}
+	session.Set(common.SessionUidKey, userId)
+
+	unreadCount, readUntil, err := s.notificationService.GetUnreadCount(session.Request.Context(), userId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	unreadDto := &UnreadDto{
+		Event:       EventUnread,
+		UnreadCount: unreadCount,
+		ReadUntil:   readUntil,
+	}
+	if err := session.Write(unreadDto.Encode()); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// PublishPublicKey sets the key the members of encrypted channels use to exchange keys with the user
+func (s *HttpServer) PublishPublicKey(context *gin.Context) {
+	userId, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var request PublishPublicKeyRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	publicKey, err := s.userService.PublishPublicKey(context.Request.Context(), userId, request.PublicKey)
+	if err != nil {
+		if errors.Is(err, common.ErrorInvalidPublicKey) {
+			common.Response(context, http.StatusBadRequest, common.ErrorInvalidPublicKey)
+			return
+		}
+
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	context.JSON(http.StatusOK, publicKey.ToPresenter())
+}
+
+func (s *HttpServer) GetPublicKeys(context *gin.Context) {
+	_, ok := context.Request.Context().Value(common.UserKey).(uint64)
+	if !ok {
+		common.Response(context, http.StatusUnauthorized, common.ErrorUnauthorized)
+		return
+	}
+
+	var request GetPublicKeysRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+		return
+	}
+
+	userIds := make([]uint64, 0, len(request.Ids))
+	for _, id := range request.Ids {
+		userId, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			common.Response(context, http.StatusBadRequest, common.ErrorInvalidParam)
+			return
+		}
+		userIds = append(userIds, userId)
+	}
+
+	publicKeys, err := s.userService.GetPublicKeys(context.Request.Context(), userIds)
+	if err != nil {
+		s.logger.Error(err.Error())
+		common.Response(context, http.StatusInternalServerError, common.ErrorServer)
+		return
+	}
+
+	publicKeysDto := &PublicKeysDto{Keys: []*PublicKeyDto{}}
+	for _, publicKey := range publicKeys {
+		publicKeysDto.Keys = append(publicKeysDto.Keys, publicKey.ToPresenter())
+	}
+	context.JSON(http.StatusOK, publicKeysDto)
+}
+
+func toUsersDto(users []*User) *UsersDto {
+	usersDto := &UsersDto{Users: []UserDto{}}
+	for _, user := range users {
+		usersDto.Users = append(usersDto.Users, UserDto{
+			Id:    strconv.FormatUint(user.Id, 10),
+			Name:  user.Name,
+			Photo: user.Photo,
+		})
+	}
+	return usersDto
+}
+
 func (s *HttpServer) OAuthGoogleLogin(context *gin.Context) {
 	state, err := common.GenerateStateOauthCookie(context, s.oAuthCookieConfig.MaxAge, s.oAuthCookieConfig.Path, s.oAuthCookieConfig.Domain)
 	if err != nil {
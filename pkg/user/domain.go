@@ -1,5 +1,11 @@
 package user
 
+import (
+	"strconv"
+
+	"github.com/thyyl/chatr/pkg/common"
+)
+
 type User struct {
 	Id       uint64
 	Email    string
@@ -8,9 +14,64 @@ type User struct {
 	AuthType AuthType
 }
 
+// Friend is a user the owner befriended, along with their direct channel once one of them opened it
+type Friend struct {
+	UserId    uint64
+	ChannelId uint64
+}
+
+// PublicKey is the key a user publishes so that the members of encrypted channels can exchange keys with them.
+// It is kept base64 encoded as the client sent it.
+type PublicKey struct {
+	UserId    uint64 `json:"userId"`
+	Key       string `json:"key"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+type Channel struct {
+	Id          uint64
+	AccessToken string
+}
+
 type AuthType string
 
 const (
 	LocalAuth  AuthType = "local"
 	GoogleAuth AuthType = "google"
 )
+
+// maxPublicKeyBytes bounds a decoded public key, leaving room for post-quantum key sizes
+const maxPublicKeyBytes = 2048
+
+const (
+	EventNotification = iota
+	EventUnread
+)
+
+func (k *PublicKey) ToPresenter() *PublicKeyDto {
+	return &PublicKeyDto{
+		UserId:    strconv.FormatUint(k.UserId, 10),
+		PublicKey: k.Key,
+		UpdatedAt: k.UpdatedAt,
+	}
+}
+
+func toNotificationDto(n *common.Notification) *NotificationDto {
+	notificationDto := &NotificationDto{
+		Event:          EventNotification,
+		NotificationId: n.Id,
+		Kind:           string(n.Kind),
+		ChannelName:    n.Payload.ChannelName,
+		Time:           n.Time,
+	}
+	if n.Payload.ChannelId != 0 {
+		notificationDto.ChannelId = strconv.FormatUint(n.Payload.ChannelId, 10)
+	}
+	if n.Payload.PeerId != 0 {
+		notificationDto.PeerId = strconv.FormatUint(n.Payload.PeerId, 10)
+	}
+	if n.Payload.MessageId != 0 {
+		notificationDto.MessageId = strconv.FormatUint(n.Payload.MessageId, 10)
+	}
+	return notificationDto
+}
@@ -1,5 +1,7 @@
 package user
 
+import "encoding/json"
+
 // ============================================================
 // Request
 // ============================================================
@@ -11,6 +13,26 @@ type GetUserRequest struct {
 	Id string `form:"id" binding:"required"`
 }
 
+type ListNotificationsRequest struct {
+	Before int64 `form:"before" binding:"min=0"`
+}
+
+type ReadNotificationsRequest struct {
+	Until int64 `json:"until" binding:"required,min=1"`
+}
+
+type BlockUserRequest struct {
+	UserId string `json:"userId" binding:"required"`
+}
+
+type PublishPublicKeyRequest struct {
+	PublicKey string `json:"publicKey" binding:"required"`
+}
+
+type GetPublicKeysRequest struct {
+	Ids []string `form:"id" binding:"required,min=1,max=100"`
+}
+
 // ============================================================
 // Response
 // ============================================================
@@ -20,8 +42,62 @@ type UserDto struct {
 	Photo string `json:"photo"`
 }
 
+type UsersDto struct {
+	Users []UserDto `json:"users"`
+}
+
+type ChannelDto struct {
+	ChannelId   string `json:"channelId"`
+	AccessToken string `json:"accessToken"`
+}
+
+type PublicKeyDto struct {
+	UserId    string `json:"userId"`
+	PublicKey string `json:"publicKey"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+type PublicKeysDto struct {
+	Keys []*PublicKeyDto `json:"keys"`
+}
+
 type GoogleUserDto struct {
 	Email string `json:"email"`
 	Name  string `json:"name"`
 	Photo string `json:"photo"`
 }
+
+// NotificationDto is sent over the notification stream and listed from the inbox
+type NotificationDto struct {
+	Event          int    `json:"event"`
+	NotificationId string `json:"notificationId"`
+	Kind           string `json:"kind"`
+	ChannelId      string `json:"channelId,omitempty"`
+	ChannelName    string `json:"channelName,omitempty"`
+	PeerId         string `json:"peerId,omitempty"`
+	MessageId      string `json:"messageId,omitempty"`
+	Time           int64  `json:"time"`
+}
+
+// UnreadDto is sent when a notification stream opens, so that the client knows how much the inbox holds for it
+type UnreadDto struct {
+	Event       int   `json:"event"`
+	UnreadCount int64 `json:"unreadCount"`
+	ReadUntil   int64 `json:"readUntil"`
+}
+
+type NotificationsDto struct {
+	Notifications []*NotificationDto `json:"notifications"`
+	UnreadCount   int64              `json:"unreadCount"`
+	ReadUntil     int64              `json:"readUntil"`
+}
+
+func (n *NotificationDto) Encode() []byte {
+	result, _ := json.Marshal(n)
+	return result
+}
+
+func (u *UnreadDto) Encode() []byte {
+	result, _ := json.Marshal(u)
+	return result
+}
@@ -12,6 +12,24 @@ import (
 	"google.golang.org/grpc"
 )
 
+var (
+	ChatConn *ChatClientConn
+)
+
+type ChatClientConn struct {
+	Conn *grpc.ClientConn
+}
+
+func NewChatClientConn(config *config.Config) (*ChatClientConn, error) {
+	conn, err := transport.InitializeGrpcClient(config.Users.Grpc.Client.Chat.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	ChatConn = &ChatClientConn{Conn: conn}
+	return ChatConn, nil
+}
+
 type GrpcServer struct {
 	grpcPort    string
 	logger      common.GrpcLog
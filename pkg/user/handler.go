@@ -51,3 +51,55 @@ func (s *GrpcServer) GetUserIdBySession(ctx context.Context, request *userProto.
 		Id: userId,
 	}, nil
 }
+
+func (s *GrpcServer) UpdateLastSeen(ctx context.Context, request *userProto.UpdateLastSeenRequest) (*userProto.UpdateLastSeenResponse, error) {
+	if err := s.userService.UpdateLastSeen(ctx, request.Id, request.LastSeen); err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &userProto.UpdateLastSeenResponse{}, nil
+}
+
+func (s *GrpcServer) GetLastSeen(ctx context.Context, request *userProto.GetLastSeenRequest) (*userProto.GetLastSeenResponse, error) {
+	lastSeens, err := s.userService.GetLastSeen(ctx, request.Ids)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &userProto.GetLastSeenResponse{
+		LastSeen: lastSeens,
+	}, nil
+}
+
+func (s *GrpcServer) GetBlockedUsers(ctx context.Context, request *userProto.GetBlockedUsersRequest) (*userProto.GetBlockedUsersResponse, error) {
+	userIds, err := s.userService.GetBlockedUserIds(ctx, request.Id)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &userProto.GetBlockedUsersResponse{
+		Ids: userIds,
+	}, nil
+}
+
+func (s *GrpcServer) SendFriendRequest(ctx context.Context, request *userProto.SendFriendRequestRequest) (*userProto.SendFriendRequestResponse, error) {
+	friends, err := s.userService.SendFriendRequest(ctx, request.UserId, request.PeerId)
+	if err != nil {
+		if errors.Is(err, common.ErrorInvalidParam) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, common.ErrorPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &userProto.SendFriendRequestResponse{
+		Friends: friends,
+	}, nil
+}
@@ -11,19 +11,38 @@ import (
 	"github.com/thyyl/chatr/pkg/config"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"gopkg.in/olahol/melody.v1"
 )
 
+var (
+	MelodyNotification MelodyNotificationConn
+)
+
+type MelodyNotificationConn struct {
+	*melody.Melody
+}
+
 type HttpServer struct {
-	name              string
-	logger            common.HttpLog
-	server            *gin.Engine
-	httpServer        *http.Server
-	httpPort          string
-	serveSwag         bool
-	userService       UserService
-	googleOAuthConfig *oauth2.Config
-	oAuthCookieConfig config.CookieConfig
-	authCookieConfig  config.CookieConfig
+	name                   string
+	logger                 common.HttpLog
+	server                 *gin.Engine
+	httpServer             *http.Server
+	httpPort               string
+	serveSwag              bool
+	melodyNotification     MelodyNotificationConn
+	notificationSubscriber *NotificationSubscriber
+	userService            UserService
+	notificationService    NotificationService
+	googleOAuthConfig      *oauth2.Config
+	oAuthCookieConfig      config.CookieConfig
+	authCookieConfig       config.CookieConfig
+}
+
+func NewMelodyNotificationConn() MelodyNotificationConn {
+	MelodyNotification = MelodyNotificationConn{
+		melody.New(),
+	}
+	return MelodyNotification
 }
 
 func NewGinServer(name string, logger common.HttpLog, config *config.Config) *gin.Engine {
@@ -35,14 +54,17 @@ func NewGinServer(name string, logger common.HttpLog, config *config.Config) *gi
 	return server
 }
 
-func NewHttpServer(name string, logger common.HttpLog, config *config.Config, server *gin.Engine, userService UserService) *HttpServer {
+func NewHttpServer(name string, logger common.HttpLog, config *config.Config, server *gin.Engine, melodyNotification MelodyNotificationConn, notificationSubscriber *NotificationSubscriber, userService UserService, notificationService NotificationService) *HttpServer {
 	return &HttpServer{
-		name:        name,
-		logger:      logger,
-		server:      server,
-		httpPort:    config.Users.Http.Server.Port,
-		serveSwag:   config.Users.Http.Server.Swag,
-		userService: userService,
+		name:                   name,
+		logger:                 logger,
+		server:                 server,
+		httpPort:               config.Users.Http.Server.Port,
+		serveSwag:              config.Users.Http.Server.Swag,
+		melodyNotification:     melodyNotification,
+		notificationSubscriber: notificationSubscriber,
+		userService:            userService,
+		notificationService:    notificationService,
 		googleOAuthConfig: &oauth2.Config{
 			RedirectURL:  config.Users.OAuth.Google.RedirectUrl,
 			ClientID:     config.Users.OAuth.Google.ClientId,
@@ -56,6 +78,8 @@ func NewHttpServer(name string, logger common.HttpLog, config *config.Config, se
 }
 
 func (s *HttpServer) RegisterRoutes() {
+	s.notificationSubscriber.RegisterHandler()
+
 	userGroup := s.server.Group("/api/user")
 	{
 		userGroup.POST("/", s.CreateLocalUser)
@@ -67,7 +91,22 @@ func (s *HttpServer) RegisterRoutes() {
 		authGroup.Use(s.CookieAuth())
 		authGroup.GET("", s.GetUser)
 		authGroup.GET("/me", s.GetUserMe)
+		authGroup.POST("/blocks", s.BlockUser)
+		authGroup.DELETE("/blocks/:id", s.UnblockUser)
+		authGroup.GET("/friends", s.ListFriends)
+		authGroup.DELETE("/friends/:id", s.RemoveFriend)
+		authGroup.POST("/friends/:id/channel", s.OpenDirectChannel)
+		authGroup.GET("/friends/requests", s.ListFriendRequests)
+		authGroup.POST("/friends/requests/:id/accept", s.AcceptFriendRequest)
+		authGroup.DELETE("/friends/requests/:id", s.DeclineFriendRequest)
+		authGroup.GET("/notifications", s.ListNotifications)
+		authGroup.POST("/notifications/read", s.ReadNotifications)
+		authGroup.GET("/notifications/stream", s.StreamNotifications)
+		authGroup.PUT("/keys", s.PublishPublicKey)
+		authGroup.GET("/keys", s.GetPublicKeys)
 	}
+
+	s.melodyNotification.HandleConnect(s.HandleNotificationOnConnect)
 }
 
 func (s *HttpServer) Run() {
@@ -84,10 +123,25 @@ func (s *HttpServer) Run() {
 			os.Exit(1)
 		}
 	}()
+
+	go func() {
+		if err := s.notificationSubscriber.Run(); err != nil {
+			s.logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}()
 }
 
 func (s *HttpServer) GracefulStop(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	if err := MelodyNotification.Close(); err != nil {
+		return err
+	}
+
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	return s.notificationSubscriber.GracefulStop()
 }
 
 func (s *HttpServer) CookieAuth() gin.HandlerFunc {
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/thyyl/chatr/pkg/common"
+	"gopkg.in/olahol/melody.v1"
+)
+
+type NotificationSubscriber struct {
+	melodyNotification  MelodyNotificationConn
+	router              *message.Router
+	notificationService NotificationService
+	subscriber          message.Subscriber
+}
+
+func NewNotificationSubscriber(melodyNotification MelodyNotificationConn, router *message.Router, notificationService NotificationService, subscriber message.Subscriber) *NotificationSubscriber {
+	return &NotificationSubscriber{
+		melodyNotification:  melodyNotification,
+		router:              router,
+		notificationService: notificationService,
+		subscriber:          subscriber,
+	}
+}
+
+func (s *NotificationSubscriber) Run() error {
+	return s.router.Run(context.Background())
+}
+
+func (s *NotificationSubscriber) GracefulStop() error {
+	return s.router.Close()
+}
+
+func (s *NotificationSubscriber) RegisterHandler() {
+	s.router.AddNoPublisherHandler(
+		"chatr_notification_handler",
+		common.NotificationPubTopic,
+		s.subscriber,
+		s.HandleNotification,
+	)
+}
+
+// HandleNotification keeps the notification in the inbox of its user, for when the user is offline, and pushes it
+// to the notification streams the user holds on this server
+func (s *NotificationSubscriber) HandleNotification(message *message.Message) error {
+	notification, err := common.DecodeToNotification([]byte(message.Payload))
+	if err != nil {
+		return err
+	}
+
+	if err := s.notificationService.StoreNotification(message.Context(), notification); err != nil {
+		return err
+	}
+
+	return s.melodyNotification.BroadcastFilter(toNotificationDto(notification).Encode(), func(session *melody.Session) bool {
+		userId, exist := session.Get(common.SessionUidKey)
+		return exist && userId.(uint64) == notification.UserId
+	})
+}
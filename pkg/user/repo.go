@@ -5,16 +5,53 @@ import (
 	"encoding/json"
 	"strconv"
 
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 	"github.com/thyyl/chatr/pkg/infra"
+	"github.com/thyyl/chatr/pkg/transport"
+	chatProto "github.com/thyyl/chatr/proto/chat"
 )
 
 type UserRepo interface {
 	CreateUser(ctx context.Context, user *User) error
 	GetUserById(ctx context.Context, userId uint64) (*User, error)
+	GetUsersByIds(ctx context.Context, userIds []uint64) ([]*User, error)
 	GetUserByOAuthEmail(ctx context.Context, authType AuthType, email string) (*User, error)
 	SetUserSession(ctx context.Context, userId uint64, session string) error
 	GetUserIdBySession(ctx context.Context, session string) (uint64, error)
+	SetLastSeen(ctx context.Context, userId uint64, lastSeen int64) error
+	GetLastSeen(ctx context.Context, userId uint64) (int64, bool, error)
+	BlockUser(ctx context.Context, userId uint64, peerId uint64) error
+	UnblockUser(ctx context.Context, userId uint64, peerId uint64) error
+	GetBlockedUserIds(ctx context.Context, userId uint64) ([]uint64, error)
+	AddFriendRequest(ctx context.Context, userId uint64, peerId uint64) error
+	RemoveFriendRequest(ctx context.Context, userId uint64, peerId uint64) error
+	HasFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error)
+	GetFriendRequestIds(ctx context.Context, userId uint64) ([]uint64, error)
+	AddFriend(ctx context.Context, userId uint64, peerId uint64) error
+	RemoveFriend(ctx context.Context, userId uint64, peerId uint64) error
+	GetFriends(ctx context.Context, userId uint64) ([]*Friend, error)
+	GetFriend(ctx context.Context, userId uint64, peerId uint64) (*Friend, error)
+	SetDirectChannel(ctx context.Context, userId uint64, peerId uint64, channelId uint64) error
+	SetPublicKey(ctx context.Context, publicKey *PublicKey) error
+	GetPublicKey(ctx context.Context, userId uint64) (*PublicKey, bool, error)
+}
+
+type ChannelRepo interface {
+	CreateChannel(ctx context.Context, reusedChannelId uint64) (uint64, string, error)
+	AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error
+}
+
+type NotificationRepo interface {
+	PublishNotification(ctx context.Context, notification *common.Notification) error
+	AddToInbox(ctx context.Context, notification *common.Notification) error
+	ListInbox(ctx context.Context, userId uint64, before int64, limit int64) ([]*common.Notification, error)
+	CountInbox(ctx context.Context, userId uint64, after int64) (int64, error)
+	SetInboxReadUntil(ctx context.Context, userId uint64, until int64) error
+	GetInboxReadUntil(ctx context.Context, userId uint64) (int64, error)
 }
 
 type UserRepoImpl struct {
@@ -25,6 +62,44 @@ func NewUserRepoImpl(redis infra.RedisCache) *UserRepoImpl {
 	return &UserRepoImpl{redis}
 }
 
+type NotificationRepoImpl struct {
+	redis     infra.RedisCache
+	publisher message.Publisher
+	inboxSize int64
+}
+
+func NewNotificationRepoImpl(redis infra.RedisCache, publisher message.Publisher, config *config.Config) *NotificationRepoImpl {
+	return &NotificationRepoImpl{
+		redis:     redis,
+		publisher: publisher,
+		inboxSize: config.Users.Notification.InboxSize,
+	}
+}
+
+type ChannelRepoImpl struct {
+	createChannel    endpoint.Endpoint
+	addUserToChannel endpoint.Endpoint
+}
+
+func NewChannelRepoImpl(chatConn *ChatClientConn) *ChannelRepoImpl {
+	return &ChannelRepoImpl{
+		createChannel: transport.NewGrpcEndpoint(
+			chatConn.Conn,
+			"chat",
+			"chat.ChannelService",
+			"CreateChannel",
+			&chatProto.CreateChannelResponse{},
+		),
+		addUserToChannel: transport.NewGrpcEndpoint(
+			chatConn.Conn,
+			"chat",
+			"chat.UserService",
+			"AddUserToChannel",
+			&chatProto.AddUserResponse{},
+		),
+	}
+}
+
 func (repo *UserRepoImpl) CreateUser(ctx context.Context, user *User) error {
 	data, err := json.Marshal(user)
 
@@ -60,6 +135,33 @@ func (repo *UserRepoImpl) GetUserById(ctx context.Context, userId uint64) (*User
 	return &user, nil
 }
 
+// GetUsersByIds looks up the users in one round trip, leaving out the ones that do not exist
+func (repo *UserRepoImpl) GetUsersByIds(ctx context.Context, userIds []uint64) ([]*User, error) {
+	keys := make([]string, len(userIds))
+	for i, userId := range userIds {
+		keys[i] = constructKey(common.UserRcKey, userId)
+	}
+
+	values, err := repo.redis.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(values))
+	for _, key := range keys {
+		value, exist := values[key]
+		if !exist {
+			continue
+		}
+		var user User
+		if err := json.Unmarshal([]byte(value), &user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
 func (repo *UserRepoImpl) GetUserByOAuthEmail(ctx context.Context, authType AuthType, email string) (*User, error) {
 	var user User
 	key := constructOAuthKey(authType, email)
@@ -95,6 +197,349 @@ func (repo *UserRepoImpl) GetUserIdBySession(ctx context.Context, session string
 	return userId, nil
 }
 
+func (repo *UserRepoImpl) SetLastSeen(ctx context.Context, userId uint64, lastSeen int64) error {
+	key := constructKey(common.LastSeenRcKey, userId)
+	return repo.redis.Set(ctx, key, lastSeen)
+}
+
+func (repo *UserRepoImpl) GetLastSeen(ctx context.Context, userId uint64) (int64, bool, error) {
+	key := constructKey(common.LastSeenRcKey, userId)
+	var lastSeen int64
+
+	exist, err := repo.redis.Get(ctx, key, &lastSeen)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return lastSeen, exist, nil
+}
+
+// BlockUser records the block on both sides, so that either user can find it without scanning
+func (repo *UserRepoImpl) BlockUser(ctx context.Context, userId uint64, peerId uint64) error {
+	cmds := []infra.RedisCmd{
+		{
+			OpType: infra.SADD,
+			Payload: infra.RedisSaddPayload{
+				Key:     constructKey(common.BlocksRcKey, userId),
+				Members: []interface{}{peerId},
+			},
+		},
+		{
+			OpType: infra.SADD,
+			Payload: infra.RedisSaddPayload{
+				Key:     constructKey(common.BlockedByRcKey, peerId),
+				Members: []interface{}{userId},
+			},
+		},
+	}
+
+	return repo.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (repo *UserRepoImpl) UnblockUser(ctx context.Context, userId uint64, peerId uint64) error {
+	cmds := []infra.RedisCmd{
+		{
+			OpType: infra.SREM,
+			Payload: infra.RedisSremPayload{
+				Key:     constructKey(common.BlocksRcKey, userId),
+				Members: []interface{}{peerId},
+			},
+		},
+		{
+			OpType: infra.SREM,
+			Payload: infra.RedisSremPayload{
+				Key:     constructKey(common.BlockedByRcKey, peerId),
+				Members: []interface{}{userId},
+			},
+		},
+	}
+
+	return repo.redis.ExecPipeLine(ctx, &cmds)
+}
+
+// GetBlockedUserIds returns the users blocked by the user together with the users who blocked them
+func (repo *UserRepoImpl) GetBlockedUserIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	blocks, err := repo.redis.SMembers(ctx, constructKey(common.BlocksRcKey, userId))
+	if err != nil {
+		return nil, err
+	}
+	blockedBy, err := repo.redis.SMembers(ctx, constructKey(common.BlockedByRcKey, userId))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]struct{})
+	var userIds []uint64
+	for _, member := range append(blocks, blockedBy...) {
+		blockedId, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[blockedId]; ok {
+			continue
+		}
+		seen[blockedId] = struct{}{}
+		userIds = append(userIds, blockedId)
+	}
+
+	return userIds, nil
+}
+
+// AddFriendRequest records a friend request from the user to the peer among the requests the peer received
+func (repo *UserRepoImpl) AddFriendRequest(ctx context.Context, userId uint64, peerId uint64) error {
+	return repo.redis.SAdd(ctx, constructKey(common.FriendRequestsRcKey, peerId), userId)
+}
+
+func (repo *UserRepoImpl) RemoveFriendRequest(ctx context.Context, userId uint64, peerId uint64) error {
+	return repo.redis.SRem(ctx, constructKey(common.FriendRequestsRcKey, peerId), userId)
+}
+
+// HasFriendRequest reports whether the user sent the peer a friend request
+func (repo *UserRepoImpl) HasFriendRequest(ctx context.Context, userId uint64, peerId uint64) (bool, error) {
+	return repo.redis.SIsMember(ctx, constructKey(common.FriendRequestsRcKey, peerId), userId)
+}
+
+// GetFriendRequestIds returns the users who sent the user a friend request
+func (repo *UserRepoImpl) GetFriendRequestIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	members, err := repo.redis.SMembers(ctx, constructKey(common.FriendRequestsRcKey, userId))
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]uint64, 0, len(members))
+	for _, member := range members {
+		requesterId, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, requesterId)
+	}
+	return userIds, nil
+}
+
+// AddFriend records the friendship on both sides and settles the requests between the users. Each side maps
+// the friend to their direct channel, 0 until one of them opens it.
+func (repo *UserRepoImpl) AddFriend(ctx context.Context, userId uint64, peerId uint64) error {
+	cmds := []infra.RedisCmd{
+		{
+			OpType: infra.HSETONE,
+			Payload: infra.RedisHsetOnePayload{
+				Key:   constructKey(common.FriendsRcKey, userId),
+				Field: strconv.FormatUint(peerId, 10),
+				Val:   0,
+			},
+		},
+		{
+			OpType: infra.HSETONE,
+			Payload: infra.RedisHsetOnePayload{
+				Key:   constructKey(common.FriendsRcKey, peerId),
+				Field: strconv.FormatUint(userId, 10),
+				Val:   0,
+			},
+		},
+		{
+			OpType: infra.SREM,
+			Payload: infra.RedisSremPayload{
+				Key:     constructKey(common.FriendRequestsRcKey, userId),
+				Members: []interface{}{peerId},
+			},
+		},
+		{
+			OpType: infra.SREM,
+			Payload: infra.RedisSremPayload{
+				Key:     constructKey(common.FriendRequestsRcKey, peerId),
+				Members: []interface{}{userId},
+			},
+		},
+	}
+
+	return repo.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (repo *UserRepoImpl) RemoveFriend(ctx context.Context, userId uint64, peerId uint64) error {
+	if err := repo.redis.HDel(ctx, constructKey(common.FriendsRcKey, userId), strconv.FormatUint(peerId, 10)); err != nil {
+		return err
+	}
+	return repo.redis.HDel(ctx, constructKey(common.FriendsRcKey, peerId), strconv.FormatUint(userId, 10))
+}
+
+func (repo *UserRepoImpl) GetFriends(ctx context.Context, userId uint64) ([]*Friend, error) {
+	fields, err := repo.redis.HGetAll(ctx, constructKey(common.FriendsRcKey, userId))
+	if err != nil {
+		return nil, err
+	}
+
+	friends := make([]*Friend, 0, len(fields))
+	for field, value := range fields {
+		friend, err := parseFriend(field, value)
+		if err != nil {
+			return nil, err
+		}
+		friends = append(friends, friend)
+	}
+	return friends, nil
+}
+
+// GetFriend returns the friendship of the user with the peer, or ErrorNotFriend
+func (repo *UserRepoImpl) GetFriend(ctx context.Context, userId uint64, peerId uint64) (*Friend, error) {
+	var channelId uint64
+	exist, err := repo.redis.HGet(ctx, constructKey(common.FriendsRcKey, userId), strconv.FormatUint(peerId, 10), &channelId)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, common.ErrorNotFriend
+	}
+
+	return &Friend{
+		UserId:    peerId,
+		ChannelId: channelId,
+	}, nil
+}
+
+func (repo *UserRepoImpl) SetDirectChannel(ctx context.Context, userId uint64, peerId uint64, channelId uint64) error {
+	cmds := []infra.RedisCmd{
+		{
+			OpType: infra.HSETONE,
+			Payload: infra.RedisHsetOnePayload{
+				Key:   constructKey(common.FriendsRcKey, userId),
+				Field: strconv.FormatUint(peerId, 10),
+				Val:   channelId,
+			},
+		},
+		{
+			OpType: infra.HSETONE,
+			Payload: infra.RedisHsetOnePayload{
+				Key:   constructKey(common.FriendsRcKey, peerId),
+				Field: strconv.FormatUint(userId, 10),
+				Val:   channelId,
+			},
+		},
+	}
+
+	return repo.redis.ExecPipeLine(ctx, &cmds)
+}
+
+func (repo *UserRepoImpl) SetPublicKey(ctx context.Context, publicKey *PublicKey) error {
+	data, err := json.Marshal(publicKey)
+	if err != nil {
+		return err
+	}
+
+	key := constructKey(common.PublicKeyRcKey, publicKey.UserId)
+	return repo.redis.Set(ctx, key, data)
+}
+
+func (repo *UserRepoImpl) GetPublicKey(ctx context.Context, userId uint64) (*PublicKey, bool, error) {
+	key := constructKey(common.PublicKeyRcKey, userId)
+	var publicKey PublicKey
+
+	exist, err := repo.redis.Get(ctx, key, &publicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exist {
+		return nil, false, nil
+	}
+
+	return &publicKey, true, nil
+}
+
+func (repo *ChannelRepoImpl) CreateChannel(ctx context.Context, reusedChannelId uint64) (uint64, string, error) {
+	response, err := repo.createChannel(ctx, &chatProto.CreateChannelRequest{
+		ChannelId: reusedChannelId,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	resp := response.(*chatProto.CreateChannelResponse)
+	return resp.ChannelId, resp.AccessToken, nil
+}
+
+func (repo *ChannelRepoImpl) AddUserToChannel(ctx context.Context, channelId uint64, userId uint64) error {
+	_, err := repo.addUserToChannel(ctx, &chatProto.AddUserRequest{
+		ChannelId: channelId,
+		UserId:    userId,
+	})
+	return err
+}
+
+func parseFriend(field string, value string) (*Friend, error) {
+	userId, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	channelId, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Friend{
+		UserId:    userId,
+		ChannelId: channelId,
+	}, nil
+}
+
+func (repo *NotificationRepoImpl) PublishNotification(ctx context.Context, notification *common.Notification) error {
+	return repo.publisher.Publish(common.NotificationPubTopic, message.NewMessage(
+		watermill.NewUUID(),
+		notification.Encode(),
+	))
+}
+
+// AddToInbox keeps the notification among the latest ones of its user. Every user server consumes every
+// notification, adding the same encoded notification again leaves the inbox as it is.
+func (repo *NotificationRepoImpl) AddToInbox(ctx context.Context, notification *common.Notification) error {
+	key := constructKey(common.InboxRcKey, notification.UserId)
+	return repo.redis.ZAddAndTrim(ctx, key, float64(notification.Time), string(notification.Encode()), repo.inboxSize)
+}
+
+// ListInbox returns the latest notifications sent before the given time in milliseconds, newest first.
+// A zero before lists from the latest notification.
+func (repo *NotificationRepoImpl) ListInbox(ctx context.Context, userId uint64, before int64, limit int64) ([]*common.Notification, error) {
+	max := "+inf"
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+
+	key := constructKey(common.InboxRcKey, userId)
+	members, err := repo.redis.ZRevRangeByScore(ctx, key, max, "-inf", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]*common.Notification, 0, len(members))
+	for _, member := range members {
+		notification, err := common.DecodeToNotification([]byte(member))
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// CountInbox counts the notifications sent after the given time in milliseconds
+func (repo *NotificationRepoImpl) CountInbox(ctx context.Context, userId uint64, after int64) (int64, error) {
+	key := constructKey(common.InboxRcKey, userId)
+	return repo.redis.ZCount(ctx, key, "("+strconv.FormatInt(after, 10), "+inf")
+}
+
+func (repo *NotificationRepoImpl) SetInboxReadUntil(ctx context.Context, userId uint64, until int64) error {
+	key := constructKey(common.InboxReadRcKey, userId)
+	return repo.redis.Set(ctx, key, until)
+}
+
+func (repo *NotificationRepoImpl) GetInboxReadUntil(ctx context.Context, userId uint64) (int64, error) {
+	key := constructKey(common.InboxReadRcKey, userId)
+	var until int64
+
+	if _, err := repo.redis.Get(ctx, key, &until); err != nil {
+		return 0, err
+	}
+	return until, nil
+}
+
 func constructKey(prefix string, id uint64) string {
 	return common.Join(prefix, ":", strconv.FormatUint(id, 10))
 }
@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/thyyl/chatr/pkg/common"
+	"github.com/thyyl/chatr/pkg/config"
 )
 
 type UserService interface {
@@ -20,17 +23,56 @@ type UserService interface {
 	SetUserSession(ctx context.Context, uid uint64) (string, error)
 	GetUserById(ctx context.Context, uid uint64) (*User, error)
 	GetUserIdBySession(ctx context.Context, sid string) (uint64, error)
+	UpdateLastSeen(ctx context.Context, uid uint64, lastSeen int64) error
+	GetLastSeen(ctx context.Context, uids []uint64) (map[uint64]int64, error)
+	BlockUser(ctx context.Context, uid uint64, peerId uint64) error
+	UnblockUser(ctx context.Context, uid uint64, peerId uint64) error
+	GetBlockedUserIds(ctx context.Context, uid uint64) ([]uint64, error)
+	SendFriendRequest(ctx context.Context, uid uint64, peerId uint64) (bool, error)
+	AcceptFriendRequest(ctx context.Context, uid uint64, requesterId uint64) error
+	DeclineFriendRequest(ctx context.Context, uid uint64, requesterId uint64) error
+	ListFriendRequests(ctx context.Context, uid uint64) ([]*User, error)
+	ListFriends(ctx context.Context, uid uint64) ([]*User, error)
+	RemoveFriend(ctx context.Context, uid uint64, friendId uint64) error
+	OpenDirectChannel(ctx context.Context, uid uint64, friendId uint64) (*Channel, error)
+	PublishPublicKey(ctx context.Context, uid uint64, key string) (*PublicKey, error)
+	GetPublicKeys(ctx context.Context, uids []uint64) ([]*PublicKey, error)
+}
+
+type NotificationService interface {
+	StoreNotification(ctx context.Context, notification *common.Notification) error
+	ListNotifications(ctx context.Context, uid uint64, before int64) ([]*common.Notification, error)
+	GetUnreadCount(ctx context.Context, uid uint64) (int64, int64, error)
+	MarkNotificationsRead(ctx context.Context, uid uint64, until int64) error
 }
 
 type UserServiceImpl struct {
-	userRepo UserRepo
-	sf       common.IDGenerator
+	userRepo         UserRepo
+	channelRepo      ChannelRepo
+	notificationRepo NotificationRepo
+	sf               common.IDGenerator
+	logger           common.HttpLog
 }
 
-func NewUserServiceImpl(userRepo UserRepo, sf common.IDGenerator) *UserServiceImpl {
+func NewUserServiceImpl(userRepo UserRepo, channelRepo ChannelRepo, notificationRepo NotificationRepo, sf common.IDGenerator, logger common.HttpLog) *UserServiceImpl {
 	return &UserServiceImpl{
-		userRepo: userRepo,
-		sf:       sf,
+		userRepo:         userRepo,
+		channelRepo:      channelRepo,
+		notificationRepo: notificationRepo,
+		sf:               sf,
+		logger:           logger,
+	}
+}
+
+type NotificationServiceImpl struct {
+	notificationRepo NotificationRepo
+	pageSize         int64
+}
+
+func NewNotificationServiceImpl(notificationRepo NotificationRepo, config *config.Config) *NotificationServiceImpl {
+	return &NotificationServiceImpl{
+		notificationRepo: notificationRepo,
+		pageSize:         config.Users.Notification.PageSize,
 	}
 }
 
@@ -107,6 +149,264 @@ func (s *UserServiceImpl) GetUserIdBySession(ctx context.Context, sid string) (u
 	return userId, nil
 }
 
+func (s *UserServiceImpl) UpdateLastSeen(ctx context.Context, uid uint64, lastSeen int64) error {
+	if err := s.userRepo.SetLastSeen(ctx, uid, lastSeen); err != nil {
+		return fmt.Errorf("error set last seen of user %d: %w", uid, err)
+	}
+	return nil
+}
+
+// GetLastSeen returns the last active time in milliseconds of the given users, leaving out users never seen
+func (s *UserServiceImpl) GetLastSeen(ctx context.Context, uids []uint64) (map[uint64]int64, error) {
+	lastSeens := make(map[uint64]int64)
+	for _, uid := range uids {
+		lastSeen, exist, err := s.userRepo.GetLastSeen(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("error get last seen of user %d: %w", uid, err)
+		}
+		if exist {
+			lastSeens[uid] = lastSeen
+		}
+	}
+	return lastSeens, nil
+}
+
+// BlockUser blocks the peer for the user. Blocking ends their friendship and drops the friend requests between
+// them, so that the peer cannot reach the user through a direct channel either.
+func (s *UserServiceImpl) BlockUser(ctx context.Context, uid uint64, peerId uint64) error {
+	if uid == peerId {
+		return common.ErrorInvalidParam
+	}
+	if _, err := s.GetUserById(ctx, peerId); err != nil {
+		return err
+	}
+	if err := s.userRepo.BlockUser(ctx, uid, peerId); err != nil {
+		return fmt.Errorf("error block user %d for user %d: %w", peerId, uid, err)
+	}
+
+	if err := s.userRepo.RemoveFriend(ctx, uid, peerId); err != nil {
+		return fmt.Errorf("error remove friend %d of user %d: %w", peerId, uid, err)
+	}
+	for _, requesterId := range []uint64{uid, peerId} {
+		receiverId := uid + peerId - requesterId
+		if err := s.userRepo.RemoveFriendRequest(ctx, requesterId, receiverId); err != nil {
+			return fmt.Errorf("error remove friend request from user %d to user %d: %w", requesterId, receiverId, err)
+		}
+	}
+	return nil
+}
+
+func (s *UserServiceImpl) UnblockUser(ctx context.Context, uid uint64, peerId uint64) error {
+	if err := s.userRepo.UnblockUser(ctx, uid, peerId); err != nil {
+		return fmt.Errorf("error unblock user %d for user %d: %w", peerId, uid, err)
+	}
+	return nil
+}
+
+// GetBlockedUserIds returns the users the user must never be paired with, whichever side placed the block
+func (s *UserServiceImpl) GetBlockedUserIds(ctx context.Context, uid uint64) ([]uint64, error) {
+	userIds, err := s.userRepo.GetBlockedUserIds(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("error get blocked users of user %d: %w", uid, err)
+	}
+	return userIds, nil
+}
+
+// SendFriendRequest asks the peer to become friends and reports whether they are friends now, which is the
+// case once both users asked. Blocked users cannot befriend each other.
+func (s *UserServiceImpl) SendFriendRequest(ctx context.Context, uid uint64, peerId uint64) (bool, error) {
+	if uid == peerId {
+		return false, common.ErrorInvalidParam
+	}
+	if err := s.checkNotBlocked(ctx, uid, peerId); err != nil {
+		return false, err
+	}
+	if _, err := s.userRepo.GetFriend(ctx, uid, peerId); err == nil {
+		return true, nil
+	} else if !errors.Is(err, common.ErrorNotFriend) {
+		return false, fmt.Errorf("error get friend %d of user %d: %w", peerId, uid, err)
+	}
+
+	// the request is stored before looking for the peer's, so that two users asking at once still become friends
+	if err := s.userRepo.AddFriendRequest(ctx, uid, peerId); err != nil {
+		return false, fmt.Errorf("error add friend request from user %d to user %d: %w", uid, peerId, err)
+	}
+	requested, err := s.userRepo.HasFriendRequest(ctx, peerId, uid)
+	if err != nil {
+		return false, fmt.Errorf("error get friend request from user %d to user %d: %w", peerId, uid, err)
+	}
+	if !requested {
+		s.notify(ctx, peerId, common.FriendRequestNotification, common.NotificationPayload{PeerId: uid})
+		return false, nil
+	}
+
+	if err := s.userRepo.AddFriend(ctx, uid, peerId); err != nil {
+		return false, fmt.Errorf("error add friend %d to user %d: %w", peerId, uid, err)
+	}
+	s.notify(ctx, peerId, common.BefriendedNotification, common.NotificationPayload{PeerId: uid})
+	s.notify(ctx, uid, common.BefriendedNotification, common.NotificationPayload{PeerId: peerId})
+	return true, nil
+}
+
+// AcceptFriendRequest makes the requester a friend of the user. A request between users who blocked each other
+// since it was sent cannot be accepted.
+func (s *UserServiceImpl) AcceptFriendRequest(ctx context.Context, uid uint64, requesterId uint64) error {
+	requested, err := s.userRepo.HasFriendRequest(ctx, requesterId, uid)
+	if err != nil {
+		return fmt.Errorf("error get friend request from user %d to user %d: %w", requesterId, uid, err)
+	}
+	if !requested {
+		return common.ErrorFriendRequestNotFound
+	}
+
+	if err := s.checkNotBlocked(ctx, uid, requesterId); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.AddFriend(ctx, uid, requesterId); err != nil {
+		return fmt.Errorf("error add friend %d to user %d: %w", requesterId, uid, err)
+	}
+	s.notify(ctx, requesterId, common.BefriendedNotification, common.NotificationPayload{PeerId: uid})
+	return nil
+}
+
+func (s *UserServiceImpl) DeclineFriendRequest(ctx context.Context, uid uint64, requesterId uint64) error {
+	if err := s.userRepo.RemoveFriendRequest(ctx, requesterId, uid); err != nil {
+		return fmt.Errorf("error remove friend request from user %d to user %d: %w", requesterId, uid, err)
+	}
+	return nil
+}
+
+// ListFriendRequests returns the users waiting for the user to accept their friend request
+func (s *UserServiceImpl) ListFriendRequests(ctx context.Context, uid uint64) ([]*User, error) {
+	requesterIds, err := s.userRepo.GetFriendRequestIds(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("error get friend requests of user %d: %w", uid, err)
+	}
+	return s.getUsers(ctx, requesterIds)
+}
+
+func (s *UserServiceImpl) ListFriends(ctx context.Context, uid uint64) ([]*User, error) {
+	friends, err := s.userRepo.GetFriends(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("error get friends of user %d: %w", uid, err)
+	}
+
+	friendIds := make([]uint64, len(friends))
+	for i, friend := range friends {
+		friendIds[i] = friend.UserId
+	}
+	return s.getUsers(ctx, friendIds)
+}
+
+func (s *UserServiceImpl) RemoveFriend(ctx context.Context, uid uint64, friendId uint64) error {
+	if err := s.userRepo.RemoveFriend(ctx, uid, friendId); err != nil {
+		return fmt.Errorf("error remove friend %d of user %d: %w", friendId, uid, err)
+	}
+	return nil
+}
+
+// OpenDirectChannel returns the channel of the user with a friend. The channel is created the first time and
+// reused afterwards, unless both of them left it. Friends who blocked each other cannot open it.
+func (s *UserServiceImpl) OpenDirectChannel(ctx context.Context, uid uint64, friendId uint64) (*Channel, error) {
+	if err := s.checkNotBlocked(ctx, uid, friendId); err != nil {
+		return nil, err
+	}
+
+	friend, err := s.userRepo.GetFriend(ctx, uid, friendId)
+	if err != nil {
+		if errors.Is(err, common.ErrorNotFriend) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error get friend %d of user %d: %w", friendId, uid, err)
+	}
+
+	channelId, accessToken, err := s.channelRepo.CreateChannel(ctx, friend.ChannelId)
+	if err != nil {
+		return nil, fmt.Errorf("error create direct channel for users %d and %d: %w", uid, friendId, err)
+	}
+	// either friend may have left a reused channel, joining again is harmless for the one who did not
+	for _, userId := range []uint64{uid, friendId} {
+		if err := s.channelRepo.AddUserToChannel(ctx, channelId, userId); err != nil {
+			return nil, fmt.Errorf("error add user %d to direct channel %d: %w", userId, channelId, err)
+		}
+	}
+
+	if channelId != friend.ChannelId {
+		if err := s.userRepo.SetDirectChannel(ctx, uid, friendId, channelId); err != nil {
+			return nil, fmt.Errorf("error set direct channel of users %d and %d: %w", uid, friendId, err)
+		}
+	}
+
+	return &Channel{
+		Id:          channelId,
+		AccessToken: accessToken,
+	}, nil
+}
+
+// PublishPublicKey replaces the public key of the user. The key must be base64 encoded, the server does not look
+// any further into it.
+func (s *UserServiceImpl) PublishPublicKey(ctx context.Context, uid uint64, key string) (*PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) == 0 || len(decoded) > maxPublicKeyBytes {
+		return nil, common.ErrorInvalidPublicKey
+	}
+
+	publicKey := &PublicKey{
+		UserId:    uid,
+		Key:       key,
+		UpdatedAt: time.Now().UnixMilli(),
+	}
+	if err := s.userRepo.SetPublicKey(ctx, publicKey); err != nil {
+		return nil, fmt.Errorf("error set public key of user %d: %w", uid, err)
+	}
+	return publicKey, nil
+}
+
+// GetPublicKeys returns the public keys of the given users, leaving out users who have not published one
+func (s *UserServiceImpl) GetPublicKeys(ctx context.Context, uids []uint64) ([]*PublicKey, error) {
+	publicKeys := make([]*PublicKey, 0, len(uids))
+	for _, uid := range uids {
+		publicKey, exist, err := s.userRepo.GetPublicKey(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("error get public key of user %d: %w", uid, err)
+		}
+		if exist {
+			publicKeys = append(publicKeys, publicKey)
+		}
+	}
+	return publicKeys, nil
+}
+
+// notify only logs a failure. What the notification tells about is stored by then, so failing the request would
+// only make the client retry it.
+func (s *UserServiceImpl) notify(ctx context.Context, uid uint64, kind common.NotificationKind, payload common.NotificationPayload) {
+	if err := s.notificationRepo.PublishNotification(ctx, common.NewNotification(uid, kind, payload)); err != nil {
+		s.logger.Error(fmt.Errorf("error publish %s notification to user %d: %w", kind, uid, err).Error())
+	}
+}
+
+// getUsers looks up users, leaving out the ones that no longer exist
+// checkNotBlocked returns common.ErrorPermissionDenied when either user blocked the other
+func (s *UserServiceImpl) checkNotBlocked(ctx context.Context, uid uint64, peerId uint64) error {
+	blockedIds, err := s.GetBlockedUserIds(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(blockedIds, peerId) {
+		return common.ErrorPermissionDenied
+	}
+	return nil
+}
+
+func (s *UserServiceImpl) getUsers(ctx context.Context, uids []uint64) ([]*User, error) {
+	users, err := s.userRepo.GetUsersByIds(ctx, uids)
+	if err != nil {
+		return nil, fmt.Errorf("error get users %v: %w", uids, err)
+	}
+	return users, nil
+}
+
 func (s *UserServiceImpl) GetOrCreateUserByOAuth(ctx context.Context, user *User) (*User, error) {
 	existedUser, err := s.userRepo.GetUserByOAuthEmail(ctx, user.AuthType, user.Email)
 	if err != nil {
@@ -131,3 +431,51 @@ func (s *UserServiceImpl) GetOrCreateUserByOAuth(ctx context.Context, user *User
 	}
 	return existedUser, nil
 }
+
+func (s *NotificationServiceImpl) StoreNotification(ctx context.Context, notification *common.Notification) error {
+	if err := s.notificationRepo.AddToInbox(ctx, notification); err != nil {
+		return fmt.Errorf("error add notification %s to the inbox of user %d: %w", notification.Id, notification.UserId, err)
+	}
+	return nil
+}
+
+// ListNotifications returns a page of the inbox, newest first, starting before the given time in milliseconds
+func (s *NotificationServiceImpl) ListNotifications(ctx context.Context, uid uint64, before int64) ([]*common.Notification, error) {
+	notifications, err := s.notificationRepo.ListInbox(ctx, uid, before, s.pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("error list inbox of user %d: %w", uid, err)
+	}
+	return notifications, nil
+}
+
+// GetUnreadCount returns how many notifications of the inbox came after the ones the user read, and until when
+// the user read them
+func (s *NotificationServiceImpl) GetUnreadCount(ctx context.Context, uid uint64) (int64, int64, error) {
+	readUntil, err := s.notificationRepo.GetInboxReadUntil(ctx, uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error get inbox read cursor of user %d: %w", uid, err)
+	}
+
+	unreadCount, err := s.notificationRepo.CountInbox(ctx, uid, readUntil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error count inbox of user %d: %w", uid, err)
+	}
+	return unreadCount, readUntil, nil
+}
+
+// MarkNotificationsRead marks every notification up to the given time in milliseconds as read. The read cursor
+// never moves back.
+func (s *NotificationServiceImpl) MarkNotificationsRead(ctx context.Context, uid uint64, until int64) error {
+	readUntil, err := s.notificationRepo.GetInboxReadUntil(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("error get inbox read cursor of user %d: %w", uid, err)
+	}
+	if until <= readUntil {
+		return nil
+	}
+
+	if err := s.notificationRepo.SetInboxReadUntil(ctx, uid, until); err != nil {
+		return fmt.Errorf("error set inbox read cursor of user %d: %w", uid, err)
+	}
+	return nil
+}
@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	UserService_AddUserToChannel_FullMethodName = "/chat.UserService/AddUserToChannel"
+	UserService_AddUserToChannel_FullMethodName  = "/chat.UserService/AddUserToChannel"
+	UserService_GetChannelUserIds_FullMethodName = "/chat.UserService/GetChannelUserIds"
 )
 
 // UserServiceClient is the client API for UserService service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UserServiceClient interface {
 	AddUserToChannel(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*AddUserResponse, error)
+	GetChannelUserIds(ctx context.Context, in *GetChannelUserIdsRequest, opts ...grpc.CallOption) (*GetChannelUserIdsResponse, error)
 }
 
 type userServiceClient struct {
@@ -47,11 +49,22 @@ func (c *userServiceClient) AddUserToChannel(ctx context.Context, in *AddUserReq
 	return out, nil
 }
 
+func (c *userServiceClient) GetChannelUserIds(ctx context.Context, in *GetChannelUserIdsRequest, opts ...grpc.CallOption) (*GetChannelUserIdsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetChannelUserIdsResponse)
+	err := c.cc.Invoke(ctx, UserService_GetChannelUserIds_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UserServiceServer is the server API for UserService service.
 // All implementations must embed UnimplementedUserServiceServer
 // for forward compatibility.
 type UserServiceServer interface {
 	AddUserToChannel(context.Context, *AddUserRequest) (*AddUserResponse, error)
+	GetChannelUserIds(context.Context, *GetChannelUserIdsRequest) (*GetChannelUserIdsResponse, error)
 	mustEmbedUnimplementedUserServiceServer()
 }
 
@@ -65,6 +78,9 @@ type UnimplementedUserServiceServer struct{}
 func (UnimplementedUserServiceServer) AddUserToChannel(context.Context, *AddUserRequest) (*AddUserResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AddUserToChannel not implemented")
 }
+func (UnimplementedUserServiceServer) GetChannelUserIds(context.Context, *GetChannelUserIdsRequest) (*GetChannelUserIdsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelUserIds not implemented")
+}
 func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
 func (UnimplementedUserServiceServer) testEmbeddedByValue()                     {}
 
@@ -104,6 +120,24 @@ func _UserService_AddUserToChannel_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserService_GetChannelUserIds_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChannelUserIdsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetChannelUserIds(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetChannelUserIds_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetChannelUserIds(ctx, req.(*GetChannelUserIdsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UserService_ServiceDesc is the grpc.ServiceDesc for UserService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +149,10 @@ var UserService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AddUserToChannel",
 			Handler:    _UserService_AddUserToChannel_Handler,
 		},
+		{
+			MethodName: "GetChannelUserIds",
+			Handler:    _UserService_GetChannelUserIds_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/chat/user.proto",
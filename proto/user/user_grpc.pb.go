@@ -21,6 +21,10 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	UserService_GetUser_FullMethodName            = "/user.UserService/GetUser"
 	UserService_GetUserIdBySession_FullMethodName = "/user.UserService/GetUserIdBySession"
+	UserService_UpdateLastSeen_FullMethodName     = "/user.UserService/UpdateLastSeen"
+	UserService_GetLastSeen_FullMethodName        = "/user.UserService/GetLastSeen"
+	UserService_GetBlockedUsers_FullMethodName    = "/user.UserService/GetBlockedUsers"
+	UserService_SendFriendRequest_FullMethodName  = "/user.UserService/SendFriendRequest"
 )
 
 // UserServiceClient is the client API for UserService service.
@@ -29,6 +33,10 @@ const (
 type UserServiceClient interface {
 	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserResponse, error)
 	GetUserIdBySession(ctx context.Context, in *GetUserIdBySessionRequest, opts ...grpc.CallOption) (*GetUserIdBySessionResponse, error)
+	UpdateLastSeen(ctx context.Context, in *UpdateLastSeenRequest, opts ...grpc.CallOption) (*UpdateLastSeenResponse, error)
+	GetLastSeen(ctx context.Context, in *GetLastSeenRequest, opts ...grpc.CallOption) (*GetLastSeenResponse, error)
+	GetBlockedUsers(ctx context.Context, in *GetBlockedUsersRequest, opts ...grpc.CallOption) (*GetBlockedUsersResponse, error)
+	SendFriendRequest(ctx context.Context, in *SendFriendRequestRequest, opts ...grpc.CallOption) (*SendFriendRequestResponse, error)
 }
 
 type userServiceClient struct {
@@ -59,12 +67,56 @@ func (c *userServiceClient) GetUserIdBySession(ctx context.Context, in *GetUserI
 	return out, nil
 }
 
+func (c *userServiceClient) UpdateLastSeen(ctx context.Context, in *UpdateLastSeenRequest, opts ...grpc.CallOption) (*UpdateLastSeenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateLastSeenResponse)
+	err := c.cc.Invoke(ctx, UserService_UpdateLastSeen_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) GetLastSeen(ctx context.Context, in *GetLastSeenRequest, opts ...grpc.CallOption) (*GetLastSeenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetLastSeenResponse)
+	err := c.cc.Invoke(ctx, UserService_GetLastSeen_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) GetBlockedUsers(ctx context.Context, in *GetBlockedUsersRequest, opts ...grpc.CallOption) (*GetBlockedUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBlockedUsersResponse)
+	err := c.cc.Invoke(ctx, UserService_GetBlockedUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) SendFriendRequest(ctx context.Context, in *SendFriendRequestRequest, opts ...grpc.CallOption) (*SendFriendRequestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendFriendRequestResponse)
+	err := c.cc.Invoke(ctx, UserService_SendFriendRequest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UserServiceServer is the server API for UserService service.
 // All implementations must embed UnimplementedUserServiceServer
 // for forward compatibility.
 type UserServiceServer interface {
 	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
 	GetUserIdBySession(context.Context, *GetUserIdBySessionRequest) (*GetUserIdBySessionResponse, error)
+	UpdateLastSeen(context.Context, *UpdateLastSeenRequest) (*UpdateLastSeenResponse, error)
+	GetLastSeen(context.Context, *GetLastSeenRequest) (*GetLastSeenResponse, error)
+	GetBlockedUsers(context.Context, *GetBlockedUsersRequest) (*GetBlockedUsersResponse, error)
+	SendFriendRequest(context.Context, *SendFriendRequestRequest) (*SendFriendRequestResponse, error)
 	mustEmbedUnimplementedUserServiceServer()
 }
 
@@ -81,6 +133,18 @@ func (UnimplementedUserServiceServer) GetUser(context.Context, *GetUserRequest)
 func (UnimplementedUserServiceServer) GetUserIdBySession(context.Context, *GetUserIdBySessionRequest) (*GetUserIdBySessionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetUserIdBySession not implemented")
 }
+func (UnimplementedUserServiceServer) UpdateLastSeen(context.Context, *UpdateLastSeenRequest) (*UpdateLastSeenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateLastSeen not implemented")
+}
+func (UnimplementedUserServiceServer) GetLastSeen(context.Context, *GetLastSeenRequest) (*GetLastSeenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLastSeen not implemented")
+}
+func (UnimplementedUserServiceServer) GetBlockedUsers(context.Context, *GetBlockedUsersRequest) (*GetBlockedUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockedUsers not implemented")
+}
+func (UnimplementedUserServiceServer) SendFriendRequest(context.Context, *SendFriendRequestRequest) (*SendFriendRequestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendFriendRequest not implemented")
+}
 func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
 func (UnimplementedUserServiceServer) testEmbeddedByValue()                     {}
 
@@ -138,6 +202,78 @@ func _UserService_GetUserIdBySession_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserService_UpdateLastSeen_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateLastSeenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).UpdateLastSeen(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_UpdateLastSeen_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).UpdateLastSeen(ctx, req.(*UpdateLastSeenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_GetLastSeen_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLastSeenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetLastSeen(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetLastSeen_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetLastSeen(ctx, req.(*GetLastSeenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_GetBlockedUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlockedUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetBlockedUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetBlockedUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetBlockedUsers(ctx, req.(*GetBlockedUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_SendFriendRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendFriendRequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).SendFriendRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_SendFriendRequest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).SendFriendRequest(ctx, req.(*SendFriendRequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UserService_ServiceDesc is the grpc.ServiceDesc for UserService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -153,6 +289,22 @@ var UserService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetUserIdBySession",
 			Handler:    _UserService_GetUserIdBySession_Handler,
 		},
+		{
+			MethodName: "UpdateLastSeen",
+			Handler:    _UserService_UpdateLastSeen_Handler,
+		},
+		{
+			MethodName: "GetLastSeen",
+			Handler:    _UserService_GetLastSeen_Handler,
+		},
+		{
+			MethodName: "GetBlockedUsers",
+			Handler:    _UserService_GetBlockedUsers_Handler,
+		},
+		{
+			MethodName: "SendFriendRequest",
+			Handler:    _UserService_SendFriendRequest_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/user/user.proto",